@@ -0,0 +1,62 @@
+package bank
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// account status values
+const (
+	AccountStatusActive = "active"
+	AccountStatusFrozen = "frozen"
+	AccountStatusClosed = "closed"
+)
+
+// transaction kinds
+const (
+	TransactionKindTransfer = "transfer"
+	TransactionKindFee      = "fee"
+	TransactionKindInterest = "interest"
+)
+
+// Account account model
+type Account struct {
+	ID      uint    `gorm:"primaryKey"`
+	Balance float64 `gorm:"type:decimal(10,2)"`
+	Status  string  `gorm:"size:20;not null;default:active"`
+	Version uint    `gorm:"not null;default:0"` // bumped on every balance change, used by LockOptimistic
+	// wallet accounts are identified by a hex encoded ed25519 public key, Nonce counts their signed transfers
+	PublicKey *string `gorm:"size:64;uniqueIndex"`
+	Nonce     uint64  `gorm:"not null;default:0"`
+}
+
+// Transaction transaction model
+type Transaction struct {
+	ID            uint      `gorm:"primaryKey"`
+	BatchID       *uint     `gorm:"index"` // set when the transaction is one leg of a TransferBatch
+	FromAccountID uint      `gorm:"index"`
+	ToAccountID   uint      `gorm:"index"`
+	Amount        float64   `gorm:"type:decimal(10,2)"`
+	Kind          string    `gorm:"size:20;not null;default:transfer;index"`
+	Nonce         *uint64   // sender nonce of a signed wallet transfer
+	Signature     string    `gorm:"size:128"` // hex ed25519 signature of a signed wallet transfer
+	CreatedAt     time.Time `gorm:"index"`
+	// hash chain, see ledger.go
+	Sequence *uint64 `gorm:"uniqueIndex"`
+	PrevHash string  `gorm:"size:64"`
+	Hash     string  `gorm:"size:64;index"`
+}
+
+// AutoMigrate creates or updates the tables used by the bank package
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&Account{}, &Transaction{}, &TransferBatch{},
+		&AccountLimit{}, &TransferRejection{},
+		&ScheduledTransfer{}, &ScheduledTransferRun{},
+		&FeeSchedule{}, &FeeTier{}, &InterestAccrual{},
+		&OutboxEvent{}, &OutboxLease{},
+		&LedgerHead{}, &LedgerBlock{},
+		&Token{}, &TokenBalance{}, &TokenAllowance{}, &TokenEvent{},
+	)
+}
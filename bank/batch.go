@@ -0,0 +1,121 @@
+package bank
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ErrBatchTotalMismatch is returned when the legs of a batch do not add up to BatchRequest.ExpectedTotal
+var ErrBatchTotalMismatch = errors.New("bank: batch legs do not add up to the expected total")
+
+// TransferLeg is one money movement inside a batch
+type TransferLeg struct {
+	FromAccountID uint
+	ToAccountID   uint
+	Amount        float64
+	Kind          string // TransactionKindTransfer when empty
+
+	// set by TransferSigned only
+	nonce     *uint64
+	signature string
+	signed    bool // the key of the paying wallet authorized the leg, see WalletRule
+}
+
+// TransferBatch groups the Transaction rows written by one BatchTransfer call
+type TransferBatch struct {
+	ID           uint          `gorm:"primaryKey"`
+	Reference    string        `gorm:"size:255"`
+	LegCount     int           `gorm:"not null"`           // Transaction rows written, fee legs included
+	TotalAmount  float64       `gorm:"type:decimal(12,2)"` // sum of the requested legs, fees not included
+	Transactions []Transaction `gorm:"foreignKey:BatchID"`
+}
+
+// BatchRequest describes a set of legs executed atomically, e.g. a payroll run from one
+// account to many employees or a split payment with a fee leg
+type BatchRequest struct {
+	Reference     string  // free text stored on the batch, e.g. "payroll 2025-07"
+	ExpectedTotal float64 // when not zero the legs must add up to it exactly
+	Legs          []TransferLeg
+}
+
+// LegError reports which leg of a batch failed validation
+type LegError struct {
+	Index int
+	Err   error
+}
+
+func (e *LegError) Error() string {
+	return fmt.Sprintf("leg %d: %v", e.Index, e.Err)
+}
+
+func (e *LegError) Unwrap() error { return e.Err }
+
+// validate checks every leg and the batch total before touching the database
+func (r BatchRequest) validate() error {
+	if len(r.Legs) == 0 {
+		return ErrInvalidAmount
+	}
+	var total int64
+	for i, leg := range r.Legs {
+		if err := validateAmount(leg.Amount); err != nil {
+			return &LegError{Index: i, Err: err}
+		}
+		if leg.FromAccountID == leg.ToAccountID {
+			return &LegError{Index: i, Err: ErrSameAccount}
+		}
+		total += toCents(leg.Amount)
+	}
+	if r.ExpectedTotal != 0 && total != toCents(r.ExpectedTotal) {
+		return fmt.Errorf("%w: legs sum to %.2f, expected %.2f", ErrBatchTotalMismatch, fromCents(total), r.ExpectedTotal)
+	}
+	return nil
+}
+
+// BatchTransfer executes all legs of req in one database transaction and records one
+// TransferBatch plus one Transaction per leg, if any leg fails nothing is written
+func BatchTransfer(db *gorm.DB, req BatchRequest, opts ...TransferOption) (*TransferBatch, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	o := newTransferOptions(opts)
+	var batch *TransferBatch
+	err := withRetry(*o.retry, func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var total int64
+			for _, leg := range req.Legs {
+				total += toCents(leg.Amount)
+			}
+			b := TransferBatch{
+				Reference:   req.Reference,
+				LegCount:    len(req.Legs),
+				TotalAmount: fromCents(total),
+			}
+			if err := tx.Create(&b).Error; err != nil {
+				return err
+			}
+
+			transactions, err := postLegs(tx, o, req.Legs, &b.ID)
+			if err != nil {
+				return err
+			}
+			// postLegs adds the fee legs of a fee schedule
+			if len(transactions) != b.LegCount {
+				b.LegCount = len(transactions)
+				if err := tx.Model(&b).Update("leg_count", b.LegCount).Error; err != nil {
+					return err
+				}
+			}
+			b.Transactions = transactions
+			batch = &b
+			return nil
+		})
+	})
+	if err != nil {
+		recordRejection(db, err)
+		return nil, err
+	}
+	return batch, nil
+}
@@ -0,0 +1,46 @@
+package bank
+
+import (
+	"errors"
+	"fmt"
+)
+
+// sentinel errors returned by the transfer functions, use errors.Is to branch on them
+var (
+	ErrInvalidAmount     = errors.New("bank: invalid amount")
+	ErrSameAccount       = errors.New("bank: cannot transfer to the same account")
+	ErrAccountNotFound   = errors.New("bank: account not found")
+	ErrAccountFrozen     = errors.New("bank: account is frozen")
+	ErrAccountClosed     = errors.New("bank: account is closed")
+	ErrAccountNotEmpty   = errors.New("bank: account balance is not zero")
+	ErrInsufficientFunds = errors.New("bank: insufficient funds")
+	ErrConflict          = errors.New("bank: account was modified concurrently")
+)
+
+// AccountError reports a failure tied to a specific account,
+// Err is one of the sentinel errors above
+type AccountError struct {
+	AccountID uint
+	Err       error
+}
+
+func (e *AccountError) Error() string {
+	return fmt.Sprintf("%v (account id: %d)", e.Err, e.AccountID)
+}
+
+func (e *AccountError) Unwrap() error { return e.Err }
+
+// InsufficientFundsError carries the balance seen when the transfer was rejected,
+// it matches ErrInsufficientFunds with errors.Is
+type InsufficientFundsError struct {
+	AccountID uint
+	Balance   float64
+	Amount    float64
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("%v (account id: %d, current balance: %.2f, amount: %.2f)",
+		ErrInsufficientFunds, e.AccountID, e.Balance, e.Amount)
+}
+
+func (e *InsufficientFundsError) Is(target error) bool { return target == ErrInsufficientFunds }
@@ -0,0 +1,195 @@
+package bank
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// fee schedule kinds
+const (
+	FeeKindFlat       = "flat"
+	FeeKindPercentage = "percentage"
+	FeeKindTiered     = "tiered"
+)
+
+// ErrInvalidFeeSchedule is returned when a fee schedule is inconsistent
+var ErrInvalidFeeSchedule = errors.New("bank: invalid fee schedule")
+
+// FeeSchedule describes the fee charged to the payer of a transfer, the fee is posted as an extra
+// leg from the payer to FeeAccountID in the same database transaction
+type FeeSchedule struct {
+	ID           uint      `gorm:"primaryKey"`
+	Name         string    `gorm:"size:100;uniqueIndex"`
+	Kind         string    `gorm:"size:20;not null"`
+	FeeAccountID uint      `gorm:"not null"`
+	FlatAmount   float64   `gorm:"type:decimal(10,2);not null;default:0"` // FeeKindFlat
+	Percent      float64   `gorm:"type:decimal(9,4);not null;default:0"`  // FeeKindPercentage, 1.5 means 1.5%
+	MinFee       float64   `gorm:"type:decimal(10,2);not null;default:0"`
+	MaxFee       float64   `gorm:"type:decimal(10,2);not null;default:0"` // 0 means no cap
+	Tiers        []FeeTier `gorm:"foreignKey:FeeScheduleID"`              // FeeKindTiered
+}
+
+// FeeTier applies to amounts up to and including UpTo, the last tier may use UpTo = 0 for no bound
+type FeeTier struct {
+	ID            uint    `gorm:"primaryKey"`
+	FeeScheduleID uint    `gorm:"index"`
+	UpTo          float64 `gorm:"type:decimal(12,2);not null;default:0"`
+	FlatAmount    float64 `gorm:"type:decimal(10,2);not null;default:0"`
+	Percent       float64 `gorm:"type:decimal(9,4);not null;default:0"`
+}
+
+// decimalRat converts a float that holds a decimal column value to an exact rational,
+// using the shortest representation so 0.015 becomes exactly 15/1000
+func decimalRat(v float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(v, 'f', -1, 64))
+	return r
+}
+
+// ratToCents rounds r to whole cents, halves away from zero
+func ratToCents(r *big.Rat) int64 {
+	cents := new(big.Rat).Mul(r, big.NewRat(100, 1))
+	num, den := cents.Num(), cents.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	// compare 2*|remainder| with the denominator to round half away from zero
+	if new(big.Int).Mul(new(big.Int).Abs(m), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q.Int64()
+}
+
+// percentOf returns amount * percent / 100 exactly
+func percentOf(amount, percent float64) *big.Rat {
+	r := new(big.Rat).Mul(decimalRat(amount), decimalRat(percent))
+	return r.Quo(r, big.NewRat(100, 1))
+}
+
+// validate checks the schedule before it is stored
+func (f *FeeSchedule) validate() error {
+	if f.FeeAccountID == 0 {
+		return fmt.Errorf("%w: fee account is required", ErrInvalidFeeSchedule)
+	}
+	if f.FlatAmount < 0 || f.Percent < 0 || f.MinFee < 0 || f.MaxFee < 0 {
+		return fmt.Errorf("%w: negative amounts are not allowed", ErrInvalidFeeSchedule)
+	}
+	if f.MaxFee > 0 && f.MinFee > f.MaxFee {
+		return fmt.Errorf("%w: min fee is greater than max fee", ErrInvalidFeeSchedule)
+	}
+	switch f.Kind {
+	case FeeKindFlat, FeeKindPercentage:
+	case FeeKindTiered:
+		if len(f.Tiers) == 0 {
+			return fmt.Errorf("%w: tiered schedule without tiers", ErrInvalidFeeSchedule)
+		}
+		tiers := f.sortedTiers()
+		for i, tier := range tiers {
+			if tier.FlatAmount < 0 || tier.Percent < 0 || tier.UpTo < 0 {
+				return fmt.Errorf("%w: negative tier values", ErrInvalidFeeSchedule)
+			}
+			if tier.UpTo == 0 && i != len(tiers)-1 {
+				return fmt.Errorf("%w: only the last tier may be unbounded", ErrInvalidFeeSchedule)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidFeeSchedule, f.Kind)
+	}
+	return nil
+}
+
+// sortedTiers returns the tiers ordered by upper bound with the unbounded tier last
+func (f *FeeSchedule) sortedTiers() []FeeTier {
+	tiers := append([]FeeTier(nil), f.Tiers...)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		a, b := tiers[i].UpTo, tiers[j].UpTo
+		if a == 0 || b == 0 {
+			return b == 0 && a != 0
+		}
+		return a < b
+	})
+	return tiers
+}
+
+// Compute returns the fee for a transfer of amount, rounded to cents
+func (f *FeeSchedule) Compute(amount float64) float64 {
+	var fee *big.Rat
+	switch f.Kind {
+	case FeeKindFlat:
+		fee = decimalRat(f.FlatAmount)
+	case FeeKindPercentage:
+		fee = percentOf(amount, f.Percent)
+	case FeeKindTiered:
+		fee = new(big.Rat)
+		for _, tier := range f.sortedTiers() {
+			if tier.UpTo == 0 || toCents(amount) <= toCents(tier.UpTo) {
+				fee.Add(decimalRat(tier.FlatAmount), percentOf(amount, tier.Percent))
+				break
+			}
+		}
+	default:
+		return 0
+	}
+
+	cents := ratToCents(fee)
+	if minCents := toCents(f.MinFee); cents < minCents {
+		cents = minCents
+	}
+	if maxCents := toCents(f.MaxFee); maxCents > 0 && cents > maxCents {
+		cents = maxCents
+	}
+	return fromCents(cents)
+}
+
+// CreateFeeSchedule validates and stores a fee schedule with its tiers
+func CreateFeeSchedule(db *gorm.DB, f *FeeSchedule) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	return db.Create(f).Error
+}
+
+// GetFeeSchedule loads a fee schedule with its tiers by name
+func GetFeeSchedule(db *gorm.DB, name string) (*FeeSchedule, error) {
+	var f FeeSchedule
+	if err := db.Preload("Tiers").Where("name = ?", name).First(&f).Error; err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// WithFee charges the payer of every leg according to schedule
+func WithFee(schedule *FeeSchedule) TransferOption {
+	return func(o *transferOptions) { o.fee = schedule }
+}
+
+// appendFeeLegs adds one fee leg per transfer leg when a fee schedule is set
+func appendFeeLegs(legs []TransferLeg, schedule *FeeSchedule) []TransferLeg {
+	if schedule == nil {
+		return legs
+	}
+	withFees := append([]TransferLeg(nil), legs...)
+	for _, leg := range legs {
+		if leg.Kind != "" && leg.Kind != TransactionKindTransfer {
+			continue
+		}
+		fee := schedule.Compute(leg.Amount)
+		if fee <= 0 || leg.FromAccountID == schedule.FeeAccountID {
+			continue
+		}
+		withFees = append(withFees, TransferLeg{
+			FromAccountID: leg.FromAccountID,
+			ToAccountID:   schedule.FeeAccountID,
+			Amount:        fee,
+			Kind:          TransactionKindFee,
+			signed:        leg.signed, // the fee of a signed transfer is part of what the owner authorized
+		})
+	}
+	return withFees
+}
@@ -0,0 +1,172 @@
+package bank
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InterestPolicy configures AccrueInterest
+type InterestPolicy struct {
+	AnnualRate       float64 // percent per year, 3.5 means 3.5%
+	DaysInYear       int     // 365 when zero
+	FundingAccountID uint    // the account paying the interest, it must hold enough money
+	MinBalance       float64 // end of day balances below this earn nothing
+}
+
+// InterestAccrual records the interest of one account for one day, the unique key on
+// (account_id, day) makes the daily job idempotent
+type InterestAccrual struct {
+	ID            uint    `gorm:"primaryKey"`
+	AccountID     uint    `gorm:"uniqueIndex:idx_interest_account_day"`
+	Day           string  `gorm:"size:10;uniqueIndex:idx_interest_account_day"` // YYYY-MM-DD in local time
+	Balance       float64 `gorm:"type:decimal(12,2)"`                           // end of day balance
+	AnnualRate    float64 `gorm:"type:decimal(9,4)"`
+	Exact         string  `gorm:"size:128"` // exact interest of the day plus the carry, as a fraction
+	Posted        float64 `gorm:"type:decimal(10,2)"`
+	Carry         string  `gorm:"size:128"` // the part below one cent, added to the next day
+	TransactionID *uint
+	CreatedAt     time.Time
+}
+
+// AccrualReport summarizes one AccrueInterest run
+type AccrualReport struct {
+	Day         string
+	Accrued     int // accounts processed in this run
+	Skipped     int // accounts that already had an accrual for the day
+	TotalPosted float64
+}
+
+// AccrueInterest computes the interest of every active account for day and posts it as interest
+// transactions from the funding account. Interest is computed with exact rational arithmetic on the
+// end of day balance, only whole cents are posted and the remainder is carried to the next day.
+// Running it again for the same day skips the accounts already accrued
+func AccrueInterest(db *gorm.DB, day time.Time, policy InterestPolicy, opts ...TransferOption) (*AccrualReport, error) {
+	if policy.AnnualRate < 0 {
+		return nil, fmt.Errorf("bank: negative interest rate")
+	}
+	if policy.FundingAccountID == 0 {
+		return nil, fmt.Errorf("bank: interest funding account is required")
+	}
+	if policy.DaysInYear <= 0 {
+		policy.DaysInYear = 365
+	}
+
+	dayStart := startOfDay(day)
+	report := &AccrualReport{Day: dayStart.Format("2006-01-02")}
+
+	var ids []uint
+	if err := db.Model(&Account{}).
+		Where("status = ? AND id <> ?", AccountStatusActive, policy.FundingAccountID).
+		Order("id").Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	o := newTransferOptions(opts)
+	var total int64
+	for _, id := range ids {
+		var posted int64
+		skipped := false
+		err := withRetry(*o.retry, func() error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				posted, skipped, err = accrueAccount(tx, o, id, dayStart, report.Day, policy)
+				return err
+			})
+		})
+		if err != nil {
+			return report, fmt.Errorf("accrue interest for account %d: %w", id, err)
+		}
+		if skipped {
+			report.Skipped++
+			continue
+		}
+		report.Accrued++
+		total += posted
+		report.TotalPosted = fromCents(total)
+	}
+	return report, nil
+}
+
+// accrueAccount accrues one account for one day inside tx and returns the posted cents
+func accrueAccount(tx *gorm.DB, o transferOptions, accountID uint, dayStart time.Time, day string,
+	policy InterestPolicy) (posted int64, skipped bool, err error) {
+	// the account lock serializes concurrent runs for the same account; the funding account is
+	// locked with it, in the id order every transfer uses, so posting cannot deadlock with a transfer
+	accounts, err := lockAccounts(tx, accountID, policy.FundingAccountID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var existing int64
+	if err := tx.Model(&InterestAccrual{}).
+		Where("account_id = ? AND day = ?", accountID, day).Count(&existing).Error; err != nil {
+		return 0, false, err
+	}
+	if existing > 0 {
+		return 0, true, nil
+	}
+
+	// carry of the latest earlier day
+	carry := new(big.Rat)
+	var previous InterestAccrual
+	result := tx.Where("account_id = ? AND day < ?", accountID, day).Order("day DESC").Limit(1).Find(&previous)
+	if result.Error != nil {
+		return 0, false, result.Error
+	}
+	if result.RowsAffected > 0 && previous.Carry != "" {
+		if _, ok := carry.SetString(previous.Carry); !ok {
+			return 0, false, errors.New("bank: corrupt interest carry")
+		}
+	}
+
+	// end of day balance: current balance minus everything posted after the day
+	after, err := netChange(tx, accountID, func(q *gorm.DB) *gorm.DB {
+		return q.Where("created_at >= ?", dayStart.AddDate(0, 0, 1))
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	balance := toCents(accounts[accountID].Balance) - after
+
+	// exact = balance * rate / 100 / days + carry
+	exact := new(big.Rat).Set(carry)
+	if balance > 0 && balance >= toCents(policy.MinBalance) {
+		daily := new(big.Rat).Mul(big.NewRat(balance, 100), decimalRat(policy.AnnualRate))
+		daily.Quo(daily, big.NewRat(int64(100*policy.DaysInYear), 1))
+		exact.Add(exact, daily)
+	}
+	// post whole cents only, keep the rest for tomorrow
+	scaled := new(big.Rat).Mul(exact, big.NewRat(100, 1))
+	posted = new(big.Int).Quo(scaled.Num(), scaled.Denom()).Int64()
+	rest := new(big.Rat).Sub(exact, big.NewRat(posted, 100))
+
+	accrual := InterestAccrual{
+		AccountID:  accountID,
+		Day:        day,
+		Balance:    fromCents(balance),
+		AnnualRate: policy.AnnualRate,
+		Exact:      exact.String(),
+		Posted:     fromCents(posted),
+		Carry:      rest.String(),
+	}
+	if posted > 0 {
+		transactions, err := postLegs(tx, o, []TransferLeg{{
+			FromAccountID: policy.FundingAccountID,
+			ToAccountID:   accountID,
+			Amount:        fromCents(posted),
+			Kind:          TransactionKindInterest,
+		}}, nil)
+		if err != nil {
+			return 0, false, err
+		}
+		accrual.TransactionID = &transactions[0].ID
+	}
+	if err := tx.Create(&accrual).Error; err != nil {
+		return 0, false, err
+	}
+	return posted, false, nil
+}
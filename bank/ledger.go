@@ -0,0 +1,415 @@
+package bank
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errors of the tamper-evident ledger
+var (
+	ErrNotChained = errors.New("bank: transaction is not part of the hash chain")
+	ErrNotSealed  = errors.New("bank: transaction is not sealed into a block yet")
+)
+
+// LedgerBlockSize is the number of chained transactions sealed into one LedgerBlock.
+// Blocks store their own sequence range, so changing it only affects blocks sealed later
+var LedgerBlockSize uint64 = 64
+
+// ledgerDomain separates transaction hashes from any other sha256 use
+const ledgerDomain = "web3-ledger-tx-v1"
+
+// genesisHash is the PrevHash of the first chained transaction
+var genesisHash = strings.Repeat("0", 64)
+
+// ledgerHeadID is the id of the single LedgerHead row
+const ledgerHeadID = 1
+
+// LedgerHead is the end of the hash chain. Its row lock serializes chaining, every transfer takes
+// it last (after the account locks) right before commit, so it never takes part in a lock cycle.
+// It is a global lock: the commits of all transfers, LockOptimistic ones included, are serialized
+// on it, and the throughput of the bank is bounded by how fast one transfer commits
+type LedgerHead struct {
+	ID       uint   `gorm:"primaryKey"`
+	Sequence uint64 // sequence of the last chained transaction
+	Hash     string `gorm:"size:64"`
+	Sealed   uint64 // last sequence sealed into a block
+	Blocks   uint64 // number of sealed blocks
+}
+
+// LedgerBlock holds the merkle root of the hashes of a contiguous range of chained transactions.
+// Publishing the roots somewhere outside the database makes rewriting history detectable
+type LedgerBlock struct {
+	Number        uint64 `gorm:"primaryKey;autoIncrement:false"`
+	FirstSequence uint64
+	LastSequence  uint64 `gorm:"index"`
+	Root          string `gorm:"size:64"`
+	CreatedAt     time.Time
+}
+
+// appendString appends a length prefixed string
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// appendOptional appends a presence flag followed by the value
+func appendOptional(b []byte, present bool, v uint64) []byte {
+	if !present {
+		return append(b, 0)
+	}
+	return binary.BigEndian.AppendUint64(append(b, 1), v)
+}
+
+// transactionHash hashes the previous hash and every field of t, Sequence must be set
+func transactionHash(t *Transaction) string {
+	b := make([]byte, 0, 256)
+	b = append(b, ledgerDomain...)
+	b = appendString(b, t.PrevHash)
+	b = binary.BigEndian.AppendUint64(b, *t.Sequence)
+	b = binary.BigEndian.AppendUint64(b, uint64(t.ID))
+	var batchID uint
+	if t.BatchID != nil {
+		batchID = *t.BatchID
+	}
+	b = appendOptional(b, t.BatchID != nil, uint64(batchID))
+	b = binary.BigEndian.AppendUint64(b, uint64(t.FromAccountID))
+	b = binary.BigEndian.AppendUint64(b, uint64(t.ToAccountID))
+	b = binary.BigEndian.AppendUint64(b, uint64(toCents(t.Amount)))
+	b = appendString(b, t.Kind)
+	var nonce uint64
+	if t.Nonce != nil {
+		nonce = *t.Nonce
+	}
+	b = appendOptional(b, t.Nonce != nil, nonce)
+	b = appendString(b, t.Signature)
+	b = binary.BigEndian.AppendUint64(b, uint64(t.CreatedAt.UnixMilli()))
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// lockLedgerHead loads the chain head with a row lock, creating it on first use
+func lockLedgerHead(tx *gorm.DB) (*LedgerHead, error) {
+	var head LedgerHead
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Limit(1).Find(&head, ledgerHeadID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return &head, nil
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&LedgerHead{ID: ledgerHeadID, Hash: genesisHash}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&head, ledgerHeadID).Error; err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+// chainTransactions appends freshly created transactions to the hash chain and seals
+// full blocks, tx must be the transaction that created them
+func chainTransactions(tx *gorm.DB, transactions []Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+	head, err := lockLedgerHead(tx)
+	if err != nil {
+		return err
+	}
+	for i := range transactions {
+		t := &transactions[i]
+		sequence := head.Sequence + 1
+		t.Sequence = &sequence
+		t.PrevHash = head.Hash
+		t.Hash = transactionHash(t)
+		if err := tx.Model(&Transaction{}).Where("id = ?", t.ID).Updates(map[string]interface{}{
+			"sequence":  sequence,
+			"prev_hash": t.PrevHash,
+			"hash":      t.Hash,
+		}).Error; err != nil {
+			return err
+		}
+		head.Sequence, head.Hash = sequence, t.Hash
+	}
+	if err := sealBlocks(tx, head); err != nil {
+		return err
+	}
+	return tx.Save(head).Error
+}
+
+// sealBlocks seals every full block behind the head
+func sealBlocks(tx *gorm.DB, head *LedgerHead) error {
+	for LedgerBlockSize > 0 && head.Sequence-head.Sealed >= LedgerBlockSize {
+		first, last := head.Sealed+1, head.Sealed+LedgerBlockSize
+		leaves, err := blockLeaves(tx, first, last)
+		if err != nil {
+			return err
+		}
+		block := LedgerBlock{
+			Number:        head.Blocks + 1,
+			FirstSequence: first,
+			LastSequence:  last,
+			Root:          hex.EncodeToString(merkleRoot(leaves)),
+		}
+		if err := tx.Create(&block).Error; err != nil {
+			return err
+		}
+		head.Sealed, head.Blocks = last, block.Number
+	}
+	return nil
+}
+
+// blockLeaves loads the merkle leaves of the transactions in [first, last]
+func blockLeaves(tx *gorm.DB, first, last uint64) ([][]byte, error) {
+	var hashes []string
+	if err := tx.Model(&Transaction{}).Where("sequence BETWEEN ? AND ?", first, last).
+		Order("sequence").Pluck("hash", &hashes).Error; err != nil {
+		return nil, err
+	}
+	leaves := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		leaves[i] = leafHash(h)
+	}
+	return leaves, nil
+}
+
+// leafHash and nodeHash use different prefixes so a leaf can never pose as an inner node
+func leafHash(hash string) []byte {
+	sum := sha256.Sum256(append([]byte{0}, hash...))
+	return sum[:]
+}
+
+func nodeHash(left, right []byte) []byte {
+	b := make([]byte, 0, 1+len(left)+len(right))
+	b = append(append(append(b, 1), left...), right...)
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// merkleLevel hashes pairs of nodes, an odd last node is promoted unchanged
+func merkleLevel(nodes [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(nodes)+1)/2)
+	for i := 0; i < len(nodes); i += 2 {
+		if i+1 == len(nodes) {
+			next = append(next, nodes[i])
+			continue
+		}
+		next = append(next, nodeHash(nodes[i], nodes[i+1]))
+	}
+	return next
+}
+
+func merkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return nil
+	}
+	for len(leaves) > 1 {
+		leaves = merkleLevel(leaves)
+	}
+	return leaves[0]
+}
+
+// ProofStep is one sibling on the path from a leaf to the root
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // the sibling is the left child
+}
+
+// InclusionProof proves that a transaction, exactly as stored, is part of a sealed block
+type InclusionProof struct {
+	Transaction Transaction `json:"transaction"`
+	Block       uint64      `json:"block"`
+	Root        string      `json:"root"`
+	Steps       []ProofStep `json:"steps"`
+}
+
+// Verify recomputes the transaction hash and the path up to Root. Compare Root with a
+// root obtained independently (e.g. a published one) to rule out a rewritten database
+func (p *InclusionProof) Verify() bool {
+	t := p.Transaction
+	if t.Sequence == nil || transactionHash(&t) != t.Hash {
+		return false
+	}
+	node := leafHash(t.Hash)
+	for _, step := range p.Steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return false
+		}
+		if step.Left {
+			node = nodeHash(sibling, node)
+		} else {
+			node = nodeHash(node, sibling)
+		}
+	}
+	return hex.EncodeToString(node) == p.Root
+}
+
+// ProveTransaction builds the inclusion proof of a transaction against the root of its block
+func ProveTransaction(db *gorm.DB, transactionID uint) (*InclusionProof, error) {
+	var t Transaction
+	if err := db.First(&t, transactionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("transaction %d: %w", transactionID, gorm.ErrRecordNotFound)
+		}
+		return nil, err
+	}
+	if t.Sequence == nil {
+		return nil, ErrNotChained
+	}
+	var block LedgerBlock
+	result := db.Where("first_sequence <= ? AND last_sequence >= ?", *t.Sequence, *t.Sequence).Limit(1).Find(&block)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotSealed
+	}
+	leaves, err := blockLeaves(db, block.FirstSequence, block.LastSequence)
+	if err != nil {
+		return nil, err
+	}
+
+	proof := &InclusionProof{Transaction: t, Block: block.Number, Root: block.Root}
+	index := int(*t.Sequence - block.FirstSequence)
+	for level := leaves; len(level) > 1; level = merkleLevel(level) {
+		if sibling := index ^ 1; sibling < len(level) {
+			proof.Steps = append(proof.Steps, ProofStep{Hash: hex.EncodeToString(level[sibling]), Left: sibling < index})
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// TamperedRecord is the first inconsistency found by VerifyLedger
+type TamperedRecord struct {
+	TransactionID uint
+	Sequence      uint64
+	Block         uint64 // set when a block root does not match
+	Reason        string
+}
+
+func (r TamperedRecord) String() string {
+	if r.Block != 0 {
+		return fmt.Sprintf("block %d (from sequence %d): %s", r.Block, r.Sequence, r.Reason)
+	}
+	return fmt.Sprintf("transaction %d (sequence %d): %s", r.TransactionID, r.Sequence, r.Reason)
+}
+
+// LedgerReport is the result of VerifyLedger
+type LedgerReport struct {
+	Checked  uint64          // chained transactions checked
+	Blocks   uint64          // blocks checked
+	Legacy   int64           // transactions created before chaining was introduced
+	Tampered *TamperedRecord // nil when the ledger is intact
+}
+
+// OK reports whether no tampering was found
+func (r *LedgerReport) OK() bool { return r.Tampered == nil }
+
+// VerifyLedger walks the hash chain in sequence order and stops at the first tampered record:
+// a row whose content no longer matches its hash, a broken link (the previous row's hash was
+// rewritten), a missing sequence (deleted row), a row inserted outside the chain, a head that
+// does not match the end of the chain, or a block whose merkle root does not match its rows
+func VerifyLedger(db *gorm.DB) (*LedgerReport, error) {
+	report := &LedgerReport{}
+	var head LedgerHead
+	if err := db.Limit(1).Find(&head, ledgerHeadID).Error; err != nil {
+		return nil, err
+	}
+
+	prevHash, prevID, expected := genesisHash, uint(0), uint64(1)
+	for {
+		var transactions []Transaction
+		if err := db.Where("sequence >= ?", expected).Order("sequence").Limit(500).Find(&transactions).Error; err != nil {
+			return nil, err
+		}
+		for i := range transactions {
+			t := &transactions[i]
+			switch {
+			case *t.Sequence != expected:
+				report.Tampered = &TamperedRecord{TransactionID: t.ID, Sequence: expected,
+					Reason: fmt.Sprintf("sequence %d is missing before this record", expected)}
+			case transactionHash(t) != t.Hash:
+				report.Tampered = &TamperedRecord{TransactionID: t.ID, Sequence: expected,
+					Reason: "content does not match its hash"}
+			case t.PrevHash != prevHash:
+				report.Tampered = &TamperedRecord{TransactionID: prevID, Sequence: expected - 1,
+					Reason: fmt.Sprintf("hash was rewritten, transaction %d links to a different one", t.ID)}
+			}
+			if report.Tampered != nil {
+				return report, nil
+			}
+			prevHash, prevID = t.Hash, t.ID
+			expected++
+			report.Checked++
+		}
+		if len(transactions) < 500 {
+			break
+		}
+	}
+
+	last := expected - 1
+	switch {
+	case head.Sequence > last:
+		report.Tampered = &TamperedRecord{Sequence: last + 1,
+			Reason: fmt.Sprintf("chain ends at sequence %d but the head is at %d", last, head.Sequence)}
+	case head.Sequence < last:
+		report.Tampered = &TamperedRecord{Sequence: head.Sequence + 1,
+			Reason: fmt.Sprintf("chain continues past the head at sequence %d", head.Sequence)}
+	case last > 0 && head.Hash != prevHash:
+		report.Tampered = &TamperedRecord{TransactionID: prevID, Sequence: last,
+			Reason: "hash does not match the ledger head"}
+	}
+	if report.Tampered != nil {
+		return report, nil
+	}
+
+	// rows without a sequence are either legacy ones (older than the first chained row) or injected
+	var first Transaction
+	if err := db.Where("sequence = ?", 1).Limit(1).Find(&first).Error; err != nil {
+		return nil, err
+	}
+	if first.ID != 0 {
+		var injected Transaction
+		result := db.Where("sequence IS NULL AND id > ?", first.ID).Order("id").Limit(1).Find(&injected)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			report.Tampered = &TamperedRecord{TransactionID: injected.ID, Reason: "record was inserted outside the chain"}
+			return report, nil
+		}
+	}
+	if err := db.Model(&Transaction{}).Where("sequence IS NULL").Count(&report.Legacy).Error; err != nil {
+		return nil, err
+	}
+
+	var blocks []LedgerBlock
+	if err := db.Order("number").Find(&blocks).Error; err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		leaves, err := blockLeaves(db, block.FirstSequence, block.LastSequence)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(leaves)) != block.LastSequence-block.FirstSequence+1 ||
+			hex.EncodeToString(merkleRoot(leaves)) != block.Root {
+			report.Tampered = &TamperedRecord{Block: block.Number, Sequence: block.FirstSequence,
+				Reason: "merkle root does not match the transactions"}
+			return report, nil
+		}
+		report.Blocks++
+	}
+	return report, nil
+}
@@ -0,0 +1,311 @@
+package bank
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// outbox event types
+const EventTransactionCreated = "transaction.created"
+
+// OutboxEvent is written in the same database transaction as the change it describes,
+// the relay delivers it afterwards. EventID is the dedup key consumers should use because
+// delivery is at-least-once
+type OutboxEvent struct {
+	ID          uint   `gorm:"primaryKey"` // delivery order
+	EventID     string `gorm:"size:32;uniqueIndex"`
+	Type        string `gorm:"size:64;index"`
+	AggregateID uint
+	Payload     string `gorm:"type:text"`
+	CreatedAt   time.Time
+	SentAt      *time.Time `gorm:"index"`
+	Attempts    int
+	LastError   string `gorm:"size:255"`
+}
+
+// TransactionEvent is the payload of EventTransactionCreated
+type TransactionEvent struct {
+	TransactionID uint      `json:"transaction_id"`
+	BatchID       *uint     `json:"batch_id,omitempty"`
+	FromAccountID uint      `json:"from_account_id"`
+	ToAccountID   uint      `json:"to_account_id"`
+	Amount        float64   `json:"amount"`
+	Kind          string    `json:"kind"`
+	CreatedAt     time.Time `json:"created_at"`
+	Hash          string    `json:"hash"` // chained ledger hash
+}
+
+// newEventID returns a random 128 bit hex id
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// writeTransactionEvents adds one outbox event per transaction, tx must be the transaction
+// that created them so the events commit or roll back together with the money movement
+func writeTransactionEvents(tx *gorm.DB, transactions []Transaction) error {
+	events := make([]OutboxEvent, len(transactions))
+	for i, t := range transactions {
+		payload, err := json.Marshal(TransactionEvent{
+			TransactionID: t.ID,
+			BatchID:       t.BatchID,
+			FromAccountID: t.FromAccountID,
+			ToAccountID:   t.ToAccountID,
+			Amount:        t.Amount,
+			Kind:          t.Kind,
+			CreatedAt:     t.CreatedAt,
+			Hash:          t.Hash,
+		})
+		if err != nil {
+			return err
+		}
+		events[i] = OutboxEvent{
+			EventID:     newEventID(),
+			Type:        EventTransactionCreated,
+			AggregateID: t.ID,
+			Payload:     string(payload),
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return tx.Create(&events).Error
+}
+
+// OutboxMessage is what a sink receives
+type OutboxMessage struct {
+	EventID   string          `json:"event_id"`
+	Type      string          `json:"type"`
+	Sequence  uint            `json:"sequence"`
+	CreatedAt time.Time       `json:"created_at"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+func newOutboxMessage(e OutboxEvent) OutboxMessage {
+	return OutboxMessage{
+		EventID:   e.EventID,
+		Type:      e.Type,
+		Sequence:  e.ID,
+		CreatedAt: e.CreatedAt,
+		Payload:   json.RawMessage(e.Payload),
+	}
+}
+
+// Sink delivers outbox messages, returning nil only once the message is safely handed over.
+// The relay cancels ctx when its lease runs out, a sink should give up then
+type Sink interface {
+	Deliver(ctx context.Context, msg OutboxMessage) error
+}
+
+// ChannelSink hands messages to an in-process consumer
+type ChannelSink chan OutboxMessage
+
+func (s ChannelSink) Deliver(ctx context.Context, msg OutboxMessage) error {
+	select {
+	case s <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileSink appends messages as JSON lines to a file and syncs after every message
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens (or creates) path for appending
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Deliver(ctx context.Context, msg OutboxMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := bufio.NewWriter(s.file)
+	w.Write(line)
+	w.WriteByte('\n')
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close closes the underlying file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// WebhookSink POSTs every message as JSON to URL, the event id is also sent in the
+// Idempotency-Key header. Any 2xx response counts as delivered
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookSink creates a sink with a short request timeout
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (s *WebhookSink) Deliver(ctx context.Context, msg OutboxMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", msg.EventID)
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// OutboxLease makes sure only one relay delivers at a time, the holder renews it on every run
+// and another relay takes over once it expires
+type OutboxLease struct {
+	Name      string `gorm:"primaryKey;size:64"`
+	Owner     string `gorm:"size:32"`
+	ExpiresAt time.Time
+}
+
+// OutboxRelay delivers unsent outbox events in id order. Only the relay holding the lease delivers,
+// delivery stops at the first failure to keep the order, and an event is marked sent only after
+// the sink accepted it, so a crash in between leads to a redelivery, never a loss.
+// The lease is renewed before every event and a delivery is cancelled when it runs out, so a
+// batch may take longer than LeaseTTL but a slow event never overlaps with another relay
+type OutboxRelay struct {
+	db        *gorm.DB
+	sink      Sink
+	owner     string
+	BatchSize int
+	LeaseTTL  time.Duration
+}
+
+// NewOutboxRelay creates a relay delivering to sink
+func NewOutboxRelay(db *gorm.DB, sink Sink) *OutboxRelay {
+	return &OutboxRelay{db: db, sink: sink, owner: newEventID(), BatchSize: 100, LeaseTTL: 30 * time.Second}
+}
+
+// acquireLease takes or renews the relay lease and returns when it expires
+func (r *OutboxRelay) acquireLease() (time.Time, bool, error) {
+	now := time.Now()
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&OutboxLease{Name: "outbox", ExpiresAt: now}).Error; err != nil {
+		return time.Time{}, false, err
+	}
+	expiresAt := now.Add(r.LeaseTTL)
+	if err := r.db.Model(&OutboxLease{}).
+		Where("name = ? AND (owner = ? OR expires_at <= ?)", "outbox", r.owner, now).
+		Updates(map[string]interface{}{"owner": r.owner, "expires_at": expiresAt}).Error; err != nil {
+		return time.Time{}, false, err
+	}
+	// MySQL reports changed rows, not matched ones, so a renewal that writes the same values
+	// affects no row; read the lease back instead of trusting RowsAffected
+	var lease OutboxLease
+	if err := r.db.Where("name = ?", "outbox").Take(&lease).Error; err != nil {
+		return time.Time{}, false, err
+	}
+	return expiresAt, lease.Owner == r.owner, nil
+}
+
+// RelayOnce delivers one batch and returns how many events were sent,
+// it returns 0 without error when another relay holds the lease
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	expiresAt, ok, err := r.acquireLease()
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	var events []OutboxEvent
+	if err := r.db.Where("sent_at IS NULL").Order("id").Limit(r.BatchSize).Find(&events).Error; err != nil {
+		return 0, err
+	}
+	sent := 0
+	for i, event := range events {
+		if i > 0 {
+			// renew the lease for every event, a whole batch may take longer than LeaseTTL
+			if expiresAt, ok, err = r.acquireLease(); err != nil || !ok {
+				return sent, err
+			}
+		}
+		deliverCtx, cancel := context.WithDeadline(ctx, expiresAt)
+		err := r.sink.Deliver(deliverCtx, newOutboxMessage(event))
+		cancel()
+		if err != nil {
+			// remember the failure, the event is retried on the next run
+			r.db.Model(&OutboxEvent{}).Where("id = ?", event.ID).Updates(map[string]interface{}{
+				"attempts":   gorm.Expr("attempts + 1"),
+				"last_error": Truncate(err.Error(), 255),
+			})
+			return sent, fmt.Errorf("deliver outbox event %s: %w", event.EventID, err)
+		}
+		if err := r.db.Model(&OutboxEvent{}).Where("id = ?", event.ID).Updates(map[string]interface{}{
+			"sent_at":  time.Now(),
+			"attempts": gorm.Expr("attempts + 1"),
+		}).Error; err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// Run relays every interval until ctx is cancelled
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// drain the backlog before waiting for the next tick
+		for {
+			sent, err := r.RelayOnce(ctx)
+			if err != nil {
+				log.Printf("relay outbox events failed: %v", err)
+			}
+			if err != nil || sent < r.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
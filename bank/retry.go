@@ -0,0 +1,84 @@
+package bank
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL error numbers that mean the transaction was rolled back by the server and can be replayed
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+)
+
+// RetryPolicy controls how a transfer is replayed after a deadlock or serialization failure
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts including the first one
+	BaseDelay   time.Duration // delay before the second attempt, doubled every retry
+	MaxDelay    time.Duration // upper bound of a single delay
+}
+
+// DefaultRetryPolicy is used by TransferMoney with LockPessimistic
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   10 * time.Millisecond,
+	MaxDelay:    500 * time.Millisecond,
+}
+
+// DefaultOptimisticRetryPolicy is used by TransferMoney with LockOptimistic,
+// conflicts are cheap to replay so it retries more often with shorter delays
+var DefaultOptimisticRetryPolicy = RetryPolicy{
+	MaxAttempts: 10,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    50 * time.Millisecond,
+}
+
+// IsRetryable reports whether err is a transient concurrency failure worth retrying
+// (optimistic conflict, deadlock, lock wait timeout, serialization failure)
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrConflict) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout ||
+			string(mysqlErr.SQLState[:]) == "40001"
+	}
+	// sqlite reports lock contention only through the message
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked")
+}
+
+// backoff returns the delay before the given retry (1-based), with full jitter
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay << (retry - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// withRetry runs fn until it succeeds, fails with a non retryable error or runs out of attempts
+func withRetry(policy RetryPolicy, fn func() error) error {
+	attempts := policy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(); err == nil || !IsRetryable(err) {
+			return err
+		}
+		if attempt < attempts {
+			time.Sleep(policy.backoff(attempt))
+		}
+	}
+	return err
+}
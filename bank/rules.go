@@ -0,0 +1,281 @@
+package bank
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrRuleViolation matches every *RuleViolation with errors.Is
+var ErrRuleViolation = errors.New("bank: transfer rejected by rule")
+
+// ErrLimitExceeded is wrapped by the violations of LimitRule
+var ErrLimitExceeded = errors.New("bank: transfer limit exceeded")
+
+// RejectReason is the machine readable reason of a rule violation
+type RejectReason string
+
+// reasons produced by the built-in rules
+const (
+	ReasonAccountFrozen       RejectReason = "account_frozen"
+	ReasonAccountClosed       RejectReason = "account_closed"
+	ReasonPerTransactionLimit RejectReason = "per_transaction_limit"
+	ReasonDailyLimit          RejectReason = "daily_limit"
+	ReasonMonthlyLimit        RejectReason = "monthly_limit"
+	ReasonUnsignedDebit       RejectReason = "unsigned_debit"
+)
+
+// RuleViolation is returned when a rule rejects a transfer leg
+type RuleViolation struct {
+	Rule          string
+	Reason        RejectReason
+	AccountID     uint // the account the rule objected to
+	FromAccountID uint
+	ToAccountID   uint
+	Amount        float64
+	Err           error // optional underlying sentinel, e.g. ErrAccountFrozen
+}
+
+func (v *RuleViolation) Error() string {
+	msg := fmt.Sprintf("%v: %s/%s (account id: %d)", ErrRuleViolation, v.Rule, v.Reason, v.AccountID)
+	if v.Err != nil {
+		msg += ": " + v.Err.Error()
+	}
+	return msg
+}
+
+func (v *RuleViolation) Is(target error) bool { return target == ErrRuleViolation }
+
+func (v *RuleViolation) Unwrap() error { return v.Err }
+
+// AccountLimit holds the outbound limits of an account, zero means unlimited
+type AccountLimit struct {
+	AccountID         uint    `gorm:"primaryKey;autoIncrement:false"`
+	MaxPerTransaction float64 `gorm:"type:decimal(10,2);not null;default:0"`
+	DailyOutLimit     float64 `gorm:"type:decimal(12,2);not null;default:0"`
+	MonthlyOutLimit   float64 `gorm:"type:decimal(12,2);not null;default:0"`
+}
+
+// TransferRejection is the audit record of a transfer rejected by a rule
+type TransferRejection struct {
+	ID            uint `gorm:"primaryKey"`
+	FromAccountID uint `gorm:"index"`
+	ToAccountID   uint
+	AccountID     uint
+	Amount        float64 `gorm:"type:decimal(10,2)"`
+	Rule          string  `gorm:"size:64"`
+	Reason        string  `gorm:"size:64;index"`
+	Message       string  `gorm:"size:255"`
+	CreatedAt     time.Time
+}
+
+// RuleContext is what a rule sees when it evaluates one leg inside the transfer transaction
+type RuleContext struct {
+	Tx      *gorm.DB
+	From    *Account
+	To      *Account
+	Amount  float64
+	Pending float64 // outflow of From in earlier legs of the same batch, not yet in the database
+	Signed  bool    // the leg was authorized by the key of From, only TransferSigned sets it
+	Now     time.Time
+}
+
+// Rule decides whether a transfer leg may proceed, it returns nil or a *RuleViolation
+type Rule interface {
+	Name() string
+	Check(ctx *RuleContext) error
+}
+
+// RuleEngine evaluates its rules in order and stops at the first violation
+type RuleEngine struct {
+	rules []Rule
+}
+
+// NewRuleEngine creates an engine, StatusRule and WalletRule always run first so frozen and
+// closed accounts and wallets can never be bypassed by a custom rule set
+func NewRuleEngine(rules ...Rule) *RuleEngine {
+	return &RuleEngine{rules: append([]Rule{StatusRule{}, WalletRule{}}, rules...)}
+}
+
+// Use appends rules to the engine, it is not safe to call while transfers are running
+func (e *RuleEngine) Use(rules ...Rule) {
+	e.rules = append(e.rules, rules...)
+}
+
+// DefaultRuleEngine is used by transfers that do not pass WithRuleEngine
+var DefaultRuleEngine = NewRuleEngine(LimitRule{})
+
+// evaluate runs the rules on every leg
+func (e *RuleEngine) evaluate(tx *gorm.DB, accounts map[uint]*Account, legs []TransferLeg) error {
+	now := time.Now()
+	pending := make(map[uint]int64)
+	for _, leg := range legs {
+		ctx := &RuleContext{
+			Tx:      tx,
+			From:    accounts[leg.FromAccountID],
+			To:      accounts[leg.ToAccountID],
+			Amount:  leg.Amount,
+			Pending: fromCents(pending[leg.FromAccountID]),
+			Signed:  leg.signed,
+			Now:     now,
+		}
+		for _, rule := range e.rules {
+			if err := rule.Check(ctx); err != nil {
+				var violation *RuleViolation
+				if errors.As(err, &violation) {
+					violation.Rule = rule.Name()
+					violation.FromAccountID = leg.FromAccountID
+					violation.ToAccountID = leg.ToAccountID
+					violation.Amount = leg.Amount
+				}
+				return err
+			}
+		}
+		pending[leg.FromAccountID] += toCents(leg.Amount)
+	}
+	return nil
+}
+
+// recordRejection stores a rule violation for audit, the transfer transaction has already been
+// rolled back so it is written on its own
+func recordRejection(db *gorm.DB, err error) {
+	var violation *RuleViolation
+	if !errors.As(err, &violation) {
+		return
+	}
+	rejection := TransferRejection{
+		FromAccountID: violation.FromAccountID,
+		ToAccountID:   violation.ToAccountID,
+		AccountID:     violation.AccountID,
+		Amount:        violation.Amount,
+		Rule:          violation.Rule,
+		Reason:        string(violation.Reason),
+		Message:       violation.Error(),
+	}
+	if err := db.Create(&rejection).Error; err != nil {
+		log.Printf("record transfer rejection failed: %v", err)
+	}
+}
+
+// StatusRule rejects legs touching frozen or closed accounts
+type StatusRule struct{}
+
+func (StatusRule) Name() string { return "status" }
+
+func (StatusRule) Check(ctx *RuleContext) error {
+	for _, account := range []*Account{ctx.From, ctx.To} {
+		switch account.Status {
+		case AccountStatusFrozen:
+			return &RuleViolation{Reason: ReasonAccountFrozen, AccountID: account.ID, Err: ErrAccountFrozen}
+		case AccountStatusClosed:
+			return &RuleViolation{Reason: ReasonAccountClosed, AccountID: account.ID, Err: ErrAccountClosed}
+		}
+	}
+	return nil
+}
+
+// WalletRule rejects legs paying out of a wallet that its key did not sign, money only leaves
+// an account with a public key through TransferSigned
+type WalletRule struct{}
+
+func (WalletRule) Name() string { return "wallet" }
+
+func (WalletRule) Check(ctx *RuleContext) error {
+	if ctx.From.PublicKey != nil && !ctx.Signed {
+		return &RuleViolation{Reason: ReasonUnsignedDebit, AccountID: ctx.From.ID, Err: ErrUnsignedDebit}
+	}
+	return nil
+}
+
+// LimitRule enforces the AccountLimit of the paying account
+type LimitRule struct{}
+
+func (LimitRule) Name() string { return "limit" }
+
+func (LimitRule) Check(ctx *RuleContext) error {
+	var limit AccountLimit
+	result := ctx.Tx.Where("account_id = ?", ctx.From.ID).Limit(1).Find(&limit)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	violation := func(reason RejectReason) error {
+		return &RuleViolation{Reason: reason, AccountID: ctx.From.ID, Err: ErrLimitExceeded}
+	}
+	amount := toCents(ctx.Amount)
+	if limit.MaxPerTransaction > 0 && amount > toCents(limit.MaxPerTransaction) {
+		return violation(ReasonPerTransactionLimit)
+	}
+
+	windows := []struct {
+		limit  float64
+		since  time.Time
+		reason RejectReason
+	}{
+		{limit.DailyOutLimit, startOfDay(ctx.Now), ReasonDailyLimit},
+		{limit.MonthlyOutLimit, startOfMonth(ctx.Now), ReasonMonthlyLimit},
+	}
+	for _, w := range windows {
+		if w.limit <= 0 {
+			continue
+		}
+		var spent float64
+		if err := ctx.Tx.Model(&Transaction{}).
+			Where("from_account_id = ? AND created_at >= ?", ctx.From.ID, w.since).
+			Select("COALESCE(SUM(amount), 0)").Scan(&spent).Error; err != nil {
+			return err
+		}
+		if toCents(spent)+toCents(ctx.Pending)+amount > toCents(w.limit) {
+			return violation(w.reason)
+		}
+	}
+	return nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// SetAccountLimit creates or replaces the limits of an account
+func SetAccountLimit(db *gorm.DB, limit AccountLimit) error {
+	return db.Save(&limit).Error
+}
+
+// SetAccountStatus freezes, unfreezes or closes an account,
+// an account can only be closed once its balance is zero
+func SetAccountStatus(db *gorm.DB, accountID uint, status string) error {
+	switch status {
+	case AccountStatusActive, AccountStatusFrozen, AccountStatusClosed:
+	default:
+		return fmt.Errorf("bank: unknown account status %q", status)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		accounts, err := lockAccounts(tx, accountID)
+		if err != nil {
+			return err
+		}
+		account := accounts[accountID]
+		if account.Status == AccountStatusClosed && status != AccountStatusClosed {
+			return &AccountError{AccountID: accountID, Err: ErrAccountClosed}
+		}
+		if status == AccountStatusClosed && toCents(account.Balance) != 0 {
+			return &AccountError{AccountID: accountID, Err: ErrAccountNotEmpty}
+		}
+		// bump the version so optimistic transfers that read the old status retry
+		return tx.Model(&Account{}).Where("id = ?", accountID).Updates(map[string]interface{}{
+			"status":  status,
+			"version": gorm.Expr("version + 1"),
+		}).Error
+	})
+}
@@ -0,0 +1,332 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrScheduleNotFound is returned for unknown scheduled transfer ids
+var ErrScheduleNotFound = errors.New("bank: scheduled transfer not found")
+
+// scheduled transfer status values
+const (
+	ScheduleStatusActive    = "active"
+	ScheduleStatusPaused    = "paused"
+	ScheduleStatusCompleted = "completed"
+	ScheduleStatusFailed    = "failed"
+)
+
+// what the runner does when an execution fails
+const (
+	OnFailureRetry = "retry" // retry the same occurrence with backoff, skip it once MaxRetries is used up
+	OnFailureSkip  = "skip"  // give up on the occurrence and wait for the next one
+	OnFailurePause = "pause" // stop the standing order until it is resumed
+)
+
+// ScheduledTransfer is a standing order: a one-off transfer at NextRunAt, or a recurring one
+// driven by a cron expression or a fixed interval
+type ScheduledTransfer struct {
+	ID                uint `gorm:"primaryKey"`
+	FromAccountID     uint `gorm:"index"`
+	ToAccountID       uint
+	Amount            float64 `gorm:"type:decimal(10,2)"`
+	Cron              string  `gorm:"size:100"` // 5 field cron expression in local time, e.g. "0 9 1 * *"
+	IntervalSeconds   int64   // fixed interval used when Cron is empty, 0 means one-off
+	NextRunAt         time.Time
+	DueAt             time.Time  `gorm:"index"` // NextRunAt, or the time of the next retry
+	EndAt             *time.Time // no occurrence after this time
+	Status            string     `gorm:"size:20;not null;default:active;index"`
+	OnFailure         string     `gorm:"size:20;not null;default:retry"`
+	MaxRetries        int        `gorm:"not null;default:3"`
+	RetryDelaySeconds int64      `gorm:"not null;default:60"`
+	Attempts          int        // failed attempts of the current occurrence
+	RunCount          int        // successful executions
+	LastError         string     `gorm:"size:255"`
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// ScheduledTransferRun records every execution attempt, the unique key makes sure two runners
+// can never both commit the same attempt of the same occurrence
+type ScheduledTransferRun struct {
+	ID           uint      `gorm:"primaryKey"`
+	ScheduleID   uint      `gorm:"uniqueIndex:idx_schedule_occurrence_attempt"`
+	ScheduledFor time.Time `gorm:"uniqueIndex:idx_schedule_occurrence_attempt"`
+	Attempt      int       `gorm:"uniqueIndex:idx_schedule_occurrence_attempt"`
+	Succeeded    bool
+	Error        string `gorm:"size:255"`
+	CreatedAt    time.Time
+}
+
+// next returns the occurrence following after, ok is false when the order has no more occurrences
+func (s *ScheduledTransfer) next(after time.Time) (time.Time, bool) {
+	var next time.Time
+	switch {
+	case s.Cron != "":
+		schedule, err := parseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		next = schedule.Next(after)
+	case s.IntervalSeconds > 0:
+		next = after.Add(time.Duration(s.IntervalSeconds) * time.Second)
+	}
+	if next.IsZero() || (s.EndAt != nil && next.After(*s.EndAt)) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// CreateScheduledTransfer validates s and stores it, the first occurrence is startAt for interval
+// and one-off orders, or the first cron match at or after startAt
+func CreateScheduledTransfer(db *gorm.DB, s *ScheduledTransfer, startAt time.Time) error {
+	if err := validateAmount(s.Amount); err != nil {
+		return err
+	}
+	if s.FromAccountID == s.ToAccountID {
+		return ErrSameAccount
+	}
+	if s.IntervalSeconds < 0 {
+		return fmt.Errorf("bank: negative schedule interval")
+	}
+	switch s.OnFailure {
+	case "":
+		s.OnFailure = OnFailureRetry
+	case OnFailureRetry, OnFailureSkip, OnFailurePause:
+	default:
+		return fmt.Errorf("bank: unknown failure policy %q", s.OnFailure)
+	}
+
+	first := startAt
+	if s.Cron != "" {
+		schedule, err := parseCron(s.Cron)
+		if err != nil {
+			return err
+		}
+		if first = schedule.Next(startAt.Add(-time.Minute)); first.IsZero() {
+			return fmt.Errorf("bank: cron expression %q never matches", s.Cron)
+		}
+	}
+	if s.EndAt != nil && first.After(*s.EndAt) {
+		return fmt.Errorf("bank: schedule ends before its first occurrence")
+	}
+
+	s.NextRunAt = first
+	s.DueAt = first
+	s.Status = ScheduleStatusActive
+	return db.Create(s).Error
+}
+
+// SetScheduledTransferStatus pauses, resumes or cancels (completes) a standing order
+func SetScheduledTransferStatus(db *gorm.DB, id uint, status string) error {
+	switch status {
+	case ScheduleStatusActive, ScheduleStatusPaused, ScheduleStatusCompleted:
+	default:
+		return fmt.Errorf("bank: cannot set schedule status to %q", status)
+	}
+	result := db.Model(&ScheduledTransfer{}).Where("id = ?", id).Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// ScheduleEvent describes a failed execution and what the runner did about it
+type ScheduleEvent struct {
+	Schedule     ScheduledTransfer
+	ScheduledFor time.Time
+	Attempt      int
+	Action       string // OnFailureRetry, OnFailureSkip, OnFailurePause or ScheduleStatusFailed
+	Err          error
+}
+
+// Notifier receives schedule failure events after the runner committed its decision
+type Notifier interface {
+	Notify(event ScheduleEvent)
+}
+
+// NotifierFunc adapts a function to Notifier
+type NotifierFunc func(event ScheduleEvent)
+
+func (f NotifierFunc) Notify(event ScheduleEvent) { f(event) }
+
+// logNotifier is the default Notifier
+var logNotifier = NotifierFunc(func(e ScheduleEvent) {
+	log.Printf("scheduled transfer %d (occurrence %s, attempt %d) failed, action: %s, error: %v",
+		e.Schedule.ID, e.ScheduledFor.Format(time.RFC3339), e.Attempt, e.Action, e.Err)
+})
+
+// TransferRunner executes due scheduled transfers. Several runners may poll the same database,
+// rows are claimed with SELECT ... FOR UPDATE SKIP LOCKED and the transfer, the run record and the
+// advanced schedule are committed together, so each occurrence is executed exactly once
+type TransferRunner struct {
+	db        *gorm.DB
+	Notifier  Notifier
+	BatchSize int              // schedules claimed per RunDue call
+	Options   []TransferOption // passed to every TransferMoney call
+}
+
+// NewTransferRunner creates a runner that logs failures
+func NewTransferRunner(db *gorm.DB) *TransferRunner {
+	return &TransferRunner{db: db, Notifier: logNotifier, BatchSize: 100}
+}
+
+// Run calls RunDue every interval until ctx is cancelled
+func (r *TransferRunner) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.RunDue(time.Now()); err != nil {
+			log.Printf("run scheduled transfers failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue executes the schedules due at now and returns how many transfers succeeded
+func (r *TransferRunner) RunDue(now time.Time) (int, error) {
+	var ids []uint
+	if err := r.db.Model(&ScheduledTransfer{}).
+		Where("status = ? AND due_at <= ?", ScheduleStatusActive, now).
+		Order("due_at, id").Limit(r.BatchSize).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	executed := 0
+	for _, id := range ids {
+		ok, err := r.runOne(id, now)
+		if err != nil {
+			return executed, err
+		}
+		if ok {
+			executed++
+		}
+	}
+	return executed, nil
+}
+
+// runOne claims and executes one schedule, ok reports whether the transfer went through
+func (r *TransferRunner) runOne(id uint, now time.Time) (ok bool, err error) {
+	var event *ScheduleEvent
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		var s ScheduledTransfer
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ? AND status = ? AND due_at <= ?", id, ScheduleStatusActive, now).
+			Limit(1).Find(&s)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil // claimed by another runner or no longer due
+		}
+
+		run := ScheduledTransferRun{ScheduleID: s.ID, ScheduledFor: s.NextRunAt, Attempt: s.Attempts + 1}
+		// no retries inside the outer transaction, a deadlock aborts it as a whole
+		opts := append(append([]TransferOption(nil), r.Options...), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+		transferErr := TransferMoney(tx, s.FromAccountID, s.ToAccountID, s.Amount, opts...)
+		if transferErr != nil && IsRetryable(transferErr) {
+			return transferErr
+		}
+
+		if transferErr == nil {
+			run.Succeeded = true
+			s.RunCount++
+			s.Attempts = 0
+			s.LastError = ""
+			r.advance(&s, ScheduleStatusCompleted)
+			ok = true
+		} else {
+			run.Error = Truncate(transferErr.Error(), 255)
+			s.LastError = run.Error
+			event = r.fail(&s, run.Attempt, now, transferErr)
+		}
+
+		if err := tx.Create(&run).Error; err != nil {
+			return err
+		}
+		return tx.Save(&s).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	if event != nil && r.Notifier != nil {
+		r.Notifier.Notify(*event)
+	}
+	return ok, nil
+}
+
+// advance moves s to its next occurrence, or sets finalStatus when there is none
+func (r *TransferRunner) advance(s *ScheduledTransfer, finalStatus string) {
+	s.Attempts = 0
+	next, ok := s.next(s.NextRunAt)
+	if !ok {
+		s.Status = finalStatus
+		return
+	}
+	s.NextRunAt = next
+	s.DueAt = next
+}
+
+// fail applies the failure policy of s to a failed attempt and returns the event to notify
+func (r *TransferRunner) fail(s *ScheduledTransfer, attempt int, now time.Time, cause error) *ScheduleEvent {
+	event := &ScheduleEvent{ScheduledFor: s.NextRunAt, Attempt: attempt, Err: cause}
+	switch {
+	case s.OnFailure == OnFailurePause:
+		s.Status = ScheduleStatusPaused
+		event.Action = OnFailurePause
+	case s.OnFailure == OnFailureRetry && attempt <= s.MaxRetries:
+		s.Attempts = attempt
+		s.DueAt = now.Add(time.Duration(s.RetryDelaySeconds) * time.Second << (attempt - 1))
+		event.Action = OnFailureRetry
+	default:
+		r.advance(s, ScheduleStatusFailed)
+		event.Action = OnFailureSkip
+		if s.Status == ScheduleStatusFailed {
+			event.Action = ScheduleStatusFailed
+		}
+	}
+	event.Schedule = *s
+	return event
+}
+
+// Truncate shortens s to at most n bytes without splitting a character, e.g. to fit an error
+// message into a size limited column
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// GetScheduledTransfer loads a standing order with its latest runs first
+func GetScheduledTransfer(db *gorm.DB, id uint) (*ScheduledTransfer, []ScheduledTransferRun, error) {
+	var s ScheduledTransfer
+	if err := db.First(&s, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrScheduleNotFound
+		}
+		return nil, nil, err
+	}
+	var runs []ScheduledTransferRun
+	if err := db.Where("schedule_id = ?", id).Order("id DESC").Find(&runs).Error; err != nil {
+		return nil, nil, err
+	}
+	return &s, runs, nil
+}
@@ -0,0 +1,162 @@
+package bank
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// entry directions seen from the statement account
+const (
+	DirectionDebit  = "debit"
+	DirectionCredit = "credit"
+)
+
+// StatementEntry is one transaction on a statement with the balance right after it
+type StatementEntry struct {
+	TransactionID  uint      `json:"transaction_id"`
+	Time           time.Time `json:"time"`
+	Direction      string    `json:"direction"`
+	Kind           string    `json:"kind"`
+	CounterpartyID uint      `json:"counterparty_id"`
+	Amount         float64   `json:"amount"`
+	Balance        float64   `json:"balance"`
+}
+
+// Statement lists the transactions of an account in [From, To) with running balances
+type Statement struct {
+	AccountID      uint             `json:"account_id"`
+	From           time.Time        `json:"from"`
+	To             time.Time        `json:"to"`
+	OpeningBalance float64          `json:"opening_balance"`
+	ClosingBalance float64          `json:"closing_balance"`
+	TotalDebits    float64          `json:"total_debits"`
+	TotalCredits   float64          `json:"total_credits"`
+	Entries        []StatementEntry `json:"entries"`
+}
+
+// netChange returns incoming minus outgoing cents of the account for transactions matched by scope
+func netChange(tx *gorm.DB, accountID uint, scope func(*gorm.DB) *gorm.DB) (int64, error) {
+	var in, out float64
+	if err := scope(tx.Model(&Transaction{})).Where("to_account_id = ?", accountID).
+		Select("COALESCE(SUM(amount), 0)").Scan(&in).Error; err != nil {
+		return 0, err
+	}
+	if err := scope(tx.Model(&Transaction{})).Where("from_account_id = ?", accountID).
+		Select("COALESCE(SUM(amount), 0)").Scan(&out).Error; err != nil {
+		return 0, err
+	}
+	return toCents(in) - toCents(out), nil
+}
+
+// GetStatement builds the statement of an account between from (inclusive) and to (exclusive).
+// The closing balance is derived from the current balance minus everything posted since to,
+// the opening balance from the closing balance minus the entries of the period
+func GetStatement(db *gorm.DB, accountID uint, from, to time.Time) (*Statement, error) {
+	if !from.Before(to) {
+		return nil, errors.New("bank: statement period is empty")
+	}
+
+	statement := &Statement{AccountID: accountID, From: from, To: to}
+	// read everything in one transaction so balance and transactions come from the same snapshot
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var account Account
+		if err := tx.First(&account, accountID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &AccountError{AccountID: accountID, Err: ErrAccountNotFound}
+			}
+			return err
+		}
+
+		after, err := netChange(tx, accountID, func(q *gorm.DB) *gorm.DB {
+			return q.Where("created_at >= ?", to)
+		})
+		if err != nil {
+			return err
+		}
+
+		var transactions []Transaction
+		if err := tx.Where("(from_account_id = ? OR to_account_id = ?) AND created_at >= ? AND created_at < ?",
+			accountID, accountID, from, to).
+			Order("created_at, id").Find(&transactions).Error; err != nil {
+			return err
+		}
+
+		closing := toCents(account.Balance) - after
+		var debits, credits int64
+		entries := make([]StatementEntry, len(transactions))
+		for i, t := range transactions {
+			entry := StatementEntry{TransactionID: t.ID, Time: t.CreatedAt, Kind: t.Kind, Amount: t.Amount}
+			if t.FromAccountID == accountID {
+				entry.Direction = DirectionDebit
+				entry.CounterpartyID = t.ToAccountID
+				debits += toCents(t.Amount)
+			} else {
+				entry.Direction = DirectionCredit
+				entry.CounterpartyID = t.FromAccountID
+				credits += toCents(t.Amount)
+			}
+			entries[i] = entry
+		}
+
+		// walk forward from the opening balance to fill the running balance
+		balance := closing - credits + debits
+		statement.OpeningBalance = fromCents(balance)
+		for i := range entries {
+			if entries[i].Direction == DirectionDebit {
+				balance -= toCents(entries[i].Amount)
+			} else {
+				balance += toCents(entries[i].Amount)
+			}
+			entries[i].Balance = fromCents(balance)
+		}
+		statement.ClosingBalance = fromCents(closing)
+		statement.TotalDebits = fromCents(debits)
+		statement.TotalCredits = fromCents(credits)
+		statement.Entries = entries
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+// WriteJSON writes the statement as an indented JSON document
+func (s *Statement) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+// WriteCSV writes one row per entry, framed by the opening and closing balance rows
+func (s *Statement) WriteCSV(w io.Writer) error {
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+
+	writer := csv.NewWriter(w)
+	rows := [][]string{
+		{"transaction_id", "time", "direction", "kind", "counterparty_id", "amount", "balance"},
+		{"", s.From.Format(time.RFC3339), "opening", "", "", "", money(s.OpeningBalance)},
+	}
+	for _, e := range s.Entries {
+		rows = append(rows, []string{
+			strconv.FormatUint(uint64(e.TransactionID), 10),
+			e.Time.Format(time.RFC3339),
+			e.Direction,
+			e.Kind,
+			strconv.FormatUint(uint64(e.CounterpartyID), 10),
+			money(e.Amount),
+			money(e.Balance),
+		})
+	}
+	rows = append(rows, []string{"", s.To.Format(time.RFC3339), "closing", "", "", "", money(s.ClosingBalance)})
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
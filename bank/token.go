@@ -0,0 +1,396 @@
+package bank
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errors of the token functions
+var (
+	ErrInvalidToken               = errors.New("bank: invalid token")
+	ErrTokenNotFound              = errors.New("bank: token not found")
+	ErrNotTokenOwner              = errors.New("bank: caller is not the token owner")
+	ErrInsufficientTokenBalance   = errors.New("bank: insufficient token balance")
+	ErrInsufficientTokenAllowance = errors.New("bank: insufficient token allowance")
+	ErrTokenSupplyOverflow        = errors.New("bank: token supply overflow")
+	ErrInvalidTokenAccount        = errors.New("bank: account 0 is reserved for mints and burns")
+)
+
+// token event types, named after the ERC-20 events. Mints are transfers from account 0 and
+// burns are transfers to account 0, like transfers from and to the zero address
+const (
+	TokenEventTransfer = "Transfer"
+	TokenEventApproval = "Approval"
+)
+
+// UnlimitedAllowance is never decreased by TransferTokenFrom, like an ERC-20 max uint256 approval
+const UnlimitedAllowance int64 = math.MaxInt64
+
+// Token is a fungible token definition. Amounts are integer base units (1 token = 10^Decimals units)
+type Token struct {
+	ID             uint   `gorm:"primaryKey"`
+	Symbol         string `gorm:"size:16;uniqueIndex"`
+	Name           string `gorm:"size:64"`
+	Decimals       uint8
+	TotalSupply    int64
+	OwnerAccountID uint `gorm:"index"` // the only account allowed to mint and burn
+	CreatedAt      time.Time
+}
+
+// TokenBalance is the token balance of an account
+type TokenBalance struct {
+	TokenID   uint  `gorm:"primaryKey;autoIncrement:false"`
+	AccountID uint  `gorm:"primaryKey;autoIncrement:false"`
+	Balance   int64 `gorm:"not null;default:0"`
+}
+
+// TokenAllowance is the amount Spender may still move out of the balance of Owner
+type TokenAllowance struct {
+	TokenID          uint  `gorm:"primaryKey;autoIncrement:false"`
+	OwnerAccountID   uint  `gorm:"primaryKey;autoIncrement:false"`
+	SpenderAccountID uint  `gorm:"primaryKey;autoIncrement:false"`
+	Amount           int64 `gorm:"not null;default:0"`
+}
+
+// TokenEvent records every Transfer and Approval. For approvals From is the owner and To the spender
+type TokenEvent struct {
+	ID            uint   `gorm:"primaryKey"`
+	TokenID       uint   `gorm:"index"`
+	Type          string `gorm:"size:20"`
+	FromAccountID uint   `gorm:"index"`
+	ToAccountID   uint   `gorm:"index"`
+	Amount        int64
+	CreatedAt     time.Time
+}
+
+// validateTokenAccounts rejects account 0, which only MintToken and BurnToken
+// may use; anywhere else it would create or destroy tokens outside the supply
+func validateTokenAccounts(accountIDs ...uint) error {
+	for _, id := range accountIDs {
+		if id == 0 {
+			return ErrInvalidTokenAccount
+		}
+	}
+	return nil
+}
+
+// validateTokenAmount checks that amount is a positive number of base units
+func validateTokenAmount(amount int64) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	return nil
+}
+
+// findToken loads a token, locking it when lock is set
+func findToken(tx *gorm.DB, id uint, lock bool) (*Token, error) {
+	if lock {
+		tx = tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var token Token
+	if err := tx.Where("id = ?", id).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %d", ErrTokenNotFound, id)
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// checkTokenAccounts makes sure the sender may send and the receiver may receive
+func checkTokenAccounts(tx *gorm.DB, fromAccountID, toAccountID uint) error {
+	if fromAccountID != 0 {
+		from, err := findAccount(tx, fromAccountID)
+		if err != nil {
+			return err
+		}
+		switch from.Status {
+		case AccountStatusFrozen:
+			return &AccountError{AccountID: from.ID, Err: ErrAccountFrozen}
+		case AccountStatusClosed:
+			return &AccountError{AccountID: from.ID, Err: ErrAccountClosed}
+		}
+	}
+	if toAccountID != 0 {
+		to, err := findAccount(tx, toAccountID)
+		if err != nil {
+			return err
+		}
+		if to.Status == AccountStatusClosed {
+			return &AccountError{AccountID: to.ID, Err: ErrAccountClosed}
+		}
+	}
+	return nil
+}
+
+// lockTokenBalances creates missing balance rows and locks them in account id order,
+// account 0 (mint and burn) has no balance row
+func lockTokenBalances(tx *gorm.DB, tokenID uint, accountIDs ...uint) (map[uint]*TokenBalance, error) {
+	balances := make(map[uint]*TokenBalance, len(accountIDs))
+	for _, id := range sortedIDs(accountIDs...) {
+		if _, ok := balances[id]; ok || id == 0 {
+			continue
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&TokenBalance{TokenID: tokenID, AccountID: id}).Error; err != nil {
+			return nil, err
+		}
+		var balance TokenBalance
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_id = ? AND account_id = ?", tokenID, id).First(&balance).Error; err != nil {
+			return nil, err
+		}
+		balances[id] = &balance
+	}
+	return balances, nil
+}
+
+// moveTokens moves amount between two accounts of a locked or checked token and records
+// the Transfer event. A zero from mints and a zero to burns; the caller adjusts the supply
+func moveTokens(tx *gorm.DB, tokenID, fromAccountID, toAccountID uint, amount int64) error {
+	if err := checkTokenAccounts(tx, fromAccountID, toAccountID); err != nil {
+		return err
+	}
+	balances, err := lockTokenBalances(tx, tokenID, fromAccountID, toAccountID)
+	if err != nil {
+		return err
+	}
+	if from, ok := balances[fromAccountID]; ok {
+		if from.Balance < amount {
+			return fmt.Errorf("%w (account id: %d, balance: %d, amount: %d)",
+				ErrInsufficientTokenBalance, fromAccountID, from.Balance, amount)
+		}
+		if err := tx.Model(&TokenBalance{}).Where("token_id = ? AND account_id = ?", tokenID, fromAccountID).
+			Update("balance", from.Balance-amount).Error; err != nil {
+			return err
+		}
+	}
+	if to, ok := balances[toAccountID]; ok {
+		// cannot overflow, every balance is bounded by the total supply
+		if err := tx.Model(&TokenBalance{}).Where("token_id = ? AND account_id = ?", tokenID, toAccountID).
+			Update("balance", to.Balance+amount).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Create(&TokenEvent{
+		TokenID:       tokenID,
+		Type:          TokenEventTransfer,
+		FromAccountID: fromAccountID,
+		ToAccountID:   toAccountID,
+		Amount:        amount,
+	}).Error
+}
+
+// CreateToken defines a token owned by ownerAccountID and mints initialSupply to the owner
+func CreateToken(db *gorm.DB, symbol, name string, decimals uint8, ownerAccountID uint, initialSupply int64) (*Token, error) {
+	symbol = strings.TrimSpace(symbol)
+	if symbol == "" || len(symbol) > 16 || decimals > 18 || initialSupply < 0 {
+		return nil, ErrInvalidToken
+	}
+	token := Token{Symbol: symbol, Name: name, Decimals: decimals, OwnerAccountID: ownerAccountID, TotalSupply: initialSupply}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := findAccount(tx, ownerAccountID); err != nil {
+			return err
+		}
+		if err := tx.Create(&token).Error; err != nil {
+			return err
+		}
+		if initialSupply == 0 {
+			return nil
+		}
+		return moveTokens(tx, token.ID, 0, ownerAccountID, initialSupply)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetToken loads a token definition
+func GetToken(db *gorm.DB, tokenID uint) (*Token, error) {
+	return findToken(db, tokenID, false)
+}
+
+// TokenBalanceOf returns the balance of an account, 0 when it never held the token
+func TokenBalanceOf(db *gorm.DB, tokenID, accountID uint) (int64, error) {
+	var balance TokenBalance
+	err := db.Where("token_id = ? AND account_id = ?", tokenID, accountID).Limit(1).Find(&balance).Error
+	return balance.Balance, err
+}
+
+// TokenAllowanceOf returns how much spender may still transfer out of the balance of owner
+func TokenAllowanceOf(db *gorm.DB, tokenID, ownerAccountID, spenderAccountID uint) (int64, error) {
+	var allowance TokenAllowance
+	err := db.Where("token_id = ? AND owner_account_id = ? AND spender_account_id = ?",
+		tokenID, ownerAccountID, spenderAccountID).Limit(1).Find(&allowance).Error
+	return allowance.Amount, err
+}
+
+// TransferToken moves amount base units from one account to another
+func TransferToken(db *gorm.DB, tokenID, fromAccountID, toAccountID uint, amount int64) error {
+	if err := validateTokenAccounts(fromAccountID, toAccountID); err != nil {
+		return err
+	}
+	if err := validateTokenAmount(amount); err != nil {
+		return err
+	}
+	if fromAccountID == toAccountID {
+		return ErrSameAccount
+	}
+	return withRetry(DefaultRetryPolicy, func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if _, err := findToken(tx, tokenID, false); err != nil {
+				return err
+			}
+			return moveTokens(tx, tokenID, fromAccountID, toAccountID, amount)
+		})
+	})
+}
+
+// ApproveToken sets (not adds to) the allowance of spender over the balance of owner,
+// 0 revokes it and UnlimitedAllowance never runs out
+func ApproveToken(db *gorm.DB, tokenID, ownerAccountID, spenderAccountID uint, amount int64) error {
+	if err := validateTokenAccounts(ownerAccountID, spenderAccountID); err != nil {
+		return err
+	}
+	if amount < 0 {
+		return ErrInvalidAmount
+	}
+	if ownerAccountID == spenderAccountID {
+		return ErrSameAccount
+	}
+	return withRetry(DefaultRetryPolicy, func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if _, err := findToken(tx, tokenID, false); err != nil {
+				return err
+			}
+			if err := checkTokenAccounts(tx, ownerAccountID, spenderAccountID); err != nil {
+				return err
+			}
+			allowance := TokenAllowance{TokenID: tokenID, OwnerAccountID: ownerAccountID, SpenderAccountID: spenderAccountID, Amount: amount}
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&allowance).Error; err != nil {
+				return err
+			}
+			return tx.Create(&TokenEvent{
+				TokenID:       tokenID,
+				Type:          TokenEventApproval,
+				FromAccountID: ownerAccountID,
+				ToAccountID:   spenderAccountID,
+				Amount:        amount,
+			}).Error
+		})
+	})
+}
+
+// TransferTokenFrom lets spender move amount out of the balance of from using its allowance
+func TransferTokenFrom(db *gorm.DB, tokenID, spenderAccountID, fromAccountID, toAccountID uint, amount int64) error {
+	if err := validateTokenAccounts(spenderAccountID, fromAccountID, toAccountID); err != nil {
+		return err
+	}
+	if err := validateTokenAmount(amount); err != nil {
+		return err
+	}
+	if fromAccountID == toAccountID {
+		return ErrSameAccount
+	}
+	return withRetry(DefaultRetryPolicy, func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if _, err := findToken(tx, tokenID, false); err != nil {
+				return err
+			}
+			if err := checkTokenAccounts(tx, spenderAccountID, 0); err != nil {
+				return err
+			}
+			// the allowance row is locked before the balances, the same order for every spender
+			var allowance TokenAllowance
+			result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("token_id = ? AND owner_account_id = ? AND spender_account_id = ?", tokenID, fromAccountID, spenderAccountID).
+				Limit(1).Find(&allowance)
+			if result.Error != nil {
+				return result.Error
+			}
+			if allowance.Amount < amount {
+				return fmt.Errorf("%w (owner id: %d, spender id: %d, allowance: %d, amount: %d)",
+					ErrInsufficientTokenAllowance, fromAccountID, spenderAccountID, allowance.Amount, amount)
+			}
+			if allowance.Amount != UnlimitedAllowance {
+				if err := tx.Model(&TokenAllowance{}).
+					Where("token_id = ? AND owner_account_id = ? AND spender_account_id = ?", tokenID, fromAccountID, spenderAccountID).
+					Update("amount", allowance.Amount-amount).Error; err != nil {
+					return err
+				}
+			}
+			return moveTokens(tx, tokenID, fromAccountID, toAccountID, amount)
+		})
+	})
+}
+
+// MintToken creates amount new base units on the balance of to, only the owner may mint
+func MintToken(db *gorm.DB, tokenID, callerAccountID, toAccountID uint, amount int64) error {
+	if err := validateTokenAccounts(toAccountID); err != nil {
+		return err
+	}
+	if err := validateTokenAmount(amount); err != nil {
+		return err
+	}
+	return withRetry(DefaultRetryPolicy, func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			token, err := findToken(tx, tokenID, true)
+			if err != nil {
+				return err
+			}
+			if token.OwnerAccountID != callerAccountID {
+				return ErrNotTokenOwner
+			}
+			if token.TotalSupply > math.MaxInt64-amount {
+				return ErrTokenSupplyOverflow
+			}
+			if err := tx.Model(token).Update("total_supply", token.TotalSupply+amount).Error; err != nil {
+				return err
+			}
+			return moveTokens(tx, tokenID, 0, toAccountID, amount)
+		})
+	})
+}
+
+// BurnToken destroys amount base units from the balance of the owner, only the owner may burn
+func BurnToken(db *gorm.DB, tokenID, callerAccountID uint, amount int64) error {
+	if err := validateTokenAmount(amount); err != nil {
+		return err
+	}
+	return withRetry(DefaultRetryPolicy, func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			token, err := findToken(tx, tokenID, true)
+			if err != nil {
+				return err
+			}
+			if token.OwnerAccountID != callerAccountID {
+				return ErrNotTokenOwner
+			}
+			if err := tx.Model(token).Update("total_supply", token.TotalSupply-amount).Error; err != nil {
+				return err
+			}
+			return moveTokens(tx, tokenID, callerAccountID, 0, amount)
+		})
+	})
+}
+
+// FormatTokenAmount renders base units as a decimal number of tokens, e.g. 12345 with 2 decimals is "123.45"
+func FormatTokenAmount(amount int64, decimals uint8) string {
+	sign := ""
+	u := uint64(amount)
+	if amount < 0 {
+		sign, u = "-", uint64(-amount)
+	}
+	s := fmt.Sprintf("%0*d", int(decimals)+1, u)
+	if decimals == 0 {
+		return sign + s
+	}
+	return sign + s[:len(s)-int(decimals)] + "." + s[len(s)-int(decimals):]
+}
@@ -0,0 +1,283 @@
+package bank
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// validateAmount checks that amount is positive, finite and fits decimal(10,2)
+func validateAmount(amount float64) error {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) || amount <= 0 {
+		return ErrInvalidAmount
+	}
+	// the column only keeps two decimal places, reject anything finer
+	if math.Abs(amount*100-math.Round(amount*100)) > 1e-6 {
+		return ErrInvalidAmount
+	}
+	return nil
+}
+
+// toCents converts a decimal(10,2) amount to integer cents
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// fromCents converts integer cents back to an amount
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// findAccount loads an account and rejects missing ones, the status is checked by StatusRule
+func findAccount(tx *gorm.DB, id uint) (*Account, error) {
+	var account Account
+	if err := tx.Where("id = ?", id).First(&account).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &AccountError{AccountID: id, Err: ErrAccountNotFound}
+		}
+		return nil, err
+	}
+	return &account, nil
+}
+
+// sortedIDs returns a sorted copy of ids
+func sortedIDs(ids ...uint) []uint {
+	sorted := append([]uint(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// lockAccounts locks the given accounts with SELECT ... FOR UPDATE in ascending id order,
+// so two transfers touching the same accounts always wait on each other instead of deadlocking
+func lockAccounts(tx *gorm.DB, ids ...uint) (map[uint]*Account, error) {
+	accounts := make(map[uint]*Account, len(ids))
+	for _, id := range sortedIDs(ids...) {
+		if _, ok := accounts[id]; ok {
+			continue
+		}
+		account, err := findAccount(tx.Clauses(clause.Locking{Strength: "UPDATE"}), id)
+		if err != nil {
+			return nil, err
+		}
+		accounts[id] = account
+	}
+	return accounts, nil
+}
+
+// LockMode selects how TransferMoney protects the accounts it updates
+type LockMode int
+
+const (
+	// LockPessimistic holds SELECT ... FOR UPDATE row locks on both accounts for the whole transaction
+	LockPessimistic LockMode = iota
+	// LockOptimistic reads without locks and updates with WHERE version = ?, retrying on conflict.
+	// It only avoids the account row locks: every transfer, in either mode, still takes the
+	// LedgerHead row lock to chain its transactions right before commit, so all bank writes
+	// commit one at a time. The chain is kept synchronous so that no transaction ever exists
+	// outside of it, which is what lets VerifyLedger tell an injected row from a pending one
+	LockOptimistic
+)
+
+// TransferOption customizes a single TransferMoney call
+type TransferOption func(*transferOptions)
+
+type transferOptions struct {
+	mode  LockMode
+	retry *RetryPolicy
+	rules *RuleEngine
+	fee   *FeeSchedule
+}
+
+// WithLockMode selects the locking strategy of the transfer, the default is LockPessimistic
+func WithLockMode(mode LockMode) TransferOption {
+	return func(o *transferOptions) { o.mode = mode }
+}
+
+// WithRuleEngine evaluates the transfer against engine instead of DefaultRuleEngine
+func WithRuleEngine(engine *RuleEngine) TransferOption {
+	return func(o *transferOptions) { o.rules = engine }
+}
+
+// WithRetryPolicy overrides the retry policy of the transfer
+func WithRetryPolicy(policy RetryPolicy) TransferOption {
+	return func(o *transferOptions) { o.retry = &policy }
+}
+
+func newTransferOptions(opts []TransferOption) transferOptions {
+	o := transferOptions{mode: LockPessimistic, rules: DefaultRuleEngine}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.retry == nil {
+		if o.mode == LockOptimistic {
+			o.retry = &DefaultOptimisticRetryPolicy
+		} else {
+			o.retry = &DefaultRetryPolicy
+		}
+	}
+	return o
+}
+
+// loadAccounts reads the accounts in ascending id order, with row locks in pessimistic mode
+func loadAccounts(tx *gorm.DB, mode LockMode, ids ...uint) (map[uint]*Account, error) {
+	if mode == LockPessimistic {
+		return lockAccounts(tx, ids...)
+	}
+	accounts := make(map[uint]*Account, len(ids))
+	for _, id := range sortedIDs(ids...) {
+		if _, ok := accounts[id]; ok {
+			continue
+		}
+		account, err := findAccount(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		accounts[id] = account
+	}
+	return accounts, nil
+}
+
+// applyDelta adds delta to the balance of account and bumps its version.
+// In optimistic mode the update only matches the version that was read and fails with ErrConflict otherwise
+func applyDelta(tx *gorm.DB, mode LockMode, account *Account, delta float64) error {
+	query := tx.Model(&Account{}).Where("id = ?", account.ID)
+	if mode == LockOptimistic {
+		query = query.Where("version = ?", account.Version)
+	} else if delta < 0 {
+		query = query.Where("balance >= ?", -delta)
+	}
+	result := query.Updates(map[string]interface{}{
+		"balance": gorm.Expr("balance + ?", delta),
+		"version": gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		switch {
+		case mode == LockOptimistic:
+			return &AccountError{AccountID: account.ID, Err: ErrConflict}
+		case delta < 0:
+			return &InsufficientFundsError{AccountID: account.ID, Balance: account.Balance, Amount: -delta}
+		default:
+			return &AccountError{AccountID: account.ID, Err: ErrAccountNotFound}
+		}
+	}
+	account.Balance += delta
+	account.Version++
+	return nil
+}
+
+// TransferMoney moves amount from one account to another in a single database transaction
+// and records it as a Transaction row, deadlocks and version conflicts are retried
+func TransferMoney(db *gorm.DB, fromAccountID, toAccountID uint, amount float64, opts ...TransferOption) error {
+	if err := validateAmount(amount); err != nil {
+		return err
+	}
+	if fromAccountID == toAccountID {
+		return ErrSameAccount
+	}
+
+	o := newTransferOptions(opts)
+	err := withRetry(*o.retry, func() error {
+		return transferOnce(db, o, fromAccountID, toAccountID, amount)
+	})
+	recordRejection(db, err)
+	return err
+}
+
+// transferOnce runs one attempt of the transfer
+func transferOnce(db *gorm.DB, o transferOptions, fromAccountID, toAccountID uint, amount float64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		_, err := postLegs(tx, o, []TransferLeg{{
+			FromAccountID: fromAccountID,
+			ToAccountID:   toAccountID,
+			Amount:        amount,
+		}}, nil)
+		return err
+	})
+}
+
+// postLegs moves the money of every leg inside tx and records one Transaction per leg.
+// All involved accounts are loaded once in id order, the balance check is done on the net
+// outflow of each account so a batch either fits completely or is rejected as a whole
+func postLegs(tx *gorm.DB, o transferOptions, legs []TransferLeg, batchID *uint) ([]Transaction, error) {
+	legs = appendFeeLegs(legs, o.fee)
+
+	ids := make([]uint, 0, len(legs)*2)
+	deltas := make(map[uint]int64)
+	for _, leg := range legs {
+		ids = append(ids, leg.FromAccountID, leg.ToAccountID)
+		deltas[leg.FromAccountID] -= toCents(leg.Amount)
+		deltas[leg.ToAccountID] += toCents(leg.Amount)
+	}
+
+	// load all accounts (stable order, locked in pessimistic mode)
+	accounts, err := loadAccounts(tx, o.mode, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	// evaluate the risk rules on every leg, earlier legs count as pending outflow of later ones
+	if err := o.rules.evaluate(tx, accounts, legs); err != nil {
+		return nil, err
+	}
+
+	// check the balance of every account that pays out
+	for id, delta := range deltas {
+		if delta < 0 && toCents(accounts[id].Balance) < -delta {
+			return nil, &InsufficientFundsError{AccountID: id, Balance: accounts[id].Balance, Amount: fromCents(-delta)}
+		}
+	}
+
+	// update the balances in id order so concurrent writers never wait on each other in a cycle
+	for _, id := range sortedIDs(ids...) {
+		delta, ok := deltas[id]
+		if !ok || delta == 0 {
+			continue
+		}
+		delete(deltas, id)
+		if err := applyDelta(tx, o.mode, accounts[id], fromCents(delta)); err != nil {
+			return nil, err
+		}
+	}
+
+	// record transactions, created_at is kept to the millisecond so it survives the round trip
+	// through the database unchanged and the chained hash stays verifiable
+	now := time.Now().Truncate(time.Millisecond)
+	transactions := make([]Transaction, len(legs))
+	for i, leg := range legs {
+		kind := leg.Kind
+		if kind == "" {
+			kind = TransactionKindTransfer
+		}
+		transactions[i] = Transaction{
+			BatchID:       batchID,
+			FromAccountID: leg.FromAccountID,
+			ToAccountID:   leg.ToAccountID,
+			Amount:        leg.Amount,
+			Kind:          kind,
+			Nonce:         leg.nonce,
+			Signature:     leg.signature,
+			CreatedAt:     now,
+		}
+	}
+	if err := tx.Create(&transactions).Error; err != nil {
+		return nil, err
+	}
+
+	// append them to the tamper-evident hash chain
+	if err := chainTransactions(tx, transactions); err != nil {
+		return nil, err
+	}
+
+	// publish the transactions through the outbox in the same database transaction
+	if err := writeTransactionEvents(tx, transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
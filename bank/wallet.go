@@ -0,0 +1,227 @@
+package bank
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// errors of signed wallet transfers
+var (
+	ErrInvalidPublicKey = errors.New("bank: invalid public key")
+	ErrInvalidSignature = errors.New("bank: invalid signature")
+	ErrInvalidNonce     = errors.New("bank: invalid nonce")
+	ErrWalletNotFound   = errors.New("bank: wallet not found")
+	ErrUnsignedDebit    = errors.New("bank: debits from a wallet must be signed by its key")
+)
+
+// NonceError reports the nonce the account expected, it matches ErrInvalidNonce with errors.Is
+type NonceError struct {
+	AccountID uint
+	Expected  uint64
+	Got       uint64
+}
+
+func (e *NonceError) Error() string {
+	return fmt.Sprintf("%v (account id: %d, expected: %d, got: %d)", ErrInvalidNonce, e.AccountID, e.Expected, e.Got)
+}
+
+func (e *NonceError) Is(target error) bool { return target == ErrInvalidNonce }
+
+// transferDomain separates transfer signatures from anything else signed with the same key
+const transferDomain = "web3-ledger-transfer-v1"
+
+// SignedTransfer is a transfer between two wallets authorized by the private key of From.
+// From and To are hex encoded ed25519 public keys, Nonce must equal the current nonce of From
+type SignedTransfer struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Amount    float64 `json:"amount"`
+	Nonce     uint64  `json:"nonce"`
+	Signature string  `json:"signature"`
+}
+
+// decodePublicKey parses a hex encoded ed25519 public key
+func decodePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Message returns the bytes covered by the signature:
+// domain || from key || to key || amount in cents (big endian) || nonce (big endian)
+func (t SignedTransfer) Message() ([]byte, error) {
+	from, err := decodePublicKey(t.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := decodePublicKey(t.To)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(transferDomain)+2*ed25519.PublicKeySize+16)
+	msg = append(msg, transferDomain...)
+	msg = append(msg, from...)
+	msg = append(msg, to...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(toCents(t.Amount)))
+	msg = binary.BigEndian.AppendUint64(msg, t.Nonce)
+	return msg, nil
+}
+
+// Verify checks the signature against the From key
+func (t SignedTransfer) Verify() error {
+	msg, err := t.Message()
+	if err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(t.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	from, _ := decodePublicKey(t.From)
+	if !ed25519.Verify(from, msg, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Normalized returns t with the keys and the signature in lower case hex, the encoding wallets
+// are stored with, so the same transfer is always spelled the same way
+func (t SignedTransfer) Normalized() (SignedTransfer, error) {
+	from, err := decodePublicKey(t.From)
+	if err != nil {
+		return SignedTransfer{}, err
+	}
+	to, err := decodePublicKey(t.To)
+	if err != nil {
+		return SignedTransfer{}, err
+	}
+	sig, err := hex.DecodeString(t.Signature)
+	if err != nil {
+		return SignedTransfer{}, ErrInvalidSignature
+	}
+	t.From, t.To, t.Signature = hex.EncodeToString(from), hex.EncodeToString(to), hex.EncodeToString(sig)
+	return t, nil
+}
+
+// SignTransfer builds and signs a transfer from the wallet of key to the wallet of to
+func SignTransfer(key ed25519.PrivateKey, to string, amount float64, nonce uint64) (SignedTransfer, error) {
+	t := SignedTransfer{
+		From:   hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		To:     to,
+		Amount: amount,
+		Nonce:  nonce,
+	}
+	msg, err := t.Message()
+	if err != nil {
+		return SignedTransfer{}, err
+	}
+	t.Signature = hex.EncodeToString(ed25519.Sign(key, msg))
+	return t, nil
+}
+
+// CreateWallet creates an account identified by an ed25519 public key
+func CreateWallet(db *gorm.DB, key ed25519.PublicKey, balance float64) (*Account, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+	address := hex.EncodeToString(key)
+	account := Account{Balance: balance, PublicKey: &address}
+	if err := db.Create(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// FindWallet loads the account of a hex encoded public key, in upper or lower case
+func FindWallet(db *gorm.DB, publicKey string) (*Account, error) {
+	key, err := decodePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	// wallets are stored with the lower case encoding of CreateWallet
+	address := hex.EncodeToString(key)
+	var account Account
+	result := db.Where("public_key = ?", address).Limit(1).Find(&account)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrWalletNotFound, address)
+	}
+	return &account, nil
+}
+
+// TransferSigned executes a signed wallet transfer. The wallets are resolved, the signature verified
+// and the nonce of the sender consumed inside the transfer transaction, so a signed transfer can
+// be executed at most once
+func TransferSigned(db *gorm.DB, t SignedTransfer, opts ...TransferOption) (*Transaction, error) {
+	if err := validateAmount(t.Amount); err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(t.From, t.To) {
+		return nil, ErrSameAccount
+	}
+
+	o := newTransferOptions(opts)
+	var transaction *Transaction
+	err := withRetry(*o.retry, func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			transaction, err = postSigned(tx, o, t)
+			return err
+		})
+	})
+	recordRejection(db, err)
+	return transaction, err
+}
+
+// postSigned runs one attempt of a signed transfer inside tx
+func postSigned(tx *gorm.DB, o transferOptions, t SignedTransfer) (*Transaction, error) {
+	from, err := FindWallet(tx, t.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := FindWallet(tx, t.To)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Verify(); err != nil {
+		return nil, err
+	}
+
+	nonce := t.Nonce
+	transactions, err := postLegs(tx, o, []TransferLeg{{
+		FromAccountID: from.ID,
+		ToAccountID:   to.ID,
+		Amount:        t.Amount,
+		nonce:         &nonce,
+		signature:     t.Signature,
+		signed:        true,
+	}}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// consume the nonce, the sender row is already locked (or version checked) by postLegs
+	result := tx.Model(&Account{}).Where("id = ? AND nonce = ?", from.ID, t.Nonce).
+		Update("nonce", gorm.Expr("nonce + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		var current Account
+		if err := tx.Select("nonce").First(&current, from.ID).Error; err != nil {
+			return nil, err
+		}
+		return nil, &NonceError{AccountID: from.ID, Expected: current.Nonce, Got: t.Nonce}
+	}
+	return &transactions[0], nil
+}
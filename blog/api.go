@@ -0,0 +1,1290 @@
+package blog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxBodySize limits the size of a request body
+const maxBodySize = 1 << 20
+
+// ErrorBody is the JSON body of every error response
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error, Fields is only set for validation errors
+type ErrorDetail struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// LoginInput is the body of POST /login
+type LoginInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// SessionView is the response of POST /login, the token goes into the
+// Authorization: Bearer header of later requests
+type SessionView struct {
+	Token     string    `json:"token"`
+	UserID    uint      `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ListBody is the JSON body of list responses, pass NextCursor as the cursor
+// query parameter to get the next page
+type ListBody struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	HasMore    bool        `json:"has_more"`
+	Total      *int64      `json:"total,omitempty"`
+}
+
+// UserView is the JSON representation of a user, it never contains the
+// password and only contains the email for the user itself and admins
+type UserView struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PostView is the JSON representation of a post
+type PostView struct {
+	ID            uint       `json:"id"`
+	UserID        uint       `json:"user_id"`
+	Title         string     `json:"title"`
+	Content       string     `json:"content"`
+	Status        string     `json:"status"`
+	PublishAt     *time.Time `json:"publish_at"`
+	CommentCount  int        `json:"comment_count"`
+	CommentStatus string     `json:"comment_status"`
+	LikeCount     int64      `json:"like_count"`
+	ViewCount     int64      `json:"view_count"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// RevisionView is the JSON representation of a revision of a post
+type RevisionView struct {
+	Number       int       `json:"number"`
+	PostID       uint      `json:"post_id"`
+	UserID       uint      `json:"user_id"`
+	Title        string    `json:"title"`
+	Content      string    `json:"content"`
+	Diff         string    `json:"diff"`
+	RestoredFrom *int      `json:"restored_from,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CommentView is the JSON representation of a comment
+type CommentView struct {
+	ID        uint      `json:"id"`
+	PostID    uint      `json:"post_id"`
+	UserID    uint      `json:"user_id"`
+	ParentID  *uint     `json:"parent_id"`
+	Depth     int       `json:"depth"`
+	State     string    `json:"state"`
+	LikeCount int64     `json:"like_count"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StatsView is the JSON representation of the engagement counters of a
+// post or comment, Changed tells whether a like or reaction request did something
+type StatsView struct {
+	Likes     int64            `json:"likes"`
+	Views     int64            `json:"views,omitempty"`
+	Reactions map[string]int64 `json:"reactions"`
+	Changed   *bool            `json:"changed,omitempty"`
+}
+
+// ReactionInput is the body of POST /posts/{id}/reactions and POST /comments/{id}/reactions
+type ReactionInput struct {
+	Emoji string `json:"emoji"`
+}
+
+// TagView is the JSON representation of a tag
+type TagView struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// TagCountView is a tag with the number of its posts
+type TagCountView struct {
+	TagView
+	PostCount int64 `json:"post_count"`
+}
+
+// CategoryView is the JSON representation of a category
+type CategoryView struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID *uint  `json:"parent_id"`
+	Depth    int    `json:"depth"`
+}
+
+// CategoryTreeView is a category with its subcategories
+type CategoryTreeView struct {
+	CategoryView
+	Children []CategoryTreeView `json:"children"`
+}
+
+// TagsInput is the body of POST /posts/{id}/tags
+type TagsInput struct {
+	Tags []string `json:"tags"`
+}
+
+// CategoryIDsInput is the body of POST /posts/{id}/categories
+type CategoryIDsInput struct {
+	CategoryIDs []uint `json:"category_ids"`
+}
+
+// ThreadView is the JSON representation of a comment with its replies
+type ThreadView struct {
+	CommentView
+	Replies []ThreadView `json:"replies"`
+}
+
+// ModerationInput is the body of POST /comments/{id}/moderation
+type ModerationInput struct {
+	State string `json:"state"`
+}
+
+// SearchHitView is the JSON representation of a search hit
+type SearchHitView struct {
+	Kind    string  `json:"kind"`
+	ID      uint    `json:"id"`
+	PostID  uint    `json:"post_id"`
+	Title   string  `json:"title,omitempty"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+func newUserView(u *User) UserView {
+	return UserView{ID: u.ID, Name: u.Name, Email: u.Email, Role: u.Role, CreatedAt: u.CreatedAt, UpdatedAt: u.UpdatedAt}
+}
+
+// userViewFor returns the view of users that viewer gets, the email is left
+// out unless viewer may update the user
+func userViewFor(viewer *User) func(*User) UserView {
+	return func(u *User) UserView {
+		view := newUserView(u)
+		if !Can(viewer, ActionUpdateUser, u.ID) {
+			view.Email = ""
+		}
+		return view
+	}
+}
+
+func newPostView(p *Post) PostView {
+	return PostView{
+		ID:            p.ID,
+		UserID:        p.UserID,
+		Title:         p.Title,
+		Content:       p.Content,
+		Status:        p.Status,
+		PublishAt:     p.PublishAt,
+		CommentCount:  p.CommentCount,
+		CommentStatus: p.CommentStatus,
+		LikeCount:     p.LikeCount,
+		ViewCount:     p.ViewCount,
+		CreatedAt:     p.CreatedAt,
+		UpdatedAt:     p.UpdatedAt,
+	}
+}
+
+func newCommentView(c *Comment) CommentView {
+	return CommentView{
+		ID:        c.ID,
+		PostID:    c.PostID,
+		UserID:    c.UserID,
+		ParentID:  c.ParentID,
+		Depth:     c.Depth,
+		State:     c.State,
+		LikeCount: c.LikeCount,
+		Content:   c.Content,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+func newRevisionView(r *PostRevision) RevisionView {
+	return RevisionView{
+		Number:       r.Number,
+		PostID:       r.PostID,
+		UserID:       r.UserID,
+		Title:        r.Title,
+		Content:      r.Content,
+		Diff:         r.Diff,
+		RestoredFrom: r.RestoredFrom,
+		CreatedAt:    r.CreatedAt,
+	}
+}
+
+func newTagView(t *Tag) TagView {
+	return TagView{ID: t.ID, Name: t.Name, Slug: t.Slug}
+}
+
+func newCategoryView(c *Category) CategoryView {
+	return CategoryView{ID: c.ID, Name: c.Name, Slug: c.Slug, ParentID: c.ParentID, Depth: c.Depth}
+}
+
+func newCategoryTreeView(n *CategoryNode) CategoryTreeView {
+	view := CategoryTreeView{CategoryView: newCategoryView(&n.Category), Children: make([]CategoryTreeView, len(n.Children))}
+	for i, child := range n.Children {
+		view.Children[i] = newCategoryTreeView(child)
+	}
+	return view
+}
+
+func newThreadView(n *CommentNode) ThreadView {
+	view := ThreadView{CommentView: newCommentView(&n.Comment), Replies: make([]ThreadView, len(n.Replies))}
+	for i, reply := range n.Replies {
+		view.Replies[i] = newThreadView(reply)
+	}
+	return view
+}
+
+// Handler serves the blog JSON API:
+//
+//	GET    /users                 POST /users
+//	GET    /users/{id}            PATCH /users/{id}     DELETE /users/{id}
+//	GET    /users/{id}/posts      POST /users/{id}/posts
+//	GET    /posts
+//	GET    /posts/{id}            PATCH /posts/{id}     DELETE /posts/{id}
+//	GET    /posts/{id}/revisions  GET /posts/{id}/revisions/{number}
+//	POST   /posts/{id}/revisions/{number}/restore
+//	GET    /posts/{id}/comments   POST /posts/{id}/comments
+//	GET    /posts/{id}/thread
+//	GET    /comments/{id}         PATCH /comments/{id}  DELETE /comments/{id}
+//	GET    /comments/{id}/thread  POST /comments/{id}/moderation
+//	GET    /moderation/comments
+//	GET    /tags                  GET /tags/{slug}/posts
+//	GET    /posts/{id}/tags       POST /posts/{id}/tags  DELETE /posts/{id}/tags/{slug}
+//	GET    /categories            POST /categories      GET /categories/{id}/posts
+//	GET    /posts/{id}/categories POST /posts/{id}/categories
+//	DELETE /posts/{id}/categories/{category}
+//	POST   /posts/{id}/like       DELETE /posts/{id}/like
+//	POST   /posts/{id}/reactions  DELETE /posts/{id}/reactions/{emoji}
+//	GET    /posts/{id}/stats
+//	(and the same three for /comments/{id})
+//	POST   /login                 POST /logout          GET /me
+//	GET    /search?q=             (kind=post|comment, limit, offset)
+//
+// Posts are created published unless the body asks for a draft or a
+// scheduled post, see PostInput; GET /users/{id}/posts?status= lists the
+// posts of another status to the users who may edit them. Scheduled posts
+// are published by RunPublisher, which the server has to run.
+//
+// GET /posts/{id} counts a view. Likes, reactions and views are counted
+// behind by the counters of Engagement, which the server has to run.
+//
+// The list endpoints take the query parameters limit, cursor, sort (a field,
+// "-" prefixed for descending order) and total=true, see PageRequest.
+//
+// Writes other than signing up and logging in need an Authorization: Bearer
+// token and are checked by Authorize. Comments waiting for moderation are
+// only shown to their author and to the moderators of the post.
+type Handler struct {
+	db         *gorm.DB
+	search     *Search
+	engagement *Engagement
+	mux        *http.ServeMux
+}
+
+// NewHandler creates the API handler
+func NewHandler(db *gorm.DB) *Handler {
+	h := &Handler{db: db, search: NewSearch(db), engagement: NewEngagement(db), mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /users", h.listUsers)
+	h.mux.HandleFunc("POST /users", h.createUser)
+	h.mux.HandleFunc("GET /users/{id}", h.getUser)
+	h.mux.HandleFunc("PATCH /users/{id}", h.updateUser)
+	h.mux.HandleFunc("DELETE /users/{id}", h.deleteUser)
+	h.mux.HandleFunc("GET /users/{id}/posts", h.listUserPosts)
+	h.mux.HandleFunc("POST /users/{id}/posts", h.createPost)
+	h.mux.HandleFunc("GET /posts", h.listPosts)
+	h.mux.HandleFunc("GET /posts/{id}", h.getPost)
+	h.mux.HandleFunc("PATCH /posts/{id}", h.updatePost)
+	h.mux.HandleFunc("DELETE /posts/{id}", h.deletePost)
+	h.mux.HandleFunc("GET /posts/{id}/revisions", h.listRevisions)
+	h.mux.HandleFunc("GET /posts/{id}/revisions/{number}", h.getRevision)
+	h.mux.HandleFunc("POST /posts/{id}/revisions/{number}/restore", h.restoreRevision)
+	h.mux.HandleFunc("GET /posts/{id}/comments", h.listPostComments)
+	h.mux.HandleFunc("POST /posts/{id}/comments", h.createComment)
+	h.mux.HandleFunc("GET /comments/{id}", h.getComment)
+	h.mux.HandleFunc("PATCH /comments/{id}", h.updateComment)
+	h.mux.HandleFunc("DELETE /comments/{id}", h.deleteComment)
+	h.mux.HandleFunc("GET /posts/{id}/thread", h.listThreads)
+	h.mux.HandleFunc("GET /comments/{id}/thread", h.getThread)
+	h.mux.HandleFunc("POST /comments/{id}/moderation", h.moderateComment)
+	h.mux.HandleFunc("GET /moderation/comments", h.moderationQueue)
+	h.mux.HandleFunc("GET /tags", h.listTags)
+	h.mux.HandleFunc("GET /tags/{slug}/posts", h.listTagPosts)
+	h.mux.HandleFunc("GET /posts/{id}/tags", h.postTags)
+	h.mux.HandleFunc("POST /posts/{id}/tags", h.attachTags)
+	h.mux.HandleFunc("DELETE /posts/{id}/tags/{slug}", h.detachTag)
+	h.mux.HandleFunc("GET /categories", h.categoryTree)
+	h.mux.HandleFunc("POST /categories", h.createCategory)
+	h.mux.HandleFunc("GET /categories/{id}/posts", h.listCategoryPosts)
+	h.mux.HandleFunc("GET /posts/{id}/categories", h.postCategories)
+	h.mux.HandleFunc("POST /posts/{id}/categories", h.attachCategories)
+	h.mux.HandleFunc("DELETE /posts/{id}/categories/{category}", h.detachCategory)
+	for _, target := range []string{TargetPost, TargetComment} {
+		prefix := "/" + target + "s/{id}"
+		h.mux.HandleFunc("POST "+prefix+"/like", h.like(target, true))
+		h.mux.HandleFunc("DELETE "+prefix+"/like", h.like(target, false))
+		h.mux.HandleFunc("POST "+prefix+"/reactions", h.react(target))
+		h.mux.HandleFunc("DELETE "+prefix+"/reactions/{emoji}", h.unreact(target))
+		h.mux.HandleFunc("GET "+prefix+"/stats", h.stats(target))
+	}
+	h.mux.HandleFunc("POST /login", h.login)
+	h.mux.HandleFunc("POST /logout", h.logout)
+	h.mux.HandleFunc("GET /me", h.me)
+	h.mux.HandleFunc("GET /search", h.searchDocuments)
+	return h
+}
+
+// Engagement returns the engagement service of the handler, its counters
+// have to be flushed, usually by running Engagement.Run next to the server
+func (h *Handler) Engagement() *Engagement {
+	return h.engagement
+}
+
+// ServeHTTP routes the request, unknown paths and methods get the same JSON error body as everything else
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := h.mux.Handler(r); pattern == "" {
+		// let the mux decide between 404, 405 (with its Allow header) and a redirect
+		rec := &statusRecorder{header: http.Header{}}
+		h.mux.ServeHTTP(rec, r)
+		if rec.status >= 300 && rec.status < 400 {
+			for k, v := range rec.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.status)
+			return
+		}
+		if allow := rec.header.Get("Allow"); allow != "" {
+			w.Header().Set("Allow", allow)
+		}
+		code := "not_found"
+		if rec.status == http.StatusMethodNotAllowed {
+			code = "method_not_allowed"
+		}
+		writeError(w, rec.status, code, http.StatusText(rec.status), nil)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// statusRecorder captures the status and headers of the mux fallback handlers
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (r *statusRecorder) Header() http.Header         { return r.header }
+func (r *statusRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (r *statusRecorder) WriteHeader(status int)      { r.status = status }
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write response failed: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string, fields map[string]string) {
+	writeJSON(w, status, ErrorBody{Error: ErrorDetail{Code: code, Message: message, Fields: fields}})
+}
+
+// fail maps an error of the blog functions to a status code and error body
+func fail(w http.ResponseWriter, err error) {
+	var validation *ValidationError
+	switch {
+	case errors.As(err, &validation):
+		writeError(w, http.StatusUnprocessableEntity, "invalid_input", "the input is invalid", validation.Fields)
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error(), nil)
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrInvalidSession), errors.Is(err, ErrUnauthenticated):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "unauthorized", err.Error(), nil)
+	case errors.Is(err, ErrForbidden):
+		writeError(w, http.StatusForbidden, "forbidden", err.Error(), nil)
+	case errors.Is(err, ErrConflict):
+		writeError(w, http.StatusConflict, "conflict", err.Error(), nil)
+	default:
+		log.Printf("blog api: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "internal server error", nil)
+	}
+}
+
+// pathID parses the {id} path value
+func pathID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	return pathUint(w, r, "id")
+}
+
+// pathUint parses a numeric path value
+func pathUint(w http.ResponseWriter, r *http.Request, name string) (uint, bool) {
+	id, err := strconv.ParseUint(r.PathValue(name), 10, 32)
+	if err != nil || id == 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", name+" must be a positive integer", nil)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// bearerToken returns the token of the Authorization: Bearer header
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// actor returns the user of the bearer token, nil when there is no token;
+// an invalid token is answered with 401 right away
+func (h *Handler) actor(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, true
+	}
+	user, err := Authenticate(h.db, token)
+	if err != nil {
+		fail(w, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// pageRequest reads the paging query parameters
+func pageRequest(r *http.Request) (PageRequest, error) {
+	query := r.URL.Query()
+	req := PageRequest{Cursor: query.Get("cursor"), Sort: query.Get("sort")}
+	v := validator{}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		v.check(err == nil && n > 0, "limit", fmt.Sprintf("must be between 1 and %d", MaxPageSize))
+		req.Limit = n
+	}
+	if total := query.Get("total"); total != "" {
+		withTotal, err := strconv.ParseBool(total)
+		v.check(err == nil, "total", "must be true or false")
+		req.WithTotal = withTotal
+	}
+	return req, v.err()
+}
+
+// writePage writes a page of a listing as a ListBody
+func writePage[T, V any](w http.ResponseWriter, page *Page[T], err error, view func(*T) V) {
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	views := make([]V, len(page.Items))
+	for i := range page.Items {
+		views[i] = view(&page.Items[i])
+	}
+	writeJSON(w, http.StatusOK, ListBody{Data: views, NextCursor: page.NextCursor, HasMore: page.HasMore, Total: page.Total})
+}
+
+// decode reads a JSON body into v, unknown fields are rejected
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid JSON body: "+err.Error(), nil)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request) {
+	req, err := pageRequest(r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	viewer, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	users, err := ListUsers(h.db, viewer, req)
+	writePage(w, users, err, userViewFor(viewer))
+}
+
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	var in UserInput
+	if !decode(w, r, &in) {
+		return
+	}
+	user, err := CreateUser(h.db, in)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newUserView(user))
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	viewer, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	user, err := GetUser(h.db, id)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, userViewFor(viewer)(user))
+}
+
+func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	var patch UserPatch
+	if !decode(w, r, &patch) {
+		return
+	}
+	user, err := UpdateUser(h.db, actor, id, patch)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newUserView(user))
+}
+
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	if err := DeleteUser(h.db, actor, id); err != nil {
+		fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listUserPosts(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	var posts *Page[Post]
+	if status := r.URL.Query().Get("status"); status != "" {
+		posts, err = ListUserPostsByStatus(h.db, actor, id, status, req)
+	} else {
+		posts, err = ListUserPosts(h.db, id, req)
+	}
+	writePage(w, posts, err, newPostView)
+}
+
+func (h *Handler) listPosts(w http.ResponseWriter, r *http.Request) {
+	req, err := pageRequest(r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	posts, err := ListPosts(h.db, req)
+	writePage(w, posts, err, newPostView)
+}
+
+func (h *Handler) createPost(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	var in PostInput
+	if !decode(w, r, &in) {
+		return
+	}
+	post, err := CreatePost(h.db, actor, id, in)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newPostView(post))
+}
+
+func (h *Handler) getPost(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	viewer, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	post, err := GetVisiblePost(h.db, viewer, id)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	if post.Status == PostPublished {
+		h.engagement.View(id)
+	}
+	writeJSON(w, http.StatusOK, newPostView(post))
+}
+
+func (h *Handler) updatePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	var patch PostPatch
+	if !decode(w, r, &patch) {
+		return
+	}
+	post, err := UpdatePost(h.db, actor, id, patch)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPostView(post))
+}
+
+func (h *Handler) deletePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	if err := DeletePost(h.db, actor, id); err != nil {
+		fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// visiblePost answers 404 unless the post exists and the caller may see it
+func (h *Handler) visiblePost(w http.ResponseWriter, r *http.Request, id uint) bool {
+	viewer, ok := h.actor(w, r)
+	if !ok {
+		return false
+	}
+	if _, err := GetVisiblePost(h.db, viewer, id); err != nil {
+		fail(w, err)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) listRevisions(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	revisions, err := ListRevisions(h.db, actor, id, req)
+	writePage(w, revisions, err, newRevisionView)
+}
+
+func (h *Handler) getRevision(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	number, ok := pathUint(w, r, "number")
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	revision, err := GetRevision(h.db, actor, id, int(number))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newRevisionView(revision))
+}
+
+func (h *Handler) restoreRevision(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	number, ok := pathUint(w, r, "number")
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	post, err := RestoreRevision(h.db, actor, id, int(number))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPostView(post))
+}
+
+func (h *Handler) listPostComments(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok || !h.visiblePost(w, r, id) {
+		return
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	comments, err := ListPostComments(h.db, id, req)
+	writePage(w, comments, err, newCommentView)
+}
+
+func (h *Handler) createComment(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	var in CommentInput
+	if !decode(w, r, &in) {
+		return
+	}
+	comment, err := CreateComment(h.db, actor, id, in)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newCommentView(comment))
+}
+
+func (h *Handler) getComment(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	viewer, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	comment, err := GetComment(h.db, id)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	post, err := GetPost(h.db, comment.PostID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	if !CanSeePost(viewer, post) || !CanSeeComment(viewer, comment, post) {
+		fail(w, notFound("comment", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, newCommentView(comment))
+}
+
+func (h *Handler) updateComment(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	var patch CommentPatch
+	if !decode(w, r, &patch) {
+		return
+	}
+	comment, err := UpdateComment(h.db, actor, id, patch)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newCommentView(comment))
+}
+
+func (h *Handler) deleteComment(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	if err := DeleteComment(h.db, actor, id); err != nil {
+		fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listThreads(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	viewer, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	threads, err := ListThreads(h.db, viewer, id, req)
+	writePage(w, threads, err, func(n **CommentNode) ThreadView { return newThreadView(*n) })
+}
+
+func (h *Handler) getThread(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	viewer, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	thread, err := GetThread(h.db, viewer, id)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newThreadView(thread))
+}
+
+func (h *Handler) moderateComment(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	var in ModerationInput
+	if !decode(w, r, &in) {
+		return
+	}
+	comment, err := ModerateComment(h.db, actor, id, in.State)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newCommentView(comment))
+}
+
+func (h *Handler) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	comments, err := ListModerationQueue(h.db, actor, req)
+	writePage(w, comments, err, newCommentView)
+}
+
+func (h *Handler) listTags(w http.ResponseWriter, r *http.Request) {
+	counts, err := ListTags(h.db)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	views := make([]TagCountView, len(counts))
+	for i := range counts {
+		views[i] = TagCountView{TagView: newTagView(&counts[i].Tag), PostCount: counts[i].PostCount}
+	}
+	writeJSON(w, http.StatusOK, ListBody{Data: views})
+}
+
+func (h *Handler) listTagPosts(w http.ResponseWriter, r *http.Request) {
+	req, err := pageRequest(r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	posts, err := ListTagPosts(h.db, r.PathValue("slug"), req)
+	writePage(w, posts, err, newPostView)
+}
+
+func writeTags(w http.ResponseWriter, status int, tags []Tag) {
+	views := make([]TagView, len(tags))
+	for i := range tags {
+		views[i] = newTagView(&tags[i])
+	}
+	writeJSON(w, status, ListBody{Data: views})
+}
+
+func (h *Handler) postTags(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok || !h.visiblePost(w, r, id) {
+		return
+	}
+	tags, err := PostTags(h.db, id)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeTags(w, http.StatusOK, tags)
+}
+
+func (h *Handler) attachTags(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	var in TagsInput
+	if !decode(w, r, &in) {
+		return
+	}
+	tags, err := AttachTags(h.db, actor, id, in.Tags)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeTags(w, http.StatusOK, tags)
+}
+
+func (h *Handler) detachTag(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	if err := DetachTag(h.db, actor, id, r.PathValue("slug")); err != nil {
+		fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) categoryTree(w http.ResponseWriter, r *http.Request) {
+	roots, err := CategoryTree(h.db)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	views := make([]CategoryTreeView, len(roots))
+	for i, root := range roots {
+		views[i] = newCategoryTreeView(root)
+	}
+	writeJSON(w, http.StatusOK, ListBody{Data: views})
+}
+
+func (h *Handler) createCategory(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	var in CategoryInput
+	if !decode(w, r, &in) {
+		return
+	}
+	category, err := CreateCategory(h.db, actor, in)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newCategoryView(category))
+}
+
+func (h *Handler) listCategoryPosts(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	posts, err := ListCategoryPosts(h.db, id, req)
+	writePage(w, posts, err, newPostView)
+}
+
+func writeCategories(w http.ResponseWriter, status int, categories []Category) {
+	views := make([]CategoryView, len(categories))
+	for i := range categories {
+		views[i] = newCategoryView(&categories[i])
+	}
+	writeJSON(w, status, ListBody{Data: views})
+}
+
+func (h *Handler) postCategories(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok || !h.visiblePost(w, r, id) {
+		return
+	}
+	categories, err := PostCategories(h.db, id)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeCategories(w, http.StatusOK, categories)
+}
+
+func (h *Handler) attachCategories(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	var in CategoryIDsInput
+	if !decode(w, r, &in) {
+		return
+	}
+	categories, err := AttachCategories(h.db, actor, id, in.CategoryIDs)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeCategories(w, http.StatusOK, categories)
+}
+
+func (h *Handler) detachCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	categoryID, ok := pathUint(w, r, "category")
+	if !ok {
+		return
+	}
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+	if err := DetachCategory(h.db, actor, id, categoryID); err != nil {
+		fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeStats answers a like or reaction request with the counters of its target
+func (h *Handler) writeStats(w http.ResponseWriter, viewer *User, target string, id uint, changed *bool) {
+	stats, err := h.engagement.Stats(viewer, target, id)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, StatsView{Likes: stats.Likes, Views: stats.Views, Reactions: stats.Reactions, Changed: changed})
+}
+
+func (h *Handler) like(target string, like bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		actor, ok := h.actor(w, r)
+		if !ok {
+			return
+		}
+		var changed bool
+		var err error
+		if like {
+			changed, err = h.engagement.Like(actor, target, id)
+		} else {
+			changed, err = h.engagement.Unlike(actor, target, id)
+		}
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		h.writeStats(w, actor, target, id, &changed)
+	}
+}
+
+func (h *Handler) react(target string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		actor, ok := h.actor(w, r)
+		if !ok {
+			return
+		}
+		var in ReactionInput
+		if !decode(w, r, &in) {
+			return
+		}
+		changed, err := h.engagement.React(actor, target, id, in.Emoji)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		h.writeStats(w, actor, target, id, &changed)
+	}
+}
+
+func (h *Handler) unreact(target string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		actor, ok := h.actor(w, r)
+		if !ok {
+			return
+		}
+		changed, err := h.engagement.Unreact(actor, target, id, r.PathValue("emoji"))
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		h.writeStats(w, actor, target, id, &changed)
+	}
+}
+
+func (h *Handler) stats(target string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		viewer, ok := h.actor(w, r)
+		if !ok {
+			return
+		}
+		h.writeStats(w, viewer, target, id, nil)
+	}
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+	var in LoginInput
+	if !decode(w, r, &in) {
+		return
+	}
+	token, session, err := Login(h.db, in.Email, in.Password)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, SessionView{Token: token, UserID: session.UserID, ExpiresAt: session.ExpiresAt})
+}
+
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	if err := Logout(h.db, bearerToken(r)); err != nil {
+		fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) me(w http.ResponseWriter, r *http.Request) {
+	user, err := Authenticate(h.db, bearerToken(r))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newUserView(user))
+}
+
+func (h *Handler) searchDocuments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := SearchOptions{Kinds: query["kind"]}
+	v := validator{}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		v.check(err == nil && n > 0, "limit", fmt.Sprintf("must be between 1 and %d", MaxPageSize))
+		opts.Limit = n
+	}
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		v.check(err == nil && n >= 0, "offset", "must not be negative")
+		opts.Offset = n
+	}
+	if err := v.err(); err != nil {
+		fail(w, err)
+		return
+	}
+	result, err := h.search.Search(query.Get("q"), opts)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	views := make([]SearchHitView, len(result.Hits))
+	for i, hit := range result.Hits {
+		views[i] = SearchHitView{Kind: hit.Kind, ID: hit.ID, PostID: hit.PostID, Title: hit.Title, Snippet: hit.Snippet, Score: hit.Score}
+	}
+	total := result.Total
+	writeJSON(w, http.StatusOK, ListBody{Data: views, HasMore: int64(opts.Offset+len(views)) < total, Total: &total})
+}
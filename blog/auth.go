@@ -0,0 +1,209 @@
+package blog
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// errors of the authentication functions
+var (
+	ErrInvalidCredentials = errors.New("blog: invalid email or password")
+	ErrInvalidSession     = errors.New("blog: invalid or expired session")
+)
+
+// PasswordCost is the bcrypt cost of new password hashes
+var PasswordCost = bcrypt.DefaultCost
+
+// SessionTTL is how long a session token stays valid after login
+var SessionTTL = 24 * time.Hour
+
+// dummyHash is compared against when the email is unknown, so that a login
+// takes about as long for unknown emails as for wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("blog-dummy-password"), bcrypt.DefaultCost)
+
+// Session is a login session, only the SHA-256 of the token is stored
+type Session struct {
+	ID        uint   `gorm:"primaryKey"`
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+	UserID    uint   `gorm:"index"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// isPasswordHash reports whether s is already a bcrypt hash
+func isPasswordHash(s string) bool {
+	_, err := bcrypt.Cost([]byte(s))
+	return err == nil
+}
+
+// HashPassword hashes a plain text password with bcrypt
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
+	if err != nil {
+		return "", fmt.Errorf("hash password failed: %w", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches the stored password of the user
+func (u *User) CheckPassword(password string) bool {
+	if isPasswordHash(u.Password) {
+		return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+	}
+	// rows written before passwords were hashed
+	return u.Password != "" && subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1
+}
+
+// SetPassword hashes password and makes it the password of the user
+func (u *User) SetPassword(password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.Password, u.hashedPassword = hash, hash
+	return nil
+}
+
+// hashUnlessStored hashes the password of u unless it is the hash that
+// SetPassword made or that was loaded from the database. Whatever else is in
+// the field is a password, even when it looks like a bcrypt hash.
+func (u *User) hashUnlessStored() error {
+	if u.Password == "" || u.Password == u.hashedPassword {
+		return nil
+	}
+	return u.SetPassword(u.Password)
+}
+
+// AfterFind remembers the stored hash, so saving the user does not hash it again
+func (u *User) AfterFind(tx *gorm.DB) error {
+	if isPasswordHash(u.Password) {
+		u.hashedPassword = u.Password
+	}
+	return nil
+}
+
+// BeforeSave hashes the password on create, Save and updates, so no code
+// path can write a password in plain text. A stored hash is only written
+// back unchanged by UpdateColumn.
+func (u *User) BeforeSave(tx *gorm.DB) (err error) {
+	// Update("password", ...) and Updates(map) carry the new value in the statement
+	if updates, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		for _, key := range []string{"password", "Password"} {
+			if plain, ok := updates[key].(string); ok && plain != "" {
+				if updates[key], err = HashPassword(plain); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if dest, ok := tx.Statement.Dest.(*User); ok && dest != u {
+		if err := dest.hashUnlessStored(); err != nil {
+			return err
+		}
+	}
+	return u.hashUnlessStored()
+}
+
+// hashToken is the value stored for a session token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Login checks the email and password and starts a session, the returned
+// token is only known to the caller
+func Login(db *gorm.DB, email, password string) (string, *Session, error) {
+	var user User
+	err := db.Where("email = ?", strings.TrimSpace(email)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !user.CheckPassword(password) {
+		return "", nil, ErrInvalidCredentials
+	}
+	if !isPasswordHash(user.Password) {
+		// upgrade a legacy plain text password now that it is known to be right
+		hash, err := HashPassword(password)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := db.Model(&user).UpdateColumn("password", hash).Error; err != nil {
+			return "", nil, err
+		}
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(raw)
+	session := Session{TokenHash: hashToken(token), UserID: user.ID, ExpiresAt: time.Now().Add(SessionTTL)}
+	if err := db.Create(&session).Error; err != nil {
+		return "", nil, err
+	}
+	return token, &session, nil
+}
+
+// Authenticate returns the user of a valid session token
+func Authenticate(db *gorm.DB, token string) (*User, error) {
+	if token == "" {
+		return nil, ErrInvalidSession
+	}
+	var session Session
+	err := db.Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	user, err := GetUser(db, session.UserID)
+	if errors.Is(err, ErrNotFound) {
+		// the user was deleted after login
+		return nil, ErrInvalidSession
+	}
+	return user, err
+}
+
+// Logout revokes the session of a token
+func Logout(db *gorm.DB, token string) error {
+	result := db.Model(&Session{}).
+		Where("token_hash = ? AND revoked_at IS NULL", hashToken(token)).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidSession
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every open session of a user
+func RevokeUserSessions(db *gorm.DB, userID uint) error {
+	return db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// DeleteExpiredSessions removes sessions that expired or were revoked before cutoff
+func DeleteExpiredSessions(db *gorm.DB, cutoff time.Time) (int64, error) {
+	result := db.Where("expires_at < ? OR revoked_at < ?", cutoff, cutoff).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
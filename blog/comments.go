@@ -0,0 +1,146 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// CommentInput is the input of CreateComment, UserID defaults to the actor,
+// ParentID makes the comment a reply
+type CommentInput struct {
+	UserID   uint   `json:"user_id"`
+	ParentID *uint  `json:"parent_id"`
+	Content  string `json:"content"`
+}
+
+// CommentPatch is the input of UpdateComment, nil fields are left unchanged
+type CommentPatch struct {
+	Content *string `json:"content"`
+}
+
+func validComment(content string) bool {
+	return validContent(content) && len(content) <= 10000
+}
+
+// CreateComment validates and creates a comment or reply on a published
+// post on behalf of actor. Comments of the author of the post and of admins
+// are approved right away, the others wait for moderation. The comment hooks
+// place it in its thread and update the counters of the post in the same transaction.
+func CreateComment(db *gorm.DB, actor *User, postID uint, in CommentInput) (*Comment, error) {
+	if in.UserID == 0 && actor != nil {
+		in.UserID = actor.ID
+	}
+	if err := Authorize(actor, ActionCreateComment, in.UserID); err != nil {
+		return nil, err
+	}
+	if !validComment(in.Content) {
+		return nil, &ValidationError{Fields: map[string]string{"content": "is required and at most 10000 bytes"}}
+	}
+	post, err := GetVisiblePost(db, actor, postID)
+	if err != nil {
+		return nil, err
+	}
+	if post.Status != PostPublished {
+		return nil, fmt.Errorf("%w: post %d is %s and takes no comments", ErrConflict, postID, post.Status)
+	}
+	if _, err := GetUser(db, in.UserID); errors.Is(err, ErrNotFound) {
+		return nil, &ValidationError{Fields: map[string]string{"user_id": "user does not exist"}}
+	} else if err != nil {
+		return nil, err
+	}
+	if in.ParentID != nil {
+		parent, err := GetComment(db, *in.ParentID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return nil, &ValidationError{Fields: map[string]string{"parent_id": "comment does not exist"}}
+		case err != nil:
+			return nil, err
+		case parent.PostID != postID:
+			return nil, &ValidationError{Fields: map[string]string{"parent_id": "belongs to another post"}}
+		case parent.State != CommentApproved && !canModerate(actor, post):
+			return nil, &ValidationError{Fields: map[string]string{"parent_id": "is not approved"}}
+		case parent.Depth >= MaxCommentDepth:
+			return nil, &ValidationError{Fields: map[string]string{"parent_id": fmt.Sprintf("replies are limited to %d levels", MaxCommentDepth)}}
+		}
+	}
+	state := CommentPending
+	if canModerate(actor, post) {
+		state = CommentApproved
+	}
+	comment := Comment{Content: in.Content, UserID: in.UserID, PostID: postID, ParentID: in.ParentID, State: state}
+	if err := db.Create(&comment).Error; err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetComment loads a comment
+func GetComment(db *gorm.DB, id uint) (*Comment, error) {
+	var comment Comment
+	if err := db.First(&comment, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, notFound("comment", id)
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// ListPostComments loads a page of the approved comments of a post as a
+// flat list, oldest first by default; ListThreads nests the replies
+func ListPostComments(db *gorm.DB, postID uint, req PageRequest) (*Page[Comment], error) {
+	if _, err := GetPost(db, postID); err != nil {
+		return nil, err
+	}
+	query := db.Model(&Comment{}).Where("post_id = ? AND state = ?", postID, CommentApproved)
+	return paginate(query, req, "created_at", commentSortKeys)
+}
+
+// UpdateComment applies the non nil fields of patch on behalf of actor
+func UpdateComment(db *gorm.DB, actor *User, id uint, patch CommentPatch) (*Comment, error) {
+	comment, err := GetComment(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := Authorize(actor, ActionUpdateComment, comment.UserID); err != nil {
+		return nil, err
+	}
+	if patch.Content != nil && !validComment(*patch.Content) {
+		return nil, &ValidationError{Fields: map[string]string{"content": "is required and at most 10000 bytes"}}
+	}
+	if patch.Content != nil {
+		if err := db.Model(comment).Update("content", *patch.Content).Error; err != nil {
+			return nil, err
+		}
+	}
+	return comment, nil
+}
+
+// DeleteComment soft deletes a comment and its replies on behalf of actor,
+// the delete hook updates the counters of the post
+func DeleteComment(db *gorm.DB, actor *User, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		comment, err := GetComment(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := Authorize(actor, ActionDeleteComment, comment.UserID); err != nil {
+			return err
+		}
+		// deepest replies first, each one through the hooks
+		var subtree []Comment
+		err = tx.Where("root_id = ? AND path LIKE ?", comment.RootID, comment.Path+"%").
+			Order("path DESC").Find(&subtree).Error
+		if err != nil {
+			return err
+		}
+		for i := range subtree {
+			if err := tx.Delete(&subtree[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,150 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// counters kept by CounterBuffer, reactions are counted under their emoji
+const (
+	counterLikes = "likes"
+	counterViews = "views"
+)
+
+// counterKey names one buffered counter
+type counterKey struct {
+	target string // TargetPost or TargetComment
+	id     uint
+	name   string // counterLikes, counterViews or a reaction emoji
+}
+
+func (k counterKey) less(o counterKey) bool {
+	if k.target != o.target {
+		return k.target < o.target
+	}
+	if k.id != o.id {
+		return k.id < o.id
+	}
+	return k.name < o.name
+}
+
+// CounterBuffer is a write-behind buffer of counter increments. Hot counters
+// such as likes and views are added up in memory and written by Flush as
+// one UPDATE per counter, instead of one UPDATE per event on the same row.
+// Increments not flushed yet are lost if the process dies; likes and
+// reactions can then be recounted by RecomputeEngagementCounts.
+type CounterBuffer struct {
+	db       *gorm.DB
+	mu       sync.Mutex
+	pending  map[counterKey]int64
+	flushing map[counterKey]int64 // taken by the running flush, not committed yet
+	flushMu  sync.Mutex           // one flush at a time
+}
+
+func newCounterBuffer(db *gorm.DB) *CounterBuffer {
+	return &CounterBuffer{db: db, pending: map[counterKey]int64{}, flushing: map[counterKey]int64{}}
+}
+
+func (b *CounterBuffer) add(key counterKey, delta int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending[key] += delta
+}
+
+// unflushed returns the increments of a counter that are not in the database yet
+func (b *CounterBuffer) unflushed(key counterKey) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pending[key] + b.flushing[key]
+}
+
+// Pending returns the number of counters waiting for a flush
+func (b *CounterBuffer) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// Flush writes the buffered increments in one transaction. Counters are
+// written in key order, so that processes flushing at the same time lock
+// the rows in the same order. On failure the increments go back into the
+// buffer for the next flush.
+func (b *CounterBuffer) Flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	batch := b.pending
+	b.pending, b.flushing = map[counterKey]int64{}, batch
+	b.mu.Unlock()
+
+	keys := make([]counterKey, 0, len(batch))
+	for key, delta := range batch {
+		if delta != 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	err := b.db.Transaction(func(tx *gorm.DB) error {
+		for _, key := range keys {
+			if err := applyCounter(tx, key, batch[key]); err != nil {
+				return fmt.Errorf("flush counter %s of %s %d failed: %w", key.name, key.target, key.id, err)
+			}
+		}
+		return nil
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		for key, delta := range batch {
+			b.pending[key] += delta
+		}
+	}
+	b.flushing = map[counterKey]int64{}
+	return err
+}
+
+// applyCounter adds delta to the column or reaction count of a counter,
+// counters of deleted posts and comments are dropped
+func applyCounter(tx *gorm.DB, key counterKey, delta int64) error {
+	switch {
+	case key.name == counterLikes && key.target == TargetPost:
+		return tx.Model(&Post{}).Where("id = ?", key.id).UpdateColumn("like_count", gorm.Expr("like_count + ?", delta)).Error
+	case key.name == counterLikes && key.target == TargetComment:
+		return tx.Model(&Comment{}).Where("id = ?", key.id).UpdateColumn("like_count", gorm.Expr("like_count + ?", delta)).Error
+	case key.name == counterViews:
+		return tx.Model(&Post{}).Where("id = ?", key.id).UpdateColumn("view_count", gorm.Expr("view_count + ?", delta)).Error
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "target"}, {Name: "ref_id"}, {Name: "emoji"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("? + ?", clause.Column{Table: "reaction_counts", Name: "count"}, delta)}),
+	}).Create(&ReactionCount{Target: key.target, RefID: key.id, Emoji: key.name, Count: delta}).Error
+}
+
+// Run flushes every interval until ctx is cancelled, then flushes what is
+// left once more so that a clean shutdown loses nothing
+func (b *CounterBuffer) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := b.Flush(); err != nil {
+				log.Printf("flush counters failed: %v", err)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				log.Printf("flush counters failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package blog
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// countedComments counts the comments that make up Post.CommentCount for the
+// post of the outer UPDATE posts statement, the approved live ones
+const countedComments = "(SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.state = ? AND comments.deleted_at IS NULL)"
+
+// RecomputeCommentCounts sets the comment count and status of the given
+// posts from their comments in one statement. The hooks keep the counts
+// up to date by increments, this is for writes that skip them and for
+// fixing drift.
+func RecomputeCommentCounts(db *gorm.DB, postIDs ...uint) error {
+	if len(postIDs) == 0 {
+		return nil
+	}
+	return db.Model(&Post{}).Where("id IN ?", postIDs).UpdateColumns(map[string]interface{}{
+		"comment_count":  gorm.Expr(countedComments, CommentApproved),
+		"comment_status": gorm.Expr("CASE WHEN "+countedComments+" > 0 THEN ? ELSE ? END", CommentApproved, CommentStatusHas, CommentStatusNone),
+	}).Error
+}
+
+// CounterDrift is a post whose stored comment counter differs from its comments
+type CounterDrift struct {
+	PostID       uint
+	StoredCount  int
+	ActualCount  int
+	StoredStatus string
+	ActualStatus string
+}
+
+// CounterReport is the outcome of ReconcileCommentCounts
+type CounterReport struct {
+	Checked int            // posts compared
+	Drifts  []CounterDrift // posts found out of line
+	Fixed   bool           // whether the drifted posts were recomputed
+}
+
+// ReconcileCommentCounts compares the comment count and status of every post
+// with its comments, batch by batch, and with fix recomputes the posts that
+// drifted. The fix recounts at the time it runs, so comments written between
+// the comparison and the fix are not lost.
+func ReconcileCommentCounts(db *gorm.DB, fix bool) (*CounterReport, error) {
+	report := &CounterReport{Drifts: []CounterDrift{}, Fixed: fix}
+	var posts []Post
+	err := db.Select("id", "comment_count", "comment_status").
+		FindInBatches(&posts, 500, func(tx *gorm.DB, batch int) error {
+			ids := make([]uint, len(posts))
+			for i, post := range posts {
+				ids[i] = post.ID
+			}
+			var rows []struct {
+				PostID uint
+				Count  int
+			}
+			err := db.Model(&Comment{}).Select("post_id, COUNT(*) AS count").
+				Where("post_id IN ? AND state = ?", ids, CommentApproved).
+				Group("post_id").Scan(&rows).Error
+			if err != nil {
+				return err
+			}
+			actual := make(map[uint]int, len(rows))
+			for _, row := range rows {
+				actual[row.PostID] = row.Count
+			}
+			var drifted []uint
+			for _, post := range posts {
+				status := CommentStatusNone
+				if actual[post.ID] > 0 {
+					status = CommentStatusHas
+				}
+				if post.CommentCount != actual[post.ID] || post.CommentStatus != status {
+					report.Drifts = append(report.Drifts, CounterDrift{
+						PostID:       post.ID,
+						StoredCount:  post.CommentCount,
+						ActualCount:  actual[post.ID],
+						StoredStatus: post.CommentStatus,
+						ActualStatus: status,
+					})
+					drifted = append(drifted, post.ID)
+				}
+			}
+			report.Checked += len(posts)
+			if fix {
+				return RecomputeCommentCounts(db, drifted...)
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// RunCounterReconciler reconciles and fixes the comment counters every
+// interval until ctx is cancelled, logging the drift it finds
+func RunCounterReconciler(ctx context.Context, db *gorm.DB, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := ReconcileCommentCounts(db.WithContext(ctx), true)
+		if err != nil {
+			log.Printf("reconcile comment counts failed: %v", err)
+		} else {
+			for _, d := range report.Drifts {
+				log.Printf("post %d: comment count %d (%s), fixed to %d (%s)",
+					d.PostID, d.StoredCount, d.StoredStatus, d.ActualCount, d.ActualStatus)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package blog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around a change in a hunk
+const diffContext = 3
+
+// maxDiffCells bounds the table of diffLines, changes with more line pairs
+// are shown as a replacement of all changed lines
+const maxDiffCells = 1 << 22
+
+// lineOp is one line of a line diff
+type lineOp struct {
+	kind byte // ' ' kept, '-' removed, '+' added
+	text string
+}
+
+// splitLines splits text into lines, the empty text has none
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffLines returns the line operations that turn a into b. The common
+// prefix and suffix are cut off first, the lines in between are matched by
+// a longest common subsequence.
+func diffLines(a, b []string) []lineOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops := make([]lineOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, lineOp{' ', line})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, lineOp{' ', line})
+	}
+	return ops
+}
+
+func diffMiddle(a, b []string) []lineOp {
+	var ops []lineOp
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, lineOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, lineOp{'+', line})
+		}
+		return ops
+	}
+	// lcs[i*w+j] is the length of the longest common subsequence of a[i:] and b[j:]
+	w := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*w)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+				lcs[i*w+j] = lcs[(i+1)*w+j]
+			default:
+				lcs[i*w+j] = lcs[i*w+j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, lineOp{' ', a[i]})
+			i++
+			j++
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			ops = append(ops, lineOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, lineOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, lineOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, lineOp{'+', b[j]})
+	}
+	return ops
+}
+
+// unifiedDiff renders the changes from a to b as a unified diff with
+// diffContext lines of context, it is empty when nothing changed
+func unifiedDiff(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	oldLine, newLine := 0, 0 // lines of a and b before ops[pos]
+	pos := 0
+	for {
+		first := pos
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		// the hunk takes the following changes up to 2*diffContext unchanged lines apart
+		end := first + 1
+		for k := end; k < len(ops) && k-end < 2*diffContext; k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			}
+		}
+		start, stop := max(first-diffContext, pos), min(end+diffContext, len(ops))
+		// the lines between the hunks are unchanged
+		oldLine, newLine = oldLine+start-pos, newLine+start-pos
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:stop] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, op := range ops[start:stop] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.text)
+			out.WriteByte('\n')
+		}
+		oldLine, newLine, pos = oldLine+oldCount, newLine+newCount, stop
+	}
+	return out.String()
+}
+
+// hunkRange formats the start and length of a hunk side, an empty side
+// starts at the line before it
+func hunkRange(before, count int) string {
+	if count == 1 {
+		return fmt.Sprint(before + 1)
+	}
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
@@ -0,0 +1,264 @@
+package blog
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// targets of likes and reactions
+const (
+	TargetPost    = "post"
+	TargetComment = "comment"
+)
+
+// ReactionEmojis are the reactions users may add
+var ReactionEmojis = []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}
+
+// ValidReaction reports whether emoji is one of ReactionEmojis
+func ValidReaction(emoji string) bool {
+	for _, e := range ReactionEmojis {
+		if e == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// Like is the like of a user on a post or comment, the unique index makes
+// liking the same target twice a no-op
+type Like struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"uniqueIndex:idx_likes_user_target"`
+	Target    string `gorm:"size:16;uniqueIndex:idx_likes_user_target;index:idx_likes_target"`
+	RefID     uint   `gorm:"uniqueIndex:idx_likes_user_target;index:idx_likes_target"`
+	CreatedAt time.Time
+}
+
+// Reaction is an emoji reaction of a user on a post or comment, a user may
+// add each emoji once per target
+type Reaction struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"uniqueIndex:idx_reactions_user_target"`
+	Target    string `gorm:"size:16;uniqueIndex:idx_reactions_user_target"`
+	RefID     uint   `gorm:"uniqueIndex:idx_reactions_user_target"`
+	Emoji     string `gorm:"size:16;uniqueIndex:idx_reactions_user_target"`
+	CreatedAt time.Time
+}
+
+// ReactionCount is the number of reactions with an emoji on a target,
+// maintained by the CounterBuffer
+type ReactionCount struct {
+	Target string `gorm:"primaryKey;size:16"`
+	RefID  uint   `gorm:"primaryKey;autoIncrement:false"`
+	Emoji  string `gorm:"primaryKey;size:16"`
+	Count  int64
+}
+
+// Stats are the engagement counters of a post or comment, including the
+// increments that are not flushed yet
+type Stats struct {
+	Likes     int64
+	Views     int64 // posts only
+	Reactions map[string]int64
+}
+
+// Engagement records likes, reactions and views. Every like and reaction is
+// a row, written right away, which gives exactly once semantics per user;
+// the counters derived from them go through a CounterBuffer.
+type Engagement struct {
+	db       *gorm.DB
+	counters *CounterBuffer
+}
+
+// NewEngagement creates the engagement service, its counters are only
+// written by Flush or Run
+func NewEngagement(db *gorm.DB) *Engagement {
+	return &Engagement{db: db, counters: newCounterBuffer(db)}
+}
+
+// Counters returns the counter buffer
+func (e *Engagement) Counters() *CounterBuffer {
+	return e.counters
+}
+
+// Flush writes the buffered counters
+func (e *Engagement) Flush() error {
+	return e.counters.Flush()
+}
+
+// Run flushes the counters every interval until ctx is cancelled
+func (e *Engagement) Run(ctx context.Context, interval time.Duration) error {
+	return e.counters.Run(ctx, interval)
+}
+
+// checkTarget makes sure the target exists and viewer may see it
+func (e *Engagement) checkTarget(viewer *User, target string, id uint) error {
+	switch target {
+	case TargetPost:
+		_, err := GetVisiblePost(e.db, viewer, id)
+		return err
+	case TargetComment:
+		comment, err := GetComment(e.db, id)
+		if err != nil {
+			return err
+		}
+		post, err := GetPost(e.db, comment.PostID)
+		if err != nil {
+			return err
+		}
+		if !CanSeePost(viewer, post) || !CanSeeComment(viewer, comment, post) {
+			return notFound("comment", id)
+		}
+		return nil
+	}
+	return &ValidationError{Fields: map[string]string{"target": "must be post or comment"}}
+}
+
+// authorizeReaction checks that actor may like and react, the owner of a
+// like is the user who gives it
+func authorizeReaction(actor *User) error {
+	if actor == nil {
+		return ErrUnauthenticated
+	}
+	return Authorize(actor, ActionReact, actor.ID)
+}
+
+// Like likes a target on behalf of actor and reports whether it was not
+// liked before
+func (e *Engagement) Like(actor *User, target string, id uint) (bool, error) {
+	if err := authorizeReaction(actor); err != nil {
+		return false, err
+	}
+	if err := e.checkTarget(actor, target, id); err != nil {
+		return false, err
+	}
+	result := e.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Like{UserID: actor.ID, Target: target, RefID: id})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	e.counters.add(counterKey{target, id, counterLikes}, 1)
+	return true, nil
+}
+
+// Unlike takes back the like of actor and reports whether there was one
+func (e *Engagement) Unlike(actor *User, target string, id uint) (bool, error) {
+	if err := authorizeReaction(actor); err != nil {
+		return false, err
+	}
+	result := e.db.Where("user_id = ? AND target = ? AND ref_id = ?", actor.ID, target, id).Delete(&Like{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	e.counters.add(counterKey{target, id, counterLikes}, -1)
+	return true, nil
+}
+
+// React adds an emoji reaction of actor and reports whether it is new
+func (e *Engagement) React(actor *User, target string, id uint, emoji string) (bool, error) {
+	if err := authorizeReaction(actor); err != nil {
+		return false, err
+	}
+	if !ValidReaction(emoji) {
+		return false, &ValidationError{Fields: map[string]string{"emoji": "is not a known reaction"}}
+	}
+	if err := e.checkTarget(actor, target, id); err != nil {
+		return false, err
+	}
+	result := e.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Reaction{UserID: actor.ID, Target: target, RefID: id, Emoji: emoji})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	e.counters.add(counterKey{target, id, emoji}, 1)
+	return true, nil
+}
+
+// Unreact removes an emoji reaction of actor and reports whether there was one
+func (e *Engagement) Unreact(actor *User, target string, id uint, emoji string) (bool, error) {
+	if err := authorizeReaction(actor); err != nil {
+		return false, err
+	}
+	result := e.db.Where("user_id = ? AND target = ? AND ref_id = ? AND emoji = ?", actor.ID, target, id, emoji).Delete(&Reaction{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	e.counters.add(counterKey{target, id, emoji}, -1)
+	return true, nil
+}
+
+// View counts a view of a post, views are not tied to users and exist only
+// as a counter
+func (e *Engagement) View(postID uint) {
+	e.counters.add(counterKey{TargetPost, postID, counterViews}, 1)
+}
+
+// Stats loads the counters of a target visible to viewer
+func (e *Engagement) Stats(viewer *User, target string, id uint) (*Stats, error) {
+	if err := e.checkTarget(viewer, target, id); err != nil {
+		return nil, err
+	}
+	stats := &Stats{Reactions: map[string]int64{}}
+	if target == TargetPost {
+		var post Post
+		if err := e.db.Select("like_count", "view_count").First(&post, id).Error; err != nil {
+			return nil, err
+		}
+		stats.Likes, stats.Views = post.LikeCount, post.ViewCount
+	} else {
+		var comment Comment
+		if err := e.db.Select("like_count").First(&comment, id).Error; err != nil {
+			return nil, err
+		}
+		stats.Likes = comment.LikeCount
+	}
+	stats.Likes += e.counters.unflushed(counterKey{target, id, counterLikes})
+	stats.Views += e.counters.unflushed(counterKey{target, id, counterViews})
+
+	var counts []ReactionCount
+	if err := e.db.Where("target = ? AND ref_id = ?", target, id).Find(&counts).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		stats.Reactions[c.Emoji] = c.Count
+	}
+	for _, emoji := range ReactionEmojis {
+		if n := stats.Reactions[emoji] + e.counters.unflushed(counterKey{target, id, emoji}); n > 0 {
+			stats.Reactions[emoji] = n
+		} else {
+			delete(stats.Reactions, emoji)
+		}
+	}
+	return stats, nil
+}
+
+// RecomputeEngagementCounts recounts the like counts and reaction counts
+// from the likes and reactions, for increments lost with a process that
+// died before flushing. Buffers of running processes must be flushed first,
+// or their increments are counted twice.
+func RecomputeEngagementCounts(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Post{}).Where("1 = 1").UpdateColumn("like_count",
+			gorm.Expr("(SELECT COUNT(*) FROM likes WHERE likes.target = ? AND likes.ref_id = posts.id)", TargetPost)).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&Comment{}).Where("1 = 1").UpdateColumn("like_count",
+			gorm.Expr("(SELECT COUNT(*) FROM likes WHERE likes.target = ? AND likes.ref_id = comments.id)", TargetComment)).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("1 = 1").Delete(&ReactionCount{}).Error; err != nil {
+			return err
+		}
+		return tx.Exec("INSERT INTO reaction_counts (target, ref_id, emoji, count) " +
+			"SELECT target, ref_id, emoji, COUNT(*) FROM reactions GROUP BY target, ref_id, emoji").Error
+	})
+}
@@ -0,0 +1,70 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// sentinel errors returned by the blog functions, use errors.Is to branch on them
+var (
+	ErrNotFound = errors.New("blog: not found")
+	ErrConflict = errors.New("blog: conflict")
+)
+
+// mysqlErrDuplicateEntry is the MySQL error number of a unique key violation
+const mysqlErrDuplicateEntry = 1062
+
+// ValidationError lists the invalid input fields with a message per field
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ": " + e.Fields[name]
+	}
+	return "blog: invalid input (" + strings.Join(parts, ", ") + ")"
+}
+
+// validator collects field errors
+type validator map[string]string
+
+func (v validator) check(ok bool, field, message string) {
+	if !ok {
+		if _, exists := v[field]; !exists {
+			v[field] = message
+		}
+	}
+}
+
+func (v validator) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v}
+}
+
+// notFound wraps ErrNotFound with the kind and id of the missing record
+func notFound(kind string, id uint) error {
+	return fmt.Errorf("%w: %s %d", ErrNotFound, kind, id)
+}
+
+// isDuplicateKey reports whether err is a unique key violation
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDuplicateEntry
+	}
+	// SQLite has no typed error through GORM's driver wrapper
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,248 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// comment status values of a post
+const (
+	CommentStatusHas  = "有评论"
+	CommentStatusNone = "无评论"
+)
+
+// User model definition
+type User struct {
+	gorm.Model
+	Name     string
+	Email    string `gorm:"uniqueIndex;size:255"`
+	Password string `json:"-"`                      // bcrypt hash, see BeforeSave
+	Role     string `gorm:"size:16;default:author"` // RoleAdmin, RoleAuthor or RoleReader
+	Posts    []Post `gorm:"foreignKey:UserID"`
+
+	hashedPassword string // the hash in Password, set by SetPassword and AfterFind
+}
+
+// Post model definition
+type Post struct {
+	gorm.Model
+	Title         string `gorm:"size:255"`
+	Content       string `gorm:"type:text"`
+	UserID        uint
+	User          User
+	Comments      []Comment  `gorm:"foreignKey:PostID"`
+	Tags          []Tag      `gorm:"many2many:post_tags"`
+	Categories    []Category `gorm:"many2many:post_categories"`
+	CommentCount  int        `gorm:"default:0;check:comment_count >= 0"`                   // add constraint to ensure the count is not negative
+	CommentStatus string     `gorm:"default:'有评论';check:comment_status IN ('有评论', '无评论')"` // add constraint to ensure the status is valid
+	LikeCount     int64      `gorm:"default:0"`                                            // written behind by Engagement
+	ViewCount     int64      `gorm:"default:0"`                                            // written behind by Engagement
+	Status        string     `gorm:"size:16;default:published;index"`                      // PostDraft, PostScheduled, PostPublished or PostArchived
+	PublishAt     *time.Time `gorm:"index"`                                                // when a scheduled post goes live, or when a post went live
+}
+
+// Comment model definition, replies form a tree through ParentID. Path is
+// the materialized path of the comment (the fixed width ids of its ancestors
+// and itself), so ordering a thread by path lists it depth first.
+type Comment struct {
+	gorm.Model
+	Content   string `gorm:"type:text"`
+	UserID    uint
+	PostID    uint
+	ParentID  *uint  `gorm:"index"`
+	RootID    uint   `gorm:"index"` // id of the top level comment of the thread
+	Path      string `gorm:"size:600;index"`
+	Depth     int
+	State     string `gorm:"size:16;default:approved;index"` // CommentPending, CommentApproved, CommentRejected or CommentSpam
+	LikeCount int64  `gorm:"default:0"`                      // written behind by Engagement
+	User      User   `gorm:"foreignKey:UserID"`
+	Post      Post   `gorm:"foreignKey:PostID"`
+
+	// set by BeforeDelete for AfterDelete
+	counted      bool   // the comment is still in the count of its post
+	touchedPosts []uint // posts of a delete by condition
+}
+
+// AutoMigrate creates or updates the tables used by the blog package
+func AutoMigrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&User{}, &Tag{}, &Category{}, &Post{}, &Comment{}, &Session{}, &SearchDocument{},
+		&Like{}, &Reaction{}, &ReactionCount{}, &PostRevision{}); err != nil {
+		return err
+	}
+	if err := createListIndexes(db); err != nil {
+		return err
+	}
+	if err := backfillPublishAt(db); err != nil {
+		return err
+	}
+	return backfillCommentPaths(db)
+}
+
+// updateCommentCount moves the comment count of a post by delta and keeps
+// the comment status in line with it
+func updateCommentCount(tx *gorm.DB, postID uint, delta int) error {
+	// use atomic operation to change the comment count, avoid race condition
+	result := tx.Model(&Post{}).
+		Where("id = ?", postID).
+		UpdateColumn("comment_count", gorm.Expr("comment_count + ?", delta))
+
+	if result.Error != nil {
+		return fmt.Errorf("update post comment count failed: %v", result.Error)
+	}
+
+	// check the updated rows, ensure the post exists
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("post with id %d not found", postID)
+	}
+
+	// use transaction level query to check the status
+	var post Post
+	if err := tx.Select("comment_count, comment_status").
+		Where("id = ?", postID).
+		First(&post).Error; err != nil {
+		return fmt.Errorf("query post failed: %v", err)
+	}
+
+	// if the post has comments again, update the status
+	if post.CommentCount > 0 && post.CommentStatus != CommentStatusHas {
+		if err := tx.Model(&Post{}).
+			Where("id = ? AND comment_status != ?", postID, CommentStatusHas).
+			Update("comment_status", CommentStatusHas).Error; err != nil {
+			return fmt.Errorf("update post status failed: %v", err)
+		}
+	}
+
+	// if the comment count is 0, update the status
+	if post.CommentCount == 0 && post.CommentStatus != CommentStatusNone {
+		if err := tx.Model(&Post{}).
+			Where("id = ? AND comment_status != ?", postID, CommentStatusNone).
+			Update("comment_status", CommentStatusNone).Error; err != nil {
+			return fmt.Errorf("update post status failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// BeforeCreate derives the comment status of a new post from its comment
+// count, the column default would mark a post without comments as 有评论.
+// Posts created without a status, such as seed data, are published right away.
+func (p *Post) BeforeCreate(tx *gorm.DB) error {
+	if p.CommentStatus == "" {
+		p.CommentStatus = CommentStatusNone
+		if p.CommentCount > 0 {
+			p.CommentStatus = CommentStatusHas
+		}
+	}
+	if p.Status == "" {
+		p.Status = PostPublished
+	}
+	if p.Status == PostPublished && p.PublishAt == nil {
+		now := time.Now()
+		p.PublishAt = &now
+	}
+	return nil
+}
+
+// BeforeCreate treats comments created without a state, such as seed data,
+// as approved; CreateComment always sets the state
+func (c *Comment) BeforeCreate(tx *gorm.DB) error {
+	if c.State == "" {
+		c.State = CommentApproved
+	}
+	return nil
+}
+
+// safer comment create hook function, only approved comments are counted
+func (c *Comment) AfterCreate(tx *gorm.DB) error {
+	if err := c.placeInThread(tx); err != nil {
+		return err
+	}
+	if c.State == CommentApproved {
+		if err := updateCommentCount(tx, c.PostID, 1); err != nil {
+			return err
+		}
+	}
+	return indexComment(tx, c.ID)
+}
+
+// BeforeDelete looks up what the delete takes out of the comment counts. A
+// comment given by id is checked against the stored row, so that deleting
+// it again, or hard deleting a soft deleted one, does not count it twice.
+// For a delete by condition, which has no comment to go by, it records the
+// posts the condition touches. A comment of a deleted slice records its post
+// too, as RowsAffected counts the whole slice rather than the comment.
+func (c *Comment) BeforeDelete(tx *gorm.DB) error {
+	if c.ID != 0 && deletesSlice(tx) {
+		var stored Comment
+		err := tx.Unscoped().Select("post_id").Where("id = ?", c.ID).Take(&stored).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.touchedPosts = nil
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("query comment failed: %v", err)
+		}
+		c.touchedPosts = []uint{stored.PostID}
+		return nil
+	}
+	if c.ID != 0 {
+		var stored Comment
+		err := tx.Unscoped().Select("id", "post_id", "state", "deleted_at").Where("id = ?", c.ID).Take(&stored).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.counted = false
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("query comment failed: %v", err)
+		}
+		c.PostID = stored.PostID
+		c.counted = stored.State == CommentApproved && !stored.DeletedAt.Valid
+		return nil
+	}
+	query := tx.Model(&Comment{})
+	if tx.Statement.Unscoped {
+		query = query.Unscoped()
+	}
+	if where, ok := tx.Statement.Clauses["WHERE"].Expression.(clause.Where); ok {
+		query = query.Clauses(where)
+	}
+	if err := query.Distinct().Pluck("post_id", &c.touchedPosts).Error; err != nil {
+		return fmt.Errorf("query comment posts failed: %v", err)
+	}
+	return nil
+}
+
+// safer comment delete hook function, only counted comments that the
+// statement really deleted are taken off; a delete by condition or of a
+// slice recounts the posts it touched
+func (c *Comment) AfterDelete(tx *gorm.DB) error {
+	if c.ID == 0 || deletesSlice(tx) {
+		if len(c.touchedPosts) == 0 {
+			return nil
+		}
+		if err := RecomputeCommentCounts(tx, c.touchedPosts...); err != nil {
+			return fmt.Errorf("recompute comment counts failed: %v", err)
+		}
+		return unindex(tx, "kind = ? AND post_id IN ? AND ref_id NOT IN (?)",
+			SearchKindComment, c.touchedPosts, tx.Model(&Comment{}).Select("id"))
+	}
+	if c.counted && tx.Statement.RowsAffected > 0 {
+		if err := updateCommentCount(tx, c.PostID, -1); err != nil {
+			return err
+		}
+	}
+	return unindex(tx, "kind = ? AND ref_id = ?", SearchKindComment, c.ID)
+}
+
+// deletesSlice reports whether the statement deletes a slice of comments,
+// whose hooks run once per element
+func deletesSlice(tx *gorm.DB) bool {
+	kind := tx.Statement.ReflectValue.Kind()
+	return kind == reflect.Slice || kind == reflect.Array
+}
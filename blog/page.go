@@ -0,0 +1,216 @@
+package blog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// page sizes of the list functions
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// PageRequest selects a page of a listing. Sort is a field name, prefixed
+// with "-" for descending order, empty means the default order of the
+// listing. Cursor is the NextCursor of the previous page, empty for the
+// first page. Limit is capped at MaxPageSize, 0 means DefaultPageSize.
+// WithTotal also counts all matching records, which costs an extra query.
+type PageRequest struct {
+	Cursor    string
+	Limit     int
+	Sort      string
+	WithTotal bool
+}
+
+// Page is one page of a listing, NextCursor is empty on the last page
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+	HasMore    bool
+	Total      *int64
+}
+
+// sortKey returns the value of the sort column and the id of an item
+type sortKey[T any] func(item *T) (interface{}, uint)
+
+// cursor is the decoded form of PageRequest.Cursor, it records the sort it
+// was made for and the sort value and id of the last item of the page
+type cursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+func encodeCursor(sort string, value interface{}, id uint) (string, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(cursor{Sort: sort, Value: raw, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// paginate runs query for one page ordered by the sort column and then id,
+// the next page starts after the (value, id) of the last item so it is
+// stable under inserts and deletes, unlike OFFSET paging
+func paginate[T any](query *gorm.DB, req PageRequest, defaultSort string, keys map[string]sortKey[T]) (*Page[T], error) {
+	v := validator{}
+	spec := req.Sort
+	if spec == "" {
+		spec = defaultSort
+	}
+	column, desc := strings.TrimPrefix(spec, "-"), strings.HasPrefix(spec, "-")
+	key, ok := keys[column]
+	if !ok {
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		v.check(false, "sort", "must be one of "+strings.Join(names, ", ")+", optionally prefixed with -")
+	}
+	v.check(req.Limit >= 0, "limit", fmt.Sprintf("must be between 1 and %d", MaxPageSize))
+	var after *cursor
+	if req.Cursor != "" {
+		var err error
+		after, err = decodeCursor(req.Cursor)
+		v.check(err == nil, "cursor", "is malformed")
+		v.check(err != nil || after.Sort == spec, "cursor", "was made for another sort order")
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	page := &Page[T]{}
+	if req.WithTotal {
+		var total int64
+		if err := query.Session(&gorm.Session{}).Model(new(T)).Count(&total).Error; err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	order, op := "ASC", ">"
+	if desc {
+		order, op = "DESC", "<"
+	}
+	q := query.Session(&gorm.Session{})
+	if after != nil {
+		// decode the value into the type the key returns, so times compare as times
+		var zero T
+		sample, _ := key(&zero)
+		value := reflect.New(reflect.TypeOf(sample))
+		if err := json.Unmarshal(after.Value, value.Interface()); err != nil {
+			return nil, &ValidationError{Fields: map[string]string{"cursor": "is malformed"}}
+		}
+		q = q.Where(
+			fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, op, column, op),
+			value.Elem().Interface(), value.Elem().Interface(), after.ID)
+	}
+	var items []T
+	err := q.Order(fmt.Sprintf("%s %s, id %s", column, order, order)).
+		Limit(limit + 1).
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(items) > limit {
+		items = items[:limit]
+		page.HasMore = true
+		value, id := key(&items[limit-1])
+		if page.NextCursor, err = encodeCursor(spec, value, id); err != nil {
+			return nil, err
+		}
+	}
+	page.Items = items
+	return page, nil
+}
+
+// sort keys of the listings, each maps a sortable column to its value
+var (
+	userSortKeys = map[string]sortKey[User]{
+		"id":         func(u *User) (interface{}, uint) { return u.ID, u.ID },
+		"created_at": func(u *User) (interface{}, uint) { return u.CreatedAt, u.ID },
+		"name":       func(u *User) (interface{}, uint) { return u.Name, u.ID },
+	}
+	// admins see every email, so they may also sort by it
+	adminUserSortKeys = map[string]sortKey[User]{
+		"id":         func(u *User) (interface{}, uint) { return u.ID, u.ID },
+		"created_at": func(u *User) (interface{}, uint) { return u.CreatedAt, u.ID },
+		"name":       func(u *User) (interface{}, uint) { return u.Name, u.ID },
+		"email":      func(u *User) (interface{}, uint) { return u.Email, u.ID },
+	}
+	postSortKeys = map[string]sortKey[Post]{
+		"id":            func(p *Post) (interface{}, uint) { return p.ID, p.ID },
+		"created_at":    func(p *Post) (interface{}, uint) { return p.CreatedAt, p.ID },
+		"updated_at":    func(p *Post) (interface{}, uint) { return p.UpdatedAt, p.ID },
+		"title":         func(p *Post) (interface{}, uint) { return p.Title, p.ID },
+		"comment_count": func(p *Post) (interface{}, uint) { return p.CommentCount, p.ID },
+		"like_count":    func(p *Post) (interface{}, uint) { return p.LikeCount, p.ID },
+		"view_count":    func(p *Post) (interface{}, uint) { return p.ViewCount, p.ID },
+	}
+	commentSortKeys = map[string]sortKey[Comment]{
+		"id":         func(c *Comment) (interface{}, uint) { return c.ID, c.ID },
+		"created_at": func(c *Comment) (interface{}, uint) { return c.CreatedAt, c.ID },
+		"updated_at": func(c *Comment) (interface{}, uint) { return c.UpdatedAt, c.ID },
+	}
+)
+
+// listIndexes back the default orders of the listings
+var listIndexes = []struct {
+	model   interface{}
+	table   string
+	name    string
+	columns string
+}{
+	{&User{}, "users", "idx_users_created_at_id", "created_at, id"},
+	{&Post{}, "posts", "idx_posts_created_at_id", "created_at, id"},
+	{&Post{}, "posts", "idx_posts_user_created_at_id", "user_id, created_at, id"},
+	{&Comment{}, "comments", "idx_comments_post_created_at_id", "post_id, created_at, id"},
+	// the join tables are keyed by post first, listing by tag or category needs the other way round
+	{"post_tags", "post_tags", "idx_post_tags_tag_post", "tag_id, post_id"},
+	{"post_categories", "post_categories", "idx_post_categories_category_post", "category_id, post_id"},
+}
+
+// createListIndexes adds the composite indexes of listIndexes, they are not
+// expressible as struct tags because the columns come from gorm.Model
+func createListIndexes(db *gorm.DB) error {
+	for _, index := range listIndexes {
+		if db.Migrator().HasIndex(index.model, index.name) {
+			continue
+		}
+		if err := db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s)", index.name, index.table, index.columns)).Error; err != nil {
+			return fmt.Errorf("create index %s failed: %w", index.name, err)
+		}
+	}
+	return nil
+}
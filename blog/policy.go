@@ -0,0 +1,101 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errors of the policy layer
+var (
+	ErrUnauthenticated = errors.New("blog: authentication required")
+	ErrForbidden       = errors.New("blog: forbidden")
+)
+
+// user roles
+const (
+	RoleAdmin  = "admin"  // may do everything
+	RoleAuthor = "author" // may write posts and comments
+	RoleReader = "reader" // may write comments
+)
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleAuthor || role == RoleReader
+}
+
+// Action is something an actor wants to do with a resource
+type Action string
+
+// actions checked by Authorize
+const (
+	ActionCreatePost      Action = "post:create"
+	ActionUpdatePost      Action = "post:update"
+	ActionDeletePost      Action = "post:delete"
+	ActionPublishPost     Action = "post:publish" // publishing and scheduling, the owner is the author of the post
+	ActionCreateComment   Action = "comment:create"
+	ActionUpdateComment   Action = "comment:update"
+	ActionDeleteComment   Action = "comment:delete"
+	ActionModerateComment Action = "comment:moderate" // the owner is the author of the post
+	ActionReact           Action = "reaction:create"  // likes and reactions, the owner is the user who gives them
+	ActionUpdateUser      Action = "user:update"
+	ActionDeleteUser      Action = "user:delete"
+	ActionSetRole         Action = "user:set-role"
+	// no role has it, so only admins manage the category tree; the owner is 0
+	ActionManageCategories Action = "category:manage"
+)
+
+// Actions lists every action, in the order of the permission matrix
+var Actions = []Action{
+	ActionCreatePost, ActionUpdatePost, ActionDeletePost, ActionPublishPost,
+	ActionCreateComment, ActionUpdateComment, ActionDeleteComment, ActionModerateComment,
+	ActionReact,
+	ActionUpdateUser, ActionDeleteUser, ActionSetRole,
+	ActionManageCategories,
+}
+
+// roleActions are the actions a role may do on resources the actor owns,
+// admins may do every action on every resource
+var roleActions = map[string]map[Action]bool{
+	RoleAuthor: {
+		ActionCreatePost: true, ActionUpdatePost: true, ActionDeletePost: true, ActionPublishPost: true,
+		ActionCreateComment: true, ActionUpdateComment: true, ActionDeleteComment: true, ActionModerateComment: true,
+		ActionReact: true, ActionUpdateUser: true, ActionDeleteUser: true,
+	},
+	RoleReader: {
+		// a reader keeps control over posts written before a demotion, but cannot write or publish new ones
+		ActionUpdatePost: true, ActionDeletePost: true,
+		ActionCreateComment: true, ActionUpdateComment: true, ActionDeleteComment: true, ActionModerateComment: true,
+		ActionReact: true, ActionUpdateUser: true, ActionDeleteUser: true,
+	},
+}
+
+// Authorize checks whether actor may do action on a resource owned by ownerID.
+// For posts and comments the owner is their UserID, for moderating comments
+// it is the author of the post, for users it is the user itself, for
+// creations it is the user the new record will belong to.
+// A nil actor is an anonymous caller.
+func Authorize(actor *User, action Action, ownerID uint) error {
+	if actor == nil {
+		return ErrUnauthenticated
+	}
+	if actor.Role == RoleAdmin {
+		return nil
+	}
+	if actor.ID == ownerID && roleActions[actor.Role][action] {
+		return nil
+	}
+	return fmt.Errorf("%w: %s may not %s", ErrForbidden, actorName(actor), action)
+}
+
+// Can is Authorize as a boolean
+func Can(actor *User, action Action, ownerID uint) bool {
+	return Authorize(actor, action, ownerID) == nil
+}
+
+func actorName(actor *User) string {
+	role := actor.Role
+	if role == "" {
+		role = "user"
+	}
+	return fmt.Sprintf("%s %d", role, actor.ID)
+}
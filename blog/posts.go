@@ -0,0 +1,207 @@
+package blog
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// PostInput is the input of CreatePost, Status defaults to PostPublished
+// and PublishAt is only taken by scheduled posts
+type PostInput struct {
+	Title     string     `json:"title"`
+	Content   string     `json:"content"`
+	Status    string     `json:"status"`
+	PublishAt *time.Time `json:"publish_at"`
+}
+
+// PostPatch is the input of UpdatePost, nil fields are left unchanged
+type PostPatch struct {
+	Title     *string    `json:"title"`
+	Content   *string    `json:"content"`
+	Status    *string    `json:"status"`
+	PublishAt *time.Time `json:"publish_at"`
+
+	restoredFrom *int // set by RestoreRevision
+}
+
+func validTitle(title string) bool {
+	return strings.TrimSpace(title) != "" && utf8.RuneCountInString(title) <= 255
+}
+
+func validContent(content string) bool {
+	return strings.TrimSpace(content) != "" && len(content) <= 65535
+}
+
+func (in PostInput) validate(now time.Time) error {
+	v := validator{}
+	v.check(validTitle(in.Title), "title", "is required and at most 255 characters")
+	v.check(validContent(in.Content), "content", "is required and at most 64KB")
+	checkPublishing(v, in.Status, in.PublishAt, now)
+	return v.err()
+}
+
+func (p PostPatch) validate() error {
+	v := validator{}
+	v.check(p.Title == nil || validTitle(*p.Title), "title", "is required and at most 255 characters")
+	v.check(p.Content == nil || validContent(*p.Content), "content", "is required and at most 64KB")
+	return v.err()
+}
+
+// CreatePost validates and creates a post of an existing user on behalf of
+// actor, with its first revision. Drafts only need ActionCreatePost, posts
+// that go public now or later also ActionPublishPost.
+func CreatePost(db *gorm.DB, actor *User, userID uint, in PostInput) (*Post, error) {
+	if err := Authorize(actor, ActionCreatePost, userID); err != nil {
+		return nil, err
+	}
+	if in.Status == "" {
+		in.Status = PostPublished
+	}
+	now := time.Now()
+	if err := in.validate(now); err != nil {
+		return nil, err
+	}
+	if in.Status != PostDraft {
+		if err := Authorize(actor, ActionPublishPost, userID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := GetUser(db, userID); err != nil {
+		return nil, err
+	}
+	post := Post{
+		Title:         strings.TrimSpace(in.Title),
+		Content:       in.Content,
+		UserID:        userID,
+		CommentStatus: CommentStatusNone,
+		Status:        in.Status,
+		PublishAt:     in.PublishAt,
+	}
+	if in.Status == PostPublished {
+		post.PublishAt = &now
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&post).Error; err != nil {
+			return err
+		}
+		_, err := recordRevision(tx, &post, actor.ID, "", "", nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// GetPost loads a post
+func GetPost(db *gorm.DB, id uint) (*Post, error) {
+	var post Post
+	if err := db.First(&post, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, notFound("post", id)
+		}
+		return nil, err
+	}
+	return &post, nil
+}
+
+// ListPosts loads a page of published posts, newest first by default
+func ListPosts(db *gorm.DB, req PageRequest) (*Page[Post], error) {
+	return paginate(listedPosts(db.Model(&Post{})), req, "-created_at", postSortKeys)
+}
+
+// ListUserPosts loads a page of the published posts of a user, newest first by default
+func ListUserPosts(db *gorm.DB, userID uint, req PageRequest) (*Page[Post], error) {
+	if _, err := GetUser(db, userID); err != nil {
+		return nil, err
+	}
+	return paginate(listedPosts(db.Model(&Post{}).Where("user_id = ?", userID)), req, "-created_at", postSortKeys)
+}
+
+// ListUserPostsByStatus loads a page of the posts of a user with a status on
+// behalf of actor, only the users who may edit the posts see the posts
+// that are not published
+func ListUserPostsByStatus(db *gorm.DB, actor *User, userID uint, status string, req PageRequest) (*Page[Post], error) {
+	if !ValidPostStatus(status) {
+		return nil, &ValidationError{Fields: map[string]string{"status": "must be draft, scheduled, published or archived"}}
+	}
+	if status == PostPublished {
+		return ListUserPosts(db, userID, req)
+	}
+	if _, err := GetUser(db, userID); err != nil {
+		return nil, err
+	}
+	if err := Authorize(actor, ActionUpdatePost, userID); err != nil {
+		return nil, err
+	}
+	return paginate(db.Model(&Post{}).Where("user_id = ? AND status = ?", userID, status), req, "-created_at", postSortKeys)
+}
+
+// UpdatePost applies the non nil fields of patch on behalf of actor. An edit
+// of the title or content is recorded as a new revision, a change of the
+// status goes through publishingChanges.
+func UpdatePost(db *gorm.DB, actor *User, id uint, patch PostPatch) (*Post, error) {
+	var post *Post
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		post, err = postForUpdate(tx, actor, id)
+		if err != nil {
+			return err
+		}
+		if err := patch.validate(); err != nil {
+			return err
+		}
+		updates := map[string]interface{}{}
+		if patch.Title != nil && strings.TrimSpace(*patch.Title) != post.Title {
+			updates["title"] = strings.TrimSpace(*patch.Title)
+		}
+		if patch.Content != nil && *patch.Content != post.Content {
+			updates["content"] = *patch.Content
+		}
+		edited := len(updates) > 0
+		if patch.Status != nil || patch.PublishAt != nil {
+			changes, err := publishingChanges(actor, post, patch, time.Now())
+			if err != nil {
+				return err
+			}
+			for column, value := range changes {
+				updates[column] = value
+			}
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		oldTitle, oldContent := post.Title, post.Content
+		// only the edited columns, the comment counters are maintained by the comment hooks
+		if err := tx.Model(post).Updates(updates).Error; err != nil {
+			return err
+		}
+		if edited {
+			_, err := recordRevision(tx, post, actor.ID, oldTitle, oldContent, patch.restoredFrom)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// DeletePost soft deletes a post on behalf of actor
+func DeletePost(db *gorm.DB, actor *User, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		post, err := GetPost(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := Authorize(actor, ActionDeletePost, post.UserID); err != nil {
+			return err
+		}
+		return tx.Delete(post).Error
+	})
+}
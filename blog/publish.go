@@ -0,0 +1,147 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// post status values
+const (
+	PostDraft     = "draft"     // only visible to the users who may edit it
+	PostScheduled = "scheduled" // a draft that PublishDue publishes at PublishAt
+	PostPublished = "published"
+	PostArchived  = "archived" // readable at its URL, but out of the listings and closed for comments
+)
+
+// ValidPostStatus reports whether status is one of the post status values
+func ValidPostStatus(status string) bool {
+	switch status {
+	case PostDraft, PostScheduled, PostPublished, PostArchived:
+		return true
+	}
+	return false
+}
+
+// CanSeePost reports whether viewer may see post, drafts and scheduled posts
+// are only shown to the users who may edit them
+func CanSeePost(viewer *User, post *Post) bool {
+	return post.Status == PostPublished || post.Status == PostArchived || Can(viewer, ActionUpdatePost, post.UserID)
+}
+
+// GetVisiblePost loads a post that viewer may see
+func GetVisiblePost(db *gorm.DB, viewer *User, id uint) (*Post, error) {
+	post, err := GetPost(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if !CanSeePost(viewer, post) {
+		return nil, notFound("post", id)
+	}
+	return post, nil
+}
+
+// listedPosts restricts a post query to the posts shown in the listings
+func listedPosts(query *gorm.DB) *gorm.DB {
+	return query.Where("status = ?", PostPublished)
+}
+
+// checkPublishing validates the status and publish time a post is moved
+// to, publishAt is only taken by scheduled posts
+func checkPublishing(v validator, status string, publishAt *time.Time, now time.Time) {
+	v.check(ValidPostStatus(status), "status", "must be draft, scheduled, published or archived")
+	if status == PostScheduled {
+		v.check(publishAt != nil && publishAt.After(now), "publish_at", "is required for scheduled posts and must be in the future")
+	} else {
+		v.check(publishAt == nil, "publish_at", "is only taken by scheduled posts")
+	}
+}
+
+// publishingChanges returns the column updates that move post to the status
+// and publish time of patch on behalf of actor. Making a post public, now or
+// at a scheduled time, needs ActionPublishPost; any editor may take it back.
+func publishingChanges(actor *User, post *Post, patch PostPatch, now time.Time) (map[string]interface{}, error) {
+	status := post.Status
+	if patch.Status != nil {
+		status = *patch.Status
+	}
+	publishAt := patch.PublishAt
+	if publishAt == nil && patch.Status == nil && status == PostScheduled {
+		publishAt = post.PublishAt
+	}
+	v := validator{}
+	checkPublishing(v, status, publishAt, now)
+	v.check(status != PostArchived || post.Status == PostPublished || post.Status == PostArchived, "status", "only published posts can be archived")
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	if status == PostScheduled || (status == PostPublished && post.Status != PostPublished) {
+		if err := Authorize(actor, ActionPublishPost, post.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := map[string]interface{}{"status": status}
+	switch status {
+	case PostDraft:
+		changes["publish_at"] = nil
+	case PostScheduled:
+		changes["publish_at"] = *publishAt
+	case PostPublished:
+		// an archived post goes back with its first publish time
+		if post.Status != PostPublished && (post.Status != PostArchived || post.PublishAt == nil) {
+			changes["publish_at"] = now
+		}
+	}
+	return changes, nil
+}
+
+// PublishDue publishes the scheduled posts whose publish time is not after
+// now and returns how many it published. Each post is published by its own
+// conditional update, so publishers running at the same time do not
+// publish a post twice, and the post hooks index it for search.
+func PublishDue(db *gorm.DB, now time.Time) (int, error) {
+	published := 0
+	var due []Post
+	err := db.Select("id").Where("status = ? AND publish_at <= ?", PostScheduled, now).
+		FindInBatches(&due, 500, func(tx *gorm.DB, batch int) error {
+			for i := range due {
+				result := db.Model(&due[i]).Where("status = ?", PostScheduled).Update("status", PostPublished)
+				if result.Error != nil {
+					return fmt.Errorf("publish post %d failed: %w", due[i].ID, result.Error)
+				}
+				published += int(result.RowsAffected)
+			}
+			return nil
+		}).Error
+	return published, err
+}
+
+// RunPublisher publishes the due scheduled posts every interval until ctx
+// is cancelled
+func RunPublisher(ctx context.Context, db *gorm.DB, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := PublishDue(db.WithContext(ctx), time.Now()); err != nil {
+			log.Printf("publish scheduled posts failed: %v", err)
+		} else if n > 0 {
+			log.Printf("published %d scheduled posts", n)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// backfillPublishAt gives the posts published before posts had a status
+// their creation time as publish time
+func backfillPublishAt(db *gorm.DB) error {
+	return db.Model(&Post{}).Where("status = ? AND publish_at IS NULL", PostPublished).
+		UpdateColumn("publish_at", gorm.Expr("created_at")).Error
+}
@@ -0,0 +1,131 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PostRevision is a version of the title and content of a post. Every post
+// starts with revision 1 and every edit adds the next number, with the
+// editor and a unified diff against the version it replaced.
+type PostRevision struct {
+	ID           uint   `gorm:"primarykey"`
+	PostID       uint   `gorm:"uniqueIndex:idx_post_revisions_number"`
+	Number       int    `gorm:"uniqueIndex:idx_post_revisions_number"`
+	UserID       uint   // the editor
+	Title        string `gorm:"size:255"`
+	Content      string `gorm:"type:text"`
+	Diff         string `gorm:"type:text"`
+	RestoredFrom *int   // the revision this one restores
+	CreatedAt    time.Time
+}
+
+var revisionSortKeys = map[string]sortKey[PostRevision]{
+	"number": func(r *PostRevision) (interface{}, uint) { return r.Number, r.ID },
+}
+
+// revisionText is the text of a version that the diffs compare
+func revisionText(title, content string) string {
+	return title + "\n\n" + content
+}
+
+// revisionDiff compares two versions of a post, revision 0 is the empty post
+func revisionDiff(from, to int, oldTitle, oldContent, title, content string) string {
+	fromName, fromText := fmt.Sprintf("revision %d", from), revisionText(oldTitle, oldContent)
+	if from == 0 {
+		fromName, fromText = "/dev/null", ""
+	}
+	return unifiedDiff(fromName, fmt.Sprintf("revision %d", to), fromText, revisionText(title, content))
+}
+
+// recordRevision adds the current title and content of post as its next
+// revision, made by editorID from the version oldTitle and oldContent. A
+// post written before revisions were kept first gets the replaced version
+// as revision 1.
+func recordRevision(tx *gorm.DB, post *Post, editorID uint, oldTitle, oldContent string, restoredFrom *int) (*PostRevision, error) {
+	var last PostRevision
+	if err := tx.Where("post_id = ?", post.ID).Order("number DESC").Limit(1).Find(&last).Error; err != nil {
+		return nil, err
+	}
+	if last.Number == 0 && oldTitle != "" {
+		last = PostRevision{
+			PostID:    post.ID,
+			Number:    1,
+			UserID:    post.UserID,
+			Title:     oldTitle,
+			Content:   oldContent,
+			Diff:      revisionDiff(0, 1, "", "", oldTitle, oldContent),
+			CreatedAt: post.CreatedAt,
+		}
+		if err := tx.Create(&last).Error; err != nil {
+			return nil, err
+		}
+	}
+	revision := PostRevision{
+		PostID:       post.ID,
+		Number:       last.Number + 1,
+		UserID:       editorID,
+		Title:        post.Title,
+		Content:      post.Content,
+		Diff:         revisionDiff(last.Number, last.Number+1, oldTitle, oldContent, post.Title, post.Content),
+		RestoredFrom: restoredFrom,
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		if isDuplicateKey(err) {
+			return nil, fmt.Errorf("%w: post %d was edited at the same time", ErrConflict, post.ID)
+		}
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// revisionsOf loads a post and checks that actor may see its history, which
+// is kept for the users who may edit the post
+func revisionsOf(db *gorm.DB, actor *User, postID uint) (*Post, error) {
+	post, err := GetVisiblePost(db, actor, postID)
+	if err != nil {
+		return nil, err
+	}
+	if err := Authorize(actor, ActionUpdatePost, post.UserID); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// ListRevisions loads a page of the revisions of a post on behalf of actor,
+// newest first by default
+func ListRevisions(db *gorm.DB, actor *User, postID uint, req PageRequest) (*Page[PostRevision], error) {
+	if _, err := revisionsOf(db, actor, postID); err != nil {
+		return nil, err
+	}
+	return paginate(db.Model(&PostRevision{}).Where("post_id = ?", postID), req, "-number", revisionSortKeys)
+}
+
+// GetRevision loads a revision of a post on behalf of actor
+func GetRevision(db *gorm.DB, actor *User, postID uint, number int) (*PostRevision, error) {
+	if _, err := revisionsOf(db, actor, postID); err != nil {
+		return nil, err
+	}
+	var revision PostRevision
+	if err := db.Where("post_id = ? AND number = ?", postID, number).Take(&revision).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: revision %d of post %d", ErrNotFound, number, postID)
+		}
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// RestoreRevision puts the title and content of a revision back on behalf
+// of actor. The restore is an edit of its own, recorded as a new revision;
+// restoring the current version changes nothing.
+func RestoreRevision(db *gorm.DB, actor *User, postID uint, number int) (*Post, error) {
+	revision, err := GetRevision(db, actor, postID, number)
+	if err != nil {
+		return nil, err
+	}
+	return UpdatePost(db, actor, postID, PostPatch{Title: &revision.Title, Content: &revision.Content, restoredFrom: &revision.Number})
+}
@@ -0,0 +1,378 @@
+package blog
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// kinds of searchable documents
+const (
+	SearchKindPost    = "post"
+	SearchKindComment = "comment"
+)
+
+// snippet layout
+const (
+	snippetRunes = 120
+	markOpen     = "<mark>"
+	markClose    = "</mark>"
+)
+
+// SearchDocument is the searchable copy of a post or comment, kept current by
+// the model hooks in the transaction that changes the post or comment.
+// TitleTerms and ContentTerms hold the segmented text for the SQLite FTS5 and
+// in-memory engines, MySQL indexes Title and Content with its ngram parser.
+// Deleted documents stay as tombstones so that the in-memory engine sees them.
+type SearchDocument struct {
+	ID           uint      `gorm:"primaryKey"`
+	Kind         string    `gorm:"size:16;uniqueIndex:idx_search_documents_ref"`
+	RefID        uint      `gorm:"uniqueIndex:idx_search_documents_ref"`
+	PostID       uint      `gorm:"index"`
+	Title        string    `gorm:"size:255"`
+	Content      string    `gorm:"type:text"`
+	TitleTerms   string    `gorm:"type:text"`
+	ContentTerms string    `gorm:"type:text"`
+	Deleted      bool      `gorm:"index"`
+	UpdatedAt    time.Time `gorm:"index"`
+}
+
+// searchEngine finds the documents matching all phrases, best first
+type searchEngine interface {
+	name() string
+	search(db *gorm.DB, phrases []phrase, kinds []string, limit, offset int) ([]rankedDocument, int64, error)
+}
+
+type rankedDocument struct {
+	id    uint
+	score float64
+}
+
+// SearchOptions filters and pages a search
+type SearchOptions struct {
+	Kinds  []string // SearchKindPost and/or SearchKindComment, empty means both
+	Limit  int      // capped at MaxPageSize, 0 means DefaultPageSize
+	Offset int
+}
+
+// SearchHit is a matching post or comment, Title and Snippet are HTML
+// escaped with the matches wrapped in <mark></mark>
+type SearchHit struct {
+	Kind    string
+	ID      uint // id of the post or comment
+	PostID  uint
+	Title   string
+	Snippet string
+	Score   float64
+}
+
+// SearchResult is one page of hits and the number of all matching documents
+type SearchResult struct {
+	Hits  []SearchHit
+	Total int64
+}
+
+// Search runs full-text queries with the best engine the database offers:
+// MySQL FULLTEXT with the ngram parser, SQLite FTS5 (when go-sqlite3 is built
+// with the sqlite_fts5 tag), or else an in-memory inverted index.
+//
+// Queries are words and "quoted phrases" which must all match, a trailing *
+// makes a word a prefix. Chinese text is segmented into bigrams, so 入门教程
+// finds the exact sequence and a single character finds the words it starts.
+type Search struct {
+	db     *gorm.DB
+	engine searchEngine
+}
+
+// NewSearch sets up the engine for the dialect of db, falling back to the
+// in-memory index when the database has no usable full-text support
+func NewSearch(db *gorm.DB) *Search {
+	var engine searchEngine
+	var err error
+	switch db.Dialector.Name() {
+	case "mysql":
+		engine, err = newMySQLEngine(db)
+	case "sqlite":
+		engine, err = newFTS5Engine(db)
+	default:
+		err = fmt.Errorf("no full-text support for %s", db.Dialector.Name())
+	}
+	if err != nil {
+		log.Printf("blog search: %v, using the in-memory index", err)
+		engine = newMemoryEngine()
+	}
+	return &Search{db: db, engine: engine}
+}
+
+// Engine returns the name of the engine in use
+func (s *Search) Engine() string {
+	return s.engine.name()
+}
+
+// Search runs a query
+func (s *Search) Search(q string, opts SearchOptions) (*SearchResult, error) {
+	v := validator{}
+	phrases := parseQuery(q)
+	v.check(len(phrases) > 0, "q", "must contain at least one word")
+	v.check(len(q) <= 500, "q", "must be at most 500 bytes")
+	for _, kind := range opts.Kinds {
+		v.check(kind == SearchKindPost || kind == SearchKindComment, "kind", "must be post or comment")
+	}
+	v.check(opts.Limit >= 0, "limit", fmt.Sprintf("must be between 1 and %d", MaxPageSize))
+	v.check(opts.Offset >= 0, "offset", "must not be negative")
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	kinds := opts.Kinds
+	if len(kinds) == 0 {
+		kinds = []string{SearchKindPost, SearchKindComment}
+	}
+	limit := opts.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	ranked, total, err := s.engine.search(s.db, phrases, kinds, limit, opts.Offset)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(ranked))
+	for i, r := range ranked {
+		ids[i] = r.id
+	}
+	var docs []SearchDocument
+	if len(ids) > 0 {
+		if err := s.db.Where("id IN ?", ids).Find(&docs).Error; err != nil {
+			return nil, err
+		}
+	}
+	byID := make(map[uint]*SearchDocument, len(docs))
+	for i := range docs {
+		byID[docs[i].ID] = &docs[i]
+	}
+	result := &SearchResult{Total: total, Hits: make([]SearchHit, 0, len(ranked))}
+	for _, r := range ranked {
+		doc, ok := byID[r.id]
+		if !ok {
+			continue
+		}
+		result.Hits = append(result.Hits, SearchHit{
+			Kind:    doc.Kind,
+			ID:      doc.RefID,
+			PostID:  doc.PostID,
+			Title:   highlight(doc.Title, matches(doc.Title, phrases), 0, len(doc.Title)),
+			Snippet: snippet(doc.Content, phrases),
+			Score:   r.score,
+		})
+	}
+	return result, nil
+}
+
+// snippet cuts about snippetRunes runes of text around the first match
+func snippet(text string, phrases []phrase) string {
+	spans := matches(text, phrases)
+	if utf8.RuneCountInString(text) <= snippetRunes || len(spans) == 0 {
+		end := len(text)
+		if len(spans) == 0 {
+			// no match in the content, the hit is from the title
+			end = runeOffset(text, 0, snippetRunes)
+		}
+		s := highlight(text, spans, 0, end)
+		if end < len(text) {
+			s += "…"
+		}
+		return s
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	// start a third of the window before the first match
+	start := spans[0][0]
+	for n := 0; n < snippetRunes/3 && start > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	end := runeOffset(text, start, snippetRunes)
+	s := highlight(text, spans, start, end)
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(text) {
+		s += "…"
+	}
+	return s
+}
+
+// runeOffset returns the byte offset n runes after from, or len(text)
+func runeOffset(text string, from, n int) int {
+	for ; n > 0 && from < len(text); n-- {
+		_, size := utf8.DecodeRuneInString(text[from:])
+		from += size
+	}
+	return from
+}
+
+// highlight HTML escapes text[start:end] and marks the spans within it
+func highlight(text string, spans [][2]int, start, end int) string {
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	var b strings.Builder
+	pos := start
+	for i := 0; i < len(spans); i++ {
+		s, e := spans[i][0], spans[i][1]
+		// merge overlapping matches, bigram phrases often overlap
+		for i+1 < len(spans) && spans[i+1][0] < e {
+			i++
+			if spans[i][1] > e {
+				e = spans[i][1]
+			}
+		}
+		if e <= pos || s >= end {
+			continue
+		}
+		if s < pos {
+			s = pos
+		}
+		if e > end {
+			e = end
+		}
+		b.WriteString(html.EscapeString(text[pos:s]))
+		b.WriteString(markOpen + html.EscapeString(text[s:e]) + markClose)
+		pos = e
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	return b.String()
+}
+
+// upsertSearchDocument writes the searchable copy of a post or comment
+func upsertSearchDocument(tx *gorm.DB, doc SearchDocument) error {
+	doc.TitleTerms = terms(doc.Title)
+	doc.ContentTerms = terms(doc.Content)
+	doc.UpdatedAt = time.Now()
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "kind"}, {Name: "ref_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"post_id", "title", "content", "title_terms", "content_terms", "deleted", "updated_at"}),
+	}).Create(&doc).Error
+}
+
+// indexPost writes the search document of a post. A post that is not
+// published is removed from search with its comments, and they come back
+// when it is published again.
+func indexPost(tx *gorm.DB, id uint) error {
+	var post Post
+	if err := tx.Select("id", "title", "content", "status").First(&post, id).Error; err != nil {
+		return fmt.Errorf("load post for search failed: %w", err)
+	}
+	if post.Status != PostPublished {
+		return unindex(tx, "post_id = ?", post.ID)
+	}
+	err := upsertSearchDocument(tx, SearchDocument{Kind: SearchKindPost, RefID: post.ID, PostID: post.ID, Title: post.Title, Content: post.Content})
+	if err != nil {
+		return err
+	}
+	approved := tx.Model(&Comment{}).Select("id").Where("post_id = ? AND state = ?", post.ID, CommentApproved)
+	return tx.Model(&SearchDocument{}).
+		Where("kind = ? AND post_id = ? AND deleted = ? AND ref_id IN (?)", SearchKindComment, post.ID, true, approved).
+		Updates(map[string]interface{}{"deleted": false, "updated_at": time.Now()}).Error
+}
+
+// indexComment writes the search document of a comment, comments that are
+// not approved are removed from search. The comments of a post that is not
+// published are kept up to date as tombstones, for indexPost to bring back.
+func indexComment(tx *gorm.DB, id uint) error {
+	var comment Comment
+	if err := tx.Select("id", "post_id", "content", "state").First(&comment, id).Error; err != nil {
+		return fmt.Errorf("load comment for search failed: %w", err)
+	}
+	if comment.State != CommentApproved {
+		return unindex(tx, "kind = ? AND ref_id = ?", SearchKindComment, comment.ID)
+	}
+	var published int64
+	if err := tx.Model(&Post{}).Where("id = ? AND status = ?", comment.PostID, PostPublished).Count(&published).Error; err != nil {
+		return fmt.Errorf("load post of comment for search failed: %w", err)
+	}
+	return upsertSearchDocument(tx, SearchDocument{Kind: SearchKindComment, RefID: comment.ID, PostID: comment.PostID, Content: comment.Content, Deleted: published == 0})
+}
+
+// unindex turns the search documents matching the condition into tombstones
+func unindex(tx *gorm.DB, query string, args ...interface{}) error {
+	return tx.Model(&SearchDocument{}).
+		Where(query, args...).
+		Updates(map[string]interface{}{"deleted": true, "updated_at": time.Now()}).Error
+}
+
+// AfterCreate indexes a new post for search
+func (p *Post) AfterCreate(tx *gorm.DB) error {
+	return indexPost(tx, p.ID)
+}
+
+// AfterUpdate re-indexes an updated post, updates without a primary key
+// (such as the comment status updates of the comment hooks) are skipped
+func (p *Post) AfterUpdate(tx *gorm.DB) error {
+	if p.ID == 0 {
+		return nil
+	}
+	return indexPost(tx, p.ID)
+}
+
+// AfterDelete removes a post and its comments from search
+func (p *Post) AfterDelete(tx *gorm.DB) error {
+	if p.ID == 0 {
+		return nil
+	}
+	return unindex(tx, "post_id = ?", p.ID)
+}
+
+// AfterUpdate re-indexes an updated comment
+func (c *Comment) AfterUpdate(tx *gorm.DB) error {
+	if c.ID == 0 {
+		return nil
+	}
+	return indexComment(tx, c.ID)
+}
+
+// Reindex rebuilds the search documents from the posts and comments, for
+// data written before search was set up or by statements that skip hooks
+func Reindex(db *gorm.DB) error {
+	var posts []Post
+	err := db.Select("id", "title", "content").Where("status = ?", PostPublished).FindInBatches(&posts, 500, func(tx *gorm.DB, batch int) error {
+		for _, post := range posts {
+			doc := SearchDocument{Kind: SearchKindPost, RefID: post.ID, PostID: post.ID, Title: post.Title, Content: post.Content}
+			if err := upsertSearchDocument(db, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+	var comments []Comment
+	publishedPosts := db.Model(&Post{}).Select("id").Where("status = ?", PostPublished)
+	err = db.Select("id", "post_id", "content").Where("state = ? AND post_id IN (?)", CommentApproved, publishedPosts).FindInBatches(&comments, 500, func(tx *gorm.DB, batch int) error {
+		for _, comment := range comments {
+			doc := SearchDocument{Kind: SearchKindComment, RefID: comment.ID, PostID: comment.PostID, Content: comment.Content}
+			if err := upsertSearchDocument(db, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+	// documents of deleted and unpublished posts and their comments, and
+	// comments that are not approved
+	aliveComments := db.Model(&Comment{}).Select("id").Where("state = ?", CommentApproved)
+	if err := unindex(db, "deleted = ? AND post_id NOT IN (?)", false, publishedPosts); err != nil {
+		return err
+	}
+	return unindex(db, "deleted = ? AND kind = ? AND ref_id NOT IN (?)", false, SearchKindComment, aliveComments)
+}
@@ -0,0 +1,332 @@
+package blog
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BM25 parameters and the weight of a title match relative to the content
+const (
+	bm25K1      = 1.2
+	bm25B       = 0.75
+	titleWeight = 3.0
+)
+
+// mysqlEngine uses a FULLTEXT index with the ngram parser, which segments
+// CJK text the same way tokenize does
+type mysqlEngine struct{}
+
+const mysqlFulltextIndex = "idx_search_documents_fulltext"
+
+func newMySQLEngine(db *gorm.DB) (searchEngine, error) {
+	if !db.Migrator().HasIndex(&SearchDocument{}, mysqlFulltextIndex) {
+		err := db.Exec("CREATE FULLTEXT INDEX " + mysqlFulltextIndex + " ON search_documents (title, content) WITH PARSER ngram").Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mysqlEngine{}, nil
+}
+
+func (mysqlEngine) name() string { return "mysql-fulltext" }
+
+// booleanQuery builds an IN BOOLEAN MODE query requiring every phrase
+func booleanQuery(phrases []phrase) string {
+	clean := strings.NewReplacer(`"`, " ", "+", " ", "-", " ", "<", " ", ">", " ", "(", " ", ")", " ", "~", " ", "*", " ", "@", " ")
+	parts := make([]string, len(phrases))
+	for i, p := range phrases {
+		if p.prefix {
+			parts[i] = "+" + p.terms[0] + "*"
+		} else {
+			parts[i] = `+"` + strings.TrimSpace(clean.Replace(p.text)) + `"`
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (mysqlEngine) search(db *gorm.DB, phrases []phrase, kinds []string, limit, offset int) ([]rankedDocument, int64, error) {
+	const match = "MATCH (title, content) AGAINST (? IN BOOLEAN MODE)"
+	against := booleanQuery(phrases)
+	query := db.Model(&SearchDocument{}).Where(match, against).Where("deleted = ? AND kind IN ?", false, kinds)
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var rows []struct {
+		ID    uint
+		Score float64
+	}
+	err := query.Session(&gorm.Session{}).
+		Select("id, "+match+" AS score", against).
+		Order("score DESC, id").
+		Limit(limit).Offset(offset).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	ranked := make([]rankedDocument, len(rows))
+	for i, row := range rows {
+		ranked[i] = rankedDocument{id: row.ID, score: row.Score}
+	}
+	return ranked, total, nil
+}
+
+// fts5Engine keeps the segmented terms of the live documents in an FTS5
+// table, maintained by triggers on search_documents. go-sqlite3 only has
+// FTS5 when built with the sqlite_fts5 tag (go build -tags sqlite_fts5);
+// without it the table cannot be created and NewSearch falls back to the
+// in-memory index.
+type fts5Engine struct{}
+
+var fts5Setup = []string{
+	"CREATE VIRTUAL TABLE search_fts USING fts5(title_terms, content_terms)",
+	`CREATE TRIGGER search_documents_fts_insert AFTER INSERT ON search_documents WHEN new.deleted = 0 BEGIN
+		INSERT INTO search_fts (rowid, title_terms, content_terms) VALUES (new.id, new.title_terms, new.content_terms);
+	END`,
+	`CREATE TRIGGER search_documents_fts_update AFTER UPDATE ON search_documents BEGIN
+		DELETE FROM search_fts WHERE rowid = old.id;
+		INSERT INTO search_fts (rowid, title_terms, content_terms) SELECT new.id, new.title_terms, new.content_terms WHERE new.deleted = 0;
+	END`,
+	`CREATE TRIGGER search_documents_fts_delete AFTER DELETE ON search_documents BEGIN
+		DELETE FROM search_fts WHERE rowid = old.id;
+	END`,
+	"INSERT INTO search_fts (rowid, title_terms, content_terms) SELECT id, title_terms, content_terms FROM search_documents WHERE deleted = 0",
+}
+
+func newFTS5Engine(db *gorm.DB) (searchEngine, error) {
+	if db.Migrator().HasTable("search_fts") {
+		return fts5Engine{}, nil
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range fts5Setup {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fts5Engine{}, nil
+}
+
+func (fts5Engine) name() string { return "sqlite-fts5" }
+
+// matchQuery builds an FTS5 query requiring every phrase
+func matchQuery(phrases []phrase) string {
+	parts := make([]string, len(phrases))
+	for i, p := range phrases {
+		parts[i] = `"` + strings.Join(p.terms, " ") + `"`
+		if p.prefix {
+			parts[i] += "*"
+		}
+	}
+	return strings.Join(parts, " AND ")
+}
+
+func (fts5Engine) search(db *gorm.DB, phrases []phrase, kinds []string, limit, offset int) ([]rankedDocument, int64, error) {
+	const from = " FROM search_fts JOIN search_documents d ON d.id = search_fts.rowid WHERE search_fts MATCH ? AND d.kind IN ?"
+	match := matchQuery(phrases)
+	var total int64
+	if err := db.Raw("SELECT COUNT(*)"+from, match, kinds).Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var rows []struct {
+		ID   uint
+		Rank float64
+	}
+	err := db.Raw("SELECT d.id, bm25(search_fts, ?, 1.0) AS rank"+from+" ORDER BY rank, d.id LIMIT ? OFFSET ?",
+		titleWeight, match, kinds, limit, offset).Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	ranked := make([]rankedDocument, len(rows))
+	for i, row := range rows {
+		// bm25() is lower for better matches
+		ranked[i] = rankedDocument{id: row.ID, score: -row.Rank}
+	}
+	return ranked, total, nil
+}
+
+// memoryEngine is a pure Go inverted index of the live documents. Each
+// search first applies the documents changed since the previous one, going
+// back refreshLag to pick up transactions that committed late.
+type memoryEngine struct {
+	mu       sync.Mutex
+	docs     map[uint]*memoryDocument
+	postings [2]map[string]map[uint][]int // field, term, document: positions
+	length   [2]int                       // total terms per field, for the average
+	synced   time.Time
+}
+
+type memoryDocument struct {
+	kind   string
+	fields [2][]string
+}
+
+const refreshLag = 10 * time.Second
+
+func newMemoryEngine() *memoryEngine {
+	e := &memoryEngine{docs: map[uint]*memoryDocument{}}
+	for i := range e.postings {
+		e.postings[i] = map[string]map[uint][]int{}
+	}
+	return e
+}
+
+func (e *memoryEngine) name() string { return "memory" }
+
+func (e *memoryEngine) refresh(db *gorm.DB) error {
+	started := time.Now()
+	query := db.Model(&SearchDocument{}).Select("id", "kind", "title_terms", "content_terms", "deleted")
+	if !e.synced.IsZero() {
+		query = query.Where("updated_at >= ?", e.synced.Add(-refreshLag))
+	}
+	var docs []SearchDocument
+	err := query.FindInBatches(&docs, 1000, func(tx *gorm.DB, batch int) error {
+		for i := range docs {
+			e.remove(docs[i].ID)
+			if !docs[i].Deleted {
+				e.add(&docs[i])
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+	e.synced = started
+	return nil
+}
+
+func (e *memoryEngine) add(doc *SearchDocument) {
+	d := &memoryDocument{kind: doc.Kind}
+	for field, text := range []string{doc.TitleTerms, doc.ContentTerms} {
+		d.fields[field] = strings.Fields(text)
+		for pos, term := range d.fields[field] {
+			if e.postings[field][term] == nil {
+				e.postings[field][term] = map[uint][]int{}
+			}
+			e.postings[field][term][doc.ID] = append(e.postings[field][term][doc.ID], pos)
+		}
+		e.length[field] += len(d.fields[field])
+	}
+	e.docs[doc.ID] = d
+}
+
+func (e *memoryEngine) remove(id uint) {
+	d, ok := e.docs[id]
+	if !ok {
+		return
+	}
+	for field, words := range d.fields {
+		for _, term := range words {
+			delete(e.postings[field][term], id)
+			if len(e.postings[field][term]) == 0 {
+				delete(e.postings[field], term)
+			}
+		}
+		e.length[field] -= len(words)
+	}
+	delete(e.docs, id)
+}
+
+// occurrences counts the matches of a phrase per document in one field
+func (e *memoryEngine) occurrences(field int, p phrase) map[uint]int {
+	counts := map[uint]int{}
+	if p.prefix {
+		for term, docs := range e.postings[field] {
+			if strings.HasPrefix(term, p.terms[0]) {
+				for id, positions := range docs {
+					counts[id] += len(positions)
+				}
+			}
+		}
+		return counts
+	}
+	for id, positions := range e.postings[field][p.terms[0]] {
+	next:
+		for _, start := range positions {
+			for i, term := range p.terms[1:] {
+				if !containsInt(e.postings[field][term][id], start+i+1) {
+					continue next
+				}
+			}
+			counts[id]++
+		}
+	}
+	return counts
+}
+
+func containsInt(sorted []int, n int) bool {
+	i := sort.SearchInts(sorted, n)
+	return i < len(sorted) && sorted[i] == n
+}
+
+func (e *memoryEngine) search(db *gorm.DB, phrases []phrase, kinds []string, limit, offset int) ([]rankedDocument, int64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.refresh(db); err != nil {
+		return nil, 0, err
+	}
+	wanted := map[string]bool{}
+	for _, kind := range kinds {
+		wanted[kind] = true
+	}
+	n := float64(len(e.docs))
+	scores := map[uint]float64{}
+	for i, p := range phrases {
+		matched := map[uint]float64{}
+		for field, weight := range []float64{titleWeight, 1} {
+			counts := e.occurrences(field, p)
+			if len(counts) == 0 {
+				continue
+			}
+			idf := math.Log(1 + (n-float64(len(counts))+0.5)/(float64(len(counts))+0.5))
+			avg := float64(e.length[field]) / math.Max(n, 1)
+			for id, tf := range counts {
+				docLen := float64(len(e.docs[id].fields[field]))
+				f := float64(tf)
+				matched[id] += weight * idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*docLen/math.Max(avg, 1)))
+			}
+		}
+		// every phrase must match
+		for id, score := range matched {
+			if i == 0 || scores[id] > 0 {
+				scores[id] += score
+			}
+		}
+		for id := range scores {
+			if _, ok := matched[id]; !ok {
+				delete(scores, id)
+			}
+		}
+	}
+	ranked := make([]rankedDocument, 0, len(scores))
+	for id, score := range scores {
+		if wanted[e.docs[id].kind] {
+			ranked = append(ranked, rankedDocument{id: id, score: score})
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].id < ranked[j].id
+	})
+	total := int64(len(ranked))
+	if offset >= len(ranked) {
+		return nil, total, nil
+	}
+	ranked = ranked[offset:]
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked, total, nil
+}
@@ -0,0 +1,177 @@
+package blog
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token is a search term of a text with its byte range and position
+type token struct {
+	term       string
+	start, end int
+	pos        int
+}
+
+// isCJK reports whether r belongs to a script written without spaces
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r)
+}
+
+// tokenize segments text into search terms. Words of alphabetic scripts are
+// lowercased whole words. Chinese, Japanese and Korean runs have no word
+// boundaries, so they become overlapping bigrams ("入门教程" gives 入门,
+// 门教, 教程), the same scheme as MySQL's ngram parser; a single character
+// between non CJK text is a term on its own. Positions are consecutive so a
+// phrase is a run of terms at consecutive positions.
+func tokenize(text string) []token {
+	var tokens []token
+	type char struct {
+		r          rune
+		start, end int
+	}
+	var run []char
+	cjk := false
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		switch {
+		case !cjk:
+			var b strings.Builder
+			for _, c := range run {
+				b.WriteRune(c.r)
+			}
+			tokens = append(tokens, token{term: strings.ToLower(b.String()), start: run[0].start, end: run[len(run)-1].end})
+		case len(run) == 1:
+			tokens = append(tokens, token{term: string(run[0].r), start: run[0].start, end: run[0].end})
+		default:
+			for i := 0; i+1 < len(run); i++ {
+				tokens = append(tokens, token{term: string([]rune{run[i].r, run[i+1].r}), start: run[i].start, end: run[i+1].end})
+			}
+		}
+		run = run[:0]
+	}
+	for i, r := range text {
+		c := char{r: r, start: i, end: i + utf8.RuneLen(r)}
+		switch {
+		case isCJK(r):
+			if !cjk {
+				flush()
+			}
+			cjk = true
+			run = append(run, c)
+		case isWordRune(r):
+			if cjk {
+				flush()
+			}
+			cjk = false
+			run = append(run, c)
+		default:
+			flush()
+		}
+	}
+	flush()
+	for i := range tokens {
+		tokens[i].pos = i
+	}
+	return tokens
+}
+
+// terms returns the terms of text joined by spaces, the form stored for the
+// SQLite FTS5 and in-memory engines
+func terms(text string) string {
+	tokens := tokenize(text)
+	parts := make([]string, len(tokens))
+	for i, t := range tokens {
+		parts[i] = t.term
+	}
+	return strings.Join(parts, " ")
+}
+
+// phrase is one condition of a search query, all phrases must match
+type phrase struct {
+	text   string   // the words as written, for MySQL
+	terms  []string // the segmented terms, consecutive in a matching text
+	prefix bool     // the only term is a prefix, e.g. gor* or a single CJK character
+}
+
+// parseQuery splits a query into phrases: "quoted text" is one phrase, every
+// other whitespace separated word is a phrase of its own (a CJK word is
+// segmented, so 入门教程 matches the exact sequence), and a trailing * makes
+// a single word a prefix
+func parseQuery(q string) []phrase {
+	var phrases []phrase
+	add := func(text string, quoted bool) {
+		prefix := false
+		if !quoted && strings.HasSuffix(text, "*") {
+			text, prefix = strings.TrimRight(text, "*"), true
+		}
+		tokens := tokenize(text)
+		if len(tokens) == 0 {
+			return
+		}
+		p := phrase{text: text, terms: make([]string, len(tokens))}
+		for i, t := range tokens {
+			p.terms[i] = t.term
+		}
+		if len(p.terms) == 1 {
+			// a lone CJK character only exists as the start of bigrams
+			r, _ := utf8.DecodeRuneInString(p.terms[0])
+			p.prefix = prefix || (utf8.RuneCountInString(p.terms[0]) == 1 && isCJK(r))
+		}
+		phrases = append(phrases, p)
+	}
+	for {
+		q = strings.TrimSpace(q)
+		if q == "" {
+			return phrases
+		}
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				add(q[1:], true)
+				return phrases
+			}
+			add(q[1:end+1], true)
+			q = q[end+2:]
+			continue
+		}
+		end := strings.IndexFunc(q, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end < 0 {
+			end = len(q)
+		}
+		add(q[:end], false)
+		q = q[end:]
+	}
+}
+
+// matches returns the byte ranges of text matched by the phrases
+func matches(text string, phrases []phrase) [][2]int {
+	tokens := tokenize(text)
+	var spans [][2]int
+	for _, p := range phrases {
+		for i := 0; i+len(p.terms) <= len(tokens); i++ {
+			ok := true
+			for j, term := range p.terms {
+				got := tokens[i+j].term
+				if p.prefix {
+					ok = strings.HasPrefix(got, term)
+				} else if got != term {
+					ok = false
+				}
+				if !ok {
+					break
+				}
+			}
+			if ok {
+				spans = append(spans, [2]int{tokens[i].start, tokens[i+len(p.terms)-1].end})
+			}
+		}
+	}
+	return spans
+}
@@ -0,0 +1,371 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// MaxPostTags is the number of tags a post may have
+const MaxPostTags = 10
+
+// Tag is a free form label of posts, tags are created the first time a post
+// is tagged with them and are identified by their slug
+type Tag struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"size:64"`
+	Slug      string `gorm:"size:64;uniqueIndex"`
+	CreatedAt time.Time
+}
+
+// Category is a node of the category tree. Path is its materialized path,
+// built like that of comments, so a subtree is a prefix match on it.
+type Category struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"size:64"`
+	Slug      string `gorm:"size:64;uniqueIndex"`
+	ParentID  *uint  `gorm:"index"`
+	Path      string `gorm:"size:600;index"`
+	Depth     int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// slugify turns a name into a slug: lower case letters and digits, other
+// runs of characters become a single dash; CJK names keep their characters
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+func validTaxonomyName(name string) bool {
+	return slugify(name) != "" && utf8.RuneCountInString(strings.TrimSpace(name)) <= 64
+}
+
+// GetTag loads a tag by its slug
+func GetTag(db *gorm.DB, slug string) (*Tag, error) {
+	var tag Tag
+	if err := db.Where("slug = ?", slug).First(&tag).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: tag %s", ErrNotFound, slug)
+		}
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// findOrCreateTags returns the tags of the given names, creating the missing
+// ones; names with the same slug are one tag
+func findOrCreateTags(tx *gorm.DB, names []string) ([]Tag, error) {
+	tags := make([]Tag, 0, len(names))
+	seen := map[string]bool{}
+	for _, name := range names {
+		slug := slugify(name)
+		if seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		tag := Tag{Name: strings.TrimSpace(name), Slug: slug}
+		if err := tx.Where(Tag{Slug: slug}).FirstOrCreate(&tag).Error; err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// postForUpdate loads a post and checks that actor may change it
+func postForUpdate(tx *gorm.DB, actor *User, postID uint) (*Post, error) {
+	post, err := GetPost(tx, postID)
+	if err != nil {
+		return nil, err
+	}
+	if err := Authorize(actor, ActionUpdatePost, post.UserID); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// PostTags loads the tags of a post, by slug
+func PostTags(db *gorm.DB, postID uint) ([]Tag, error) {
+	post, err := GetPost(db, postID)
+	if err != nil {
+		return nil, err
+	}
+	tags := []Tag{}
+	if err := db.Model(post).Order("slug").Association("Tags").Find(&tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// AttachTags tags a post on behalf of actor, creating the tags that do not
+// exist yet, and returns all tags of the post
+func AttachTags(db *gorm.DB, actor *User, postID uint, names []string) ([]Tag, error) {
+	v := validator{}
+	v.check(len(names) > 0, "tags", "at least one tag is required")
+	for _, name := range names {
+		v.check(validTaxonomyName(name), "tags", "names are at most 64 characters with a letter or digit")
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		post, err := postForUpdate(tx, actor, postID)
+		if err != nil {
+			return err
+		}
+		tags, err := findOrCreateTags(tx, names)
+		if err != nil {
+			return err
+		}
+		association := tx.Model(post).Omit("Tags.*").Association("Tags")
+		if err := association.Append(tags); err != nil {
+			return err
+		}
+		if association.Count() > MaxPostTags {
+			return &ValidationError{Fields: map[string]string{"tags": fmt.Sprintf("a post has at most %d tags", MaxPostTags)}}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return PostTags(db, postID)
+}
+
+// DetachTag removes a tag from a post on behalf of actor, the tag itself stays
+func DetachTag(db *gorm.DB, actor *User, postID uint, slug string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		post, err := postForUpdate(tx, actor, postID)
+		if err != nil {
+			return err
+		}
+		tag, err := GetTag(tx, slug)
+		if err != nil {
+			return err
+		}
+		return tx.Model(post).Association("Tags").Delete(tag)
+	})
+}
+
+// TagCount is a tag with the number of published posts tagged with it
+type TagCount struct {
+	Tag
+	PostCount int64 `json:"post_count"`
+}
+
+// ListTags loads every tag with its post count, most used first
+func ListTags(db *gorm.DB) ([]TagCount, error) {
+	counts := []TagCount{}
+	err := db.Model(&Tag{}).
+		Select("tags.*, COUNT(posts.id) AS post_count").
+		Joins("LEFT JOIN post_tags ON post_tags.tag_id = tags.id").
+		Joins("LEFT JOIN posts ON posts.id = post_tags.post_id AND posts.deleted_at IS NULL AND posts.status = ?", PostPublished).
+		Group("tags.id").
+		Order("post_count DESC, tags.slug").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// ListTagPosts loads a page of the published posts with a tag, newest first by default
+func ListTagPosts(db *gorm.DB, slug string, req PageRequest) (*Page[Post], error) {
+	tag, err := GetTag(db, slug)
+	if err != nil {
+		return nil, err
+	}
+	tagged := db.Table("post_tags").Select("post_id").Where("tag_id = ?", tag.ID)
+	return paginate(listedPosts(db.Model(&Post{}).Where("id IN (?)", tagged)), req, "-created_at", postSortKeys)
+}
+
+// CategoryInput is the input of CreateCategory, Slug defaults to the slug of the name
+type CategoryInput struct {
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID *uint  `json:"parent_id"`
+}
+
+// AfterCreate stores the path and depth of a new category, which need its id
+func (c *Category) AfterCreate(tx *gorm.DB) error {
+	c.Path, c.Depth = pathSegment(c.ID), 0
+	if c.ParentID != nil {
+		var parent Category
+		if err := tx.Select("id", "path", "depth").First(&parent, *c.ParentID).Error; err != nil {
+			return fmt.Errorf("query parent category failed: %v", err)
+		}
+		c.Path, c.Depth = parent.Path+pathSegment(c.ID), parent.Depth+1
+	}
+	return tx.Model(c).UpdateColumns(map[string]interface{}{"path": c.Path, "depth": c.Depth}).Error
+}
+
+// MaxCategoryDepth is the deepest level of the category tree, top level categories are depth 0
+const MaxCategoryDepth = 16
+
+// CreateCategory validates and creates a category on behalf of actor, only
+// admins manage the category tree
+func CreateCategory(db *gorm.DB, actor *User, in CategoryInput) (*Category, error) {
+	if err := Authorize(actor, ActionManageCategories, 0); err != nil {
+		return nil, err
+	}
+	if in.Slug == "" {
+		in.Slug = slugify(in.Name)
+	}
+	v := validator{}
+	v.check(validTaxonomyName(in.Name), "name", "is required and at most 64 characters")
+	v.check(in.Slug == slugify(in.Slug) && utf8.RuneCountInString(in.Slug) <= 64, "slug", "must be lower case letters and digits separated by dashes")
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	if in.ParentID != nil {
+		parent, err := GetCategory(db, *in.ParentID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, &ValidationError{Fields: map[string]string{"parent_id": "category does not exist"}}
+		} else if err != nil {
+			return nil, err
+		}
+		if parent.Depth+1 >= MaxCategoryDepth {
+			return nil, &ValidationError{Fields: map[string]string{"parent_id": fmt.Sprintf("categories are limited to %d levels", MaxCategoryDepth)}}
+		}
+	}
+	category := Category{Name: strings.TrimSpace(in.Name), Slug: in.Slug, ParentID: in.ParentID}
+	if err := db.Create(&category).Error; err != nil {
+		if isDuplicateKey(err) {
+			return nil, fmt.Errorf("%w: category %s already exists", ErrConflict, in.Slug)
+		}
+		return nil, err
+	}
+	return &category, nil
+}
+
+// GetCategory loads a category
+func GetCategory(db *gorm.DB, id uint) (*Category, error) {
+	var category Category
+	if err := db.First(&category, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, notFound("category", id)
+		}
+		return nil, err
+	}
+	return &category, nil
+}
+
+// CategoryNode is a category with its subcategories
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode
+}
+
+// CategoryTree loads the whole category tree in one query, siblings in
+// the order they were created
+func CategoryTree(db *gorm.DB) ([]*CategoryNode, error) {
+	var categories []Category
+	if err := db.Order("path").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	roots := []*CategoryNode{}
+	nodes := make(map[uint]*CategoryNode, len(categories))
+	for _, category := range categories {
+		node := &CategoryNode{Category: category, Children: []*CategoryNode{}}
+		nodes[category.ID] = node
+		if category.ParentID == nil {
+			roots = append(roots, node)
+		} else if parent, ok := nodes[*category.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	return roots, nil
+}
+
+// PostCategories loads the categories of a post, in tree order
+func PostCategories(db *gorm.DB, postID uint) ([]Category, error) {
+	post, err := GetPost(db, postID)
+	if err != nil {
+		return nil, err
+	}
+	categories := []Category{}
+	if err := db.Model(post).Order("path").Association("Categories").Find(&categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// AttachCategories files a post under existing categories on behalf of
+// actor and returns all categories of the post
+func AttachCategories(db *gorm.DB, actor *User, postID uint, ids []uint) ([]Category, error) {
+	if len(ids) == 0 {
+		return nil, &ValidationError{Fields: map[string]string{"category_ids": "at least one category is required"}}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		post, err := postForUpdate(tx, actor, postID)
+		if err != nil {
+			return err
+		}
+		var categories []Category
+		if err := tx.Where("id IN ?", ids).Find(&categories).Error; err != nil {
+			return err
+		}
+		if len(categories) != len(uniqueIDs(ids)) {
+			return &ValidationError{Fields: map[string]string{"category_ids": "category does not exist"}}
+		}
+		return tx.Model(post).Omit("Categories.*").Association("Categories").Append(categories)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return PostCategories(db, postID)
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// DetachCategory removes a post from a category on behalf of actor
+func DetachCategory(db *gorm.DB, actor *User, postID, categoryID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		post, err := postForUpdate(tx, actor, postID)
+		if err != nil {
+			return err
+		}
+		category, err := GetCategory(tx, categoryID)
+		if err != nil {
+			return err
+		}
+		return tx.Model(post).Association("Categories").Delete(category)
+	})
+}
+
+// ListCategoryPosts loads a page of the published posts filed under a
+// category or any of its subcategories, newest first by default
+func ListCategoryPosts(db *gorm.DB, categoryID uint, req PageRequest) (*Page[Post], error) {
+	category, err := GetCategory(db, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	subtree := db.Model(&Category{}).Select("id").Where("path LIKE ?", category.Path+"%")
+	filed := db.Table("post_categories").Select("post_id").Where("category_id IN (?)", subtree)
+	return paginate(listedPosts(db.Model(&Post{}).Where("id IN (?)", filed)), req, "-created_at", postSortKeys)
+}
@@ -0,0 +1,242 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// moderation states of a comment, only approved comments are public,
+// counted in Post.CommentCount and searchable
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentRejected = "rejected"
+	CommentSpam     = "spam"
+)
+
+// ValidCommentState reports whether state is one of the moderation states
+func ValidCommentState(state string) bool {
+	switch state {
+	case CommentPending, CommentApproved, CommentRejected, CommentSpam:
+		return true
+	}
+	return false
+}
+
+// MaxCommentDepth is the deepest reply level, top level comments are depth 0;
+// it keeps the materialized path within its indexed column
+const MaxCommentDepth = 64
+
+// pathSegmentWidth is the width of an id in a path, base 36 with 7 digits
+// covers ids up to 78 billion
+const pathSegmentWidth = 7
+
+// pathSegment is the fixed width path element of a comment id, so that the
+// string order of paths is the numeric order of ids level by level
+func pathSegment(id uint) string {
+	s := strconv.FormatUint(uint64(id), 36)
+	return strings.Repeat("0", pathSegmentWidth-len(s)) + s + "/"
+}
+
+// placeInThread stores the path, root and depth of a new comment, which
+// need its id and so are written right after the insert
+func (c *Comment) placeInThread(tx *gorm.DB) error {
+	c.Path, c.RootID, c.Depth = pathSegment(c.ID), c.ID, 0
+	if c.ParentID != nil {
+		var parent Comment
+		if err := tx.Select("id", "post_id", "root_id", "path", "depth").First(&parent, *c.ParentID).Error; err != nil {
+			return fmt.Errorf("query parent comment failed: %v", err)
+		}
+		if parent.PostID != c.PostID {
+			return fmt.Errorf("parent comment %d belongs to another post", parent.ID)
+		}
+		c.Path, c.RootID, c.Depth = parent.Path+pathSegment(c.ID), parent.RootID, parent.Depth+1
+	}
+	return tx.Model(c).UpdateColumns(map[string]interface{}{"path": c.Path, "root_id": c.RootID, "depth": c.Depth}).Error
+}
+
+// backfillCommentPaths places comments written before threading existed,
+// they are all top level
+func backfillCommentPaths(db *gorm.DB) error {
+	var comments []Comment
+	return db.Unscoped().Select("id").Where("path = ? OR path IS NULL", "").
+		FindInBatches(&comments, 500, func(tx *gorm.DB, batch int) error {
+			for _, c := range comments {
+				err := db.Unscoped().Model(&Comment{}).Where("id = ?", c.ID).
+					UpdateColumns(map[string]interface{}{"path": pathSegment(c.ID), "root_id": c.ID, "depth": 0}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
+
+// canModerate reports whether actor may moderate the comments of post
+func canModerate(actor *User, post *Post) bool {
+	return actor != nil && Can(actor, ActionModerateComment, post.UserID)
+}
+
+// visibleComments restricts a comment query to what viewer may see: all
+// comments for moderators of the post, approved and own comments otherwise
+func visibleComments(query *gorm.DB, viewer *User, post *Post) *gorm.DB {
+	switch {
+	case canModerate(viewer, post):
+		return query
+	case viewer != nil:
+		return query.Where("state = ? OR user_id = ?", CommentApproved, viewer.ID)
+	default:
+		return query.Where("state = ?", CommentApproved)
+	}
+}
+
+// CanSeeComment reports whether viewer may see comment of post
+func CanSeeComment(viewer *User, comment *Comment, post *Post) bool {
+	return comment.State == CommentApproved || canModerate(viewer, post) || (viewer != nil && viewer.ID == comment.UserID)
+}
+
+// CommentNode is a comment with its visible replies
+type CommentNode struct {
+	Comment
+	Replies []*CommentNode
+}
+
+// buildThreads links comments ordered by path into trees under the given
+// roots, replies whose parent is not visible are left out with their subtree
+func buildThreads(roots []Comment, replies []Comment) []*CommentNode {
+	nodes := make(map[uint]*CommentNode, len(roots)+len(replies))
+	threads := make([]*CommentNode, len(roots))
+	for i := range roots {
+		threads[i] = &CommentNode{Comment: roots[i]}
+		nodes[roots[i].ID] = threads[i]
+	}
+	for i := range replies {
+		parent, ok := nodes[*replies[i].ParentID]
+		if !ok {
+			continue
+		}
+		node := &CommentNode{Comment: replies[i]}
+		parent.Replies = append(parent.Replies, node)
+		nodes[node.ID] = node
+	}
+	return threads
+}
+
+// ListThreads loads a page of the top level comments of a post, oldest first
+// by default, each with all its visible replies in two queries per page
+func ListThreads(db *gorm.DB, viewer *User, postID uint, req PageRequest) (*Page[*CommentNode], error) {
+	post, err := GetVisiblePost(db, viewer, postID)
+	if err != nil {
+		return nil, err
+	}
+	query := visibleComments(db.Model(&Comment{}).Where("post_id = ? AND parent_id IS NULL", postID), viewer, post)
+	roots, err := paginate(query, req, "created_at", commentSortKeys)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page[*CommentNode]{NextCursor: roots.NextCursor, HasMore: roots.HasMore, Total: roots.Total}
+	if len(roots.Items) == 0 {
+		page.Items = []*CommentNode{}
+		return page, nil
+	}
+	rootIDs := make([]uint, len(roots.Items))
+	for i, root := range roots.Items {
+		rootIDs[i] = root.ID
+	}
+	var replies []Comment
+	err = visibleComments(db.Where("root_id IN ? AND parent_id IS NOT NULL", rootIDs), viewer, post).
+		Order("path").Find(&replies).Error
+	if err != nil {
+		return nil, err
+	}
+	page.Items = buildThreads(roots.Items, replies)
+	return page, nil
+}
+
+// GetThread loads a comment with all its visible replies
+func GetThread(db *gorm.DB, viewer *User, id uint) (*CommentNode, error) {
+	comment, err := GetComment(db, id)
+	if err != nil {
+		return nil, err
+	}
+	post, err := GetPost(db, comment.PostID)
+	if err != nil {
+		return nil, err
+	}
+	if !CanSeePost(viewer, post) || !CanSeeComment(viewer, comment, post) {
+		return nil, notFound("comment", id)
+	}
+	var replies []Comment
+	err = visibleComments(db.Where("root_id = ? AND path LIKE ? AND id <> ?", comment.RootID, comment.Path+"%", comment.ID), viewer, post).
+		Order("path").Find(&replies).Error
+	if err != nil {
+		return nil, err
+	}
+	return buildThreads([]Comment{*comment}, replies)[0], nil
+}
+
+// ModerateComment moves a comment to another moderation state on behalf of
+// actor and keeps the comment count and the search index in line with it
+func ModerateComment(db *gorm.DB, actor *User, id uint, state string) (*Comment, error) {
+	if !ValidCommentState(state) {
+		return nil, &ValidationError{Fields: map[string]string{"state": "must be pending, approved, rejected or spam"}}
+	}
+	var comment Comment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&comment, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return notFound("comment", id)
+			}
+			return err
+		}
+		post, err := GetPost(tx, comment.PostID)
+		if err != nil {
+			return err
+		}
+		if err := Authorize(actor, ActionModerateComment, post.UserID); err != nil {
+			return err
+		}
+		if comment.State == state {
+			return nil
+		}
+		delta := 0
+		if state == CommentApproved {
+			delta = 1
+		} else if comment.State == CommentApproved {
+			delta = -1
+		}
+		if err := tx.Model(&comment).UpdateColumn("state", state).Error; err != nil {
+			return err
+		}
+		comment.State = state
+		if delta != 0 {
+			if err := updateCommentCount(tx, comment.PostID, delta); err != nil {
+				return err
+			}
+		}
+		return indexComment(tx, comment.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// ListModerationQueue loads a page of the pending comments actor may
+// moderate, oldest first: all of them for admins, those on their own posts
+// for everybody else
+func ListModerationQueue(db *gorm.DB, actor *User, req PageRequest) (*Page[Comment], error) {
+	if actor == nil {
+		return nil, ErrUnauthenticated
+	}
+	query := db.Model(&Comment{}).Where("state = ?", CommentPending)
+	if actor.Role != RoleAdmin {
+		query = query.Where("post_id IN (?)", db.Model(&Post{}).Select("id").Where("user_id = ?", actor.ID))
+	}
+	return paginate(query, req, "created_at", commentSortKeys)
+}
@@ -0,0 +1,167 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// UserInput is the input of CreateUser
+type UserInput struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// UserPatch is the input of UpdateUser, nil fields are left unchanged,
+// only admins may change the role
+type UserPatch struct {
+	Name     *string `json:"name"`
+	Email    *string `json:"email"`
+	Password *string `json:"password"`
+	Role     *string `json:"role"`
+}
+
+func validName(name string) bool {
+	return strings.TrimSpace(name) != "" && utf8.RuneCountInString(name) <= 100
+}
+
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email && len(email) <= 255
+}
+
+func validPassword(password string) bool {
+	return len(password) >= 6 && len(password) <= 72
+}
+
+func (in UserInput) validate() error {
+	v := validator{}
+	v.check(validName(in.Name), "name", "is required and at most 100 characters")
+	v.check(validEmail(in.Email), "email", "must be a valid email address")
+	v.check(validPassword(in.Password), "password", "must be 6 to 72 characters")
+	return v.err()
+}
+
+func (p UserPatch) validate() error {
+	v := validator{}
+	v.check(p.Name == nil || validName(*p.Name), "name", "is required and at most 100 characters")
+	v.check(p.Email == nil || validEmail(*p.Email), "email", "must be a valid email address")
+	v.check(p.Password == nil || validPassword(*p.Password), "password", "must be 6 to 72 characters")
+	v.check(p.Role == nil || ValidRole(*p.Role), "role", "must be admin, author or reader")
+	return v.err()
+}
+
+// emailTaken turns a unique key violation on the email into ErrConflict
+func emailTaken(err error, email string) error {
+	if err != nil && isDuplicateKey(err) {
+		return fmt.Errorf("%w: email %s is already registered", ErrConflict, email)
+	}
+	return err
+}
+
+// CreateUser validates and creates a user with the author role, anyone may sign up
+func CreateUser(db *gorm.DB, in UserInput) (*User, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	user := User{Name: strings.TrimSpace(in.Name), Email: in.Email, Role: RoleAuthor}
+	if err := user.SetPassword(in.Password); err != nil {
+		return nil, err
+	}
+	if err := db.Create(&user).Error; err != nil {
+		return nil, emailTaken(err, in.Email)
+	}
+	return &user, nil
+}
+
+// GetUser loads a user
+func GetUser(db *gorm.DB, id uint) (*User, error) {
+	var user User
+	if err := db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, notFound("user", id)
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListUsers loads a page of users on behalf of viewer, oldest first by
+// default. Only admins may sort by email, the order would leak the emails
+// to everyone else.
+func ListUsers(db *gorm.DB, viewer *User, req PageRequest) (*Page[User], error) {
+	sortKeys := userSortKeys
+	if viewer != nil && viewer.Role == RoleAdmin {
+		sortKeys = adminUserSortKeys
+	}
+	return paginate(db.Model(&User{}), req, "created_at", sortKeys)
+}
+
+// UpdateUser applies the non nil fields of patch on behalf of actor
+func UpdateUser(db *gorm.DB, actor *User, id uint, patch UserPatch) (*User, error) {
+	if err := Authorize(actor, ActionUpdateUser, id); err != nil {
+		return nil, err
+	}
+	if patch.Role != nil {
+		if err := Authorize(actor, ActionSetRole, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := patch.validate(); err != nil {
+		return nil, err
+	}
+	user, err := GetUser(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Name != nil {
+		user.Name = strings.TrimSpace(*patch.Name)
+	}
+	if patch.Email != nil {
+		user.Email = *patch.Email
+	}
+	if patch.Password != nil {
+		if err := user.SetPassword(*patch.Password); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Role != nil {
+		user.Role = *patch.Role
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return emailTaken(err, user.Email)
+		}
+		if patch.Password != nil {
+			// a new password logs out every session started with the old one
+			return RevokeUserSessions(tx, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DeleteUser soft deletes a user on behalf of actor and ends the sessions of the user
+func DeleteUser(db *gorm.DB, actor *User, id uint) error {
+	if err := Authorize(actor, ActionDeleteUser, id); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&User{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return notFound("user", id)
+		}
+		return RevokeUserSessions(tx, id)
+	})
+}
@@ -0,0 +1,100 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/test/init_project/bank"
+	"gorm.io/gorm"
+)
+
+// errors of the chain simulator
+var (
+	ErrKnownTransaction = errors.New("chain: transaction already known")
+	ErrMempoolFull      = errors.New("chain: mempool is full")
+	ErrBlockNotFound    = errors.New("chain: block not found")
+	ErrReceiptNotFound  = errors.New("chain: receipt not found")
+)
+
+// receipt status values
+const (
+	ReceiptStatusSuccess = "success"
+	ReceiptStatusFailed  = "failed"
+)
+
+// zeroHash is the parent hash of the genesis block
+var zeroHash = strings.Repeat("0", 64)
+
+// Block is a produced block. Number is the primary key, so a second producer building on the
+// same parent fails to insert its block instead of forking the chain: every stored block is final
+type Block struct {
+	Number     uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Hash       string `gorm:"size:64;uniqueIndex"`
+	ParentHash string `gorm:"size:64"`
+	TxRoot     string `gorm:"size:64"`
+	TxCount    int
+	Timestamp  time.Time
+}
+
+// Receipt is the outcome of a transfer included in a block, it also keeps the signed transfer itself
+type Receipt struct {
+	TxHash        string  `gorm:"primaryKey;size:64"`
+	BlockNumber   uint64  `gorm:"index:idx_receipt_position,priority:1"`
+	Position      int     `gorm:"index:idx_receipt_position,priority:2"` // index in the block
+	From          string  `gorm:"size:64;index"`
+	To            string  `gorm:"size:64"`
+	Amount        float64 `gorm:"type:decimal(10,2)"`
+	Nonce         uint64
+	Signature     string `gorm:"size:128"`
+	Status        string `gorm:"size:20"`
+	Error         string `gorm:"size:255"`
+	TransactionID *uint  // ledger transaction of a successful transfer
+}
+
+// AutoMigrate creates or updates the tables used by the chain package
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Block{}, &Receipt{})
+}
+
+// TxHash identifies a signed transfer: the sha256 of its signed message and signature
+func TxHash(t bank.SignedTransfer) (string, error) {
+	msg, err := t.Message()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append(msg, t.Signature...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// txRoot commits to the transactions of a block in order
+func txRoot(hashes []string) string {
+	h := sha256.New()
+	for _, hash := range hashes {
+		h.Write([]byte(hash))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// blockHash hashes the header fields of b
+func blockHash(b *Block) string {
+	buf := make([]byte, 0, 256)
+	buf = append(buf, "web3-chain-block-v1"...)
+	buf = append(buf, b.ParentHash...)
+	buf = binary.BigEndian.AppendUint64(buf, b.Number)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(b.Timestamp.UnixMilli()))
+	buf = append(buf, b.TxRoot...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(b.TxCount))
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// genesisBlock is the same for every node so that hashes are reproducible
+func genesisBlock() Block {
+	b := Block{Number: 0, ParentHash: zeroHash, TxRoot: txRoot(nil), Timestamp: time.Unix(0, 0).UTC()}
+	b.Hash = blockHash(&b)
+	return b
+}
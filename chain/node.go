@@ -0,0 +1,353 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/test/init_project/bank"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// pendingTx is a verified signed transfer waiting in the mempool
+type pendingTx struct {
+	hash     string
+	transfer bank.SignedTransfer // normalized by Submit
+	added    time.Time
+}
+
+// Node is a single producer chain on top of the bank ledger. Submitted transfers wait in an
+// in-memory mempool, ProduceBlock executes them with bank.TransferSigned inside one database
+// transaction per block and stores the block with a receipt per transfer.
+// Block production only happens when called (or on the Run interval), and the clock is
+// replaceable, so a node is fully deterministic when driven by hand
+type Node struct {
+	db          *gorm.DB
+	Now         func() time.Time
+	MaxBlockTxs int                   // transfers per block
+	MaxPending  int                   // mempool capacity
+	PendingTTL  time.Duration         // how long a transfer may wait for a missing nonce
+	Options     []bank.TransferOption // passed to every TransferSigned call
+
+	mu      sync.Mutex // guards pending
+	pending []pendingTx
+	produce sync.Mutex // serializes block production
+	head    Block
+}
+
+// NewNode creates the genesis block when needed and resumes from the latest stored block
+func NewNode(db *gorm.DB) (*Node, error) {
+	genesis := genesisBlock()
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&genesis).Error; err != nil {
+		return nil, err
+	}
+	n := &Node{db: db, Now: time.Now, MaxBlockTxs: 100, MaxPending: 10000, PendingTTL: 10 * time.Minute}
+	if err := n.loadHead(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *Node) loadHead() error {
+	var head Block
+	if err := n.db.Order("number DESC").First(&head).Error; err != nil {
+		return err
+	}
+	n.head = head
+	return nil
+}
+
+// Submit verifies a signed transfer and adds it to the mempool, returning its hash.
+// Transfers whose nonce is already used are rejected with a *bank.NonceError, higher nonces
+// are queued until the missing ones arrive, for at most PendingTTL
+func (n *Node) Submit(t bank.SignedTransfer) (string, error) {
+	if err := t.Verify(); err != nil {
+		return "", err
+	}
+	// the same wallet written in another case must not count as another sender
+	t, err := t.Normalized()
+	if err != nil {
+		return "", err
+	}
+	hash, err := TxHash(t)
+	if err != nil {
+		return "", err
+	}
+	sender, err := bank.FindWallet(n.db, t.From)
+	if err != nil {
+		return "", err
+	}
+	if _, err := bank.FindWallet(n.db, t.To); err != nil {
+		return "", err
+	}
+	if t.Nonce < sender.Nonce {
+		return "", &bank.NonceError{AccountID: sender.ID, Expected: sender.Nonce, Got: t.Nonce}
+	}
+	var mined int64
+	if err := n.db.Model(&Receipt{}).Where("tx_hash = ?", hash).Count(&mined).Error; err != nil {
+		return "", err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if mined > 0 || n.indexOf(hash) >= 0 {
+		return "", fmt.Errorf("%w: %s", ErrKnownTransaction, hash)
+	}
+	if len(n.pending) >= n.MaxPending {
+		return "", ErrMempoolFull
+	}
+	n.pending = append(n.pending, pendingTx{hash: hash, transfer: t, added: n.Now()})
+	return hash, nil
+}
+
+// indexOf returns the mempool position of hash or -1, n.mu must be held
+func (n *Node) indexOf(hash string) int {
+	for i, p := range n.pending {
+		if p.hash == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// removePending drops the transfers matched by drop from the mempool
+func (n *Node) removePending(drop func(p pendingTx) bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	kept := n.pending[:0]
+	for _, p := range n.pending {
+		if !drop(p) {
+			kept = append(kept, p)
+		}
+	}
+	n.pending = kept
+}
+
+// selectPending picks the transfers of the next block: in arrival order, but for each sender
+// only the run of consecutive nonces starting at its current account nonce.
+// It also returns the transfers to drop: those whose nonce is already used and those that
+// waited longer than PendingTTL for a missing nonce
+func (n *Node) selectPending() (selected []pendingTx, stale map[string]bool, err error) {
+	n.mu.Lock()
+	snapshot := append([]pendingTx(nil), n.pending...)
+	n.mu.Unlock()
+
+	next := make(map[string]uint64)
+	stale = make(map[string]bool)
+	for _, p := range snapshot {
+		if _, ok := next[p.transfer.From]; ok {
+			continue
+		}
+		sender, err := bank.FindWallet(n.db, p.transfer.From)
+		if err != nil {
+			return nil, nil, err
+		}
+		next[p.transfer.From] = sender.Nonce
+	}
+
+	used := make([]bool, len(snapshot))
+	for progress := true; progress && len(selected) < n.MaxBlockTxs; {
+		progress = false
+		for i, p := range snapshot {
+			if used[i] || len(selected) >= n.MaxBlockTxs {
+				continue
+			}
+			switch {
+			case p.transfer.Nonce < next[p.transfer.From]:
+				used[i] = true
+				stale[p.hash] = true
+			case p.transfer.Nonce == next[p.transfer.From]:
+				used[i] = true
+				selected = append(selected, p)
+				next[p.transfer.From]++
+				progress = true
+			}
+		}
+	}
+	if n.PendingTTL > 0 {
+		expired := n.Now().Add(-n.PendingTTL)
+		for i, p := range snapshot {
+			if !used[i] && p.transfer.Nonce > next[p.transfer.From] && p.added.Before(expired) {
+				stale[p.hash] = true
+			}
+		}
+	}
+	return selected, stale, nil
+}
+
+// ProduceBlock executes the next batch of pending transfers and stores them as a new block.
+// It returns nil without error when there is nothing to include. A transfer that fails gets a
+// failed receipt and does not use its nonce, the later transfers of the same sender are dropped
+// from the mempool since they could never run; the sender has to submit them again
+func (n *Node) ProduceBlock() (*Block, error) {
+	n.produce.Lock()
+	defer n.produce.Unlock()
+
+	selected, stale, err := n.selectPending()
+	if err != nil {
+		return nil, err
+	}
+	n.removePending(func(p pendingTx) bool { return stale[p.hash] })
+	if len(selected) == 0 {
+		return nil, nil
+	}
+
+	block := Block{
+		Number:     n.head.Number + 1,
+		ParentHash: n.head.Hash,
+		Timestamp:  n.Now().Truncate(time.Millisecond),
+	}
+	if block.Timestamp.Before(n.head.Timestamp) {
+		block.Timestamp = n.head.Timestamp
+	}
+	opts := append(append([]bank.TransferOption(nil), n.Options...), bank.WithRetryPolicy(bank.RetryPolicy{MaxAttempts: 1}))
+
+	var receipts []Receipt
+	included := make(map[string]bool)
+	failed := make(map[string]uint64) // the nonce of the failed transfer of a sender in this block
+	err = n.db.Transaction(func(tx *gorm.DB) error {
+		receipts, included, failed = nil, make(map[string]bool), make(map[string]uint64)
+		var hashes []string
+		for _, p := range selected {
+			t := p.transfer
+			if _, ok := failed[t.From]; ok {
+				continue
+			}
+			// every transfer runs in a savepoint, a failure only undoes that transfer
+			transaction, err := bank.TransferSigned(tx, t, opts...)
+			if err != nil && bank.IsRetryable(err) {
+				// the database transaction may be gone (deadlock), retry the whole block later
+				return err
+			}
+			receipt := Receipt{
+				TxHash:      p.hash,
+				BlockNumber: block.Number,
+				Position:    len(receipts),
+				From:        t.From,
+				To:          t.To,
+				Amount:      t.Amount,
+				Nonce:       t.Nonce,
+				Signature:   t.Signature,
+				Status:      ReceiptStatusSuccess,
+			}
+			if err != nil {
+				failed[t.From] = t.Nonce
+				receipt.Status = ReceiptStatusFailed
+				receipt.Error = bank.Truncate(err.Error(), 255)
+			} else {
+				receipt.TransactionID = &transaction.ID
+			}
+			receipts = append(receipts, receipt)
+			hashes = append(hashes, p.hash)
+			included[p.hash] = true
+		}
+
+		block.TxCount = len(receipts)
+		block.TxRoot = txRoot(hashes)
+		block.Hash = blockHash(&block)
+		if err := tx.Create(&block).Error; err != nil {
+			return err
+		}
+		if len(receipts) > 0 {
+			return tx.Create(&receipts).Error
+		}
+		return nil
+	})
+	if err != nil {
+		// another producer may have extended the chain, continue from what is stored
+		if headErr := n.loadHead(); headErr != nil {
+			log.Printf("reload chain head failed: %v", headErr)
+		}
+		return nil, err
+	}
+	n.head = block
+	n.removePending(func(p pendingTx) bool {
+		nonce, ok := failed[p.transfer.From]
+		return included[p.hash] || (ok && p.transfer.Nonce > nonce)
+	})
+	return &block, nil
+}
+
+// Run produces a block every interval until ctx is cancelled
+func (n *Node) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if _, err := n.ProduceBlock(); err != nil {
+			log.Printf("produce block failed: %v", err)
+		}
+	}
+}
+
+// Head returns the latest block, blocks are final as soon as they are stored
+func (n *Node) Head() Block {
+	n.produce.Lock()
+	defer n.produce.Unlock()
+	return n.head
+}
+
+// PendingCount returns the number of transfers in the mempool
+func (n *Node) PendingCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.pending)
+}
+
+// PendingTransfer returns a transfer that is still in the mempool
+func (n *Node) PendingTransfer(hash string) (bank.SignedTransfer, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if i := n.indexOf(hash); i >= 0 {
+		return n.pending[i].transfer, true
+	}
+	return bank.SignedTransfer{}, false
+}
+
+// BlockByNumber loads a block by its number
+func (n *Node) BlockByNumber(number uint64) (*Block, error) {
+	return n.findBlock("number = ?", number)
+}
+
+// BlockByHash loads a block by its hash
+func (n *Node) BlockByHash(hash string) (*Block, error) {
+	return n.findBlock("hash = ?", hash)
+}
+
+func (n *Node) findBlock(query string, arg interface{}) (*Block, error) {
+	var block Block
+	if err := n.db.Where(query, arg).First(&block).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBlockNotFound
+		}
+		return nil, err
+	}
+	return &block, nil
+}
+
+// Receipt loads the receipt of a mined transfer
+func (n *Node) Receipt(txHash string) (*Receipt, error) {
+	var receipt Receipt
+	if err := n.db.Where("tx_hash = ?", txHash).First(&receipt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReceiptNotFound
+		}
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// BlockReceipts loads the receipts of a block in execution order
+func (n *Node) BlockReceipts(number uint64) ([]Receipt, error) {
+	var receipts []Receipt
+	err := n.db.Where("block_number = ?", number).Order("position").Find(&receipts).Error
+	return receipts, err
+}
@@ -0,0 +1,77 @@
+package ethrpc
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/test/init_project/bank"
+)
+
+// ErrInvalidRawTransaction is returned for raw transactions that cannot be decoded
+var ErrInvalidRawTransaction = errors.New("ethrpc: invalid raw transaction")
+
+// rawTxType is the first byte of a raw ledger transaction
+const rawTxType = 0xed
+
+// rawTxSize is type || from key || to key || amount in cents || nonce || signature
+const rawTxSize = 1 + 2*ed25519.PublicKeySize + 8 + 8 + ed25519.SignatureSize
+
+// weiPerCent converts ledger cents to wei, so 1.00 on an account shows as 1 ether in a wallet
+var weiPerCent = big.NewInt(1e16)
+
+// EncodeRawTransaction serializes a signed transfer for eth_sendRawTransaction. Ethereum's RLP
+// secp256k1 transactions cannot be mapped onto the ed25519 wallets of the ledger, so the simulated
+// ledger uses its own fixed layout:
+//
+//	0xed || from (32) || to (32) || amount in cents (8, big endian) || nonce (8, big endian) || signature (64)
+func EncodeRawTransaction(t bank.SignedTransfer) (string, error) {
+	from, err := hex.DecodeString(t.From)
+	if err != nil || len(from) != ed25519.PublicKeySize {
+		return "", bank.ErrInvalidPublicKey
+	}
+	to, err := hex.DecodeString(t.To)
+	if err != nil || len(to) != ed25519.PublicKeySize {
+		return "", bank.ErrInvalidPublicKey
+	}
+	sig, err := hex.DecodeString(t.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return "", bank.ErrInvalidSignature
+	}
+	b := make([]byte, 0, rawTxSize)
+	b = append(b, rawTxType)
+	b = append(b, from...)
+	b = append(b, to...)
+	b = binary.BigEndian.AppendUint64(b, uint64(math.Round(t.Amount*100)))
+	b = binary.BigEndian.AppendUint64(b, t.Nonce)
+	b = append(b, sig...)
+	return "0x" + hex.EncodeToString(b), nil
+}
+
+// DecodeRawTransaction parses the output of EncodeRawTransaction
+func DecodeRawTransaction(raw string) (bank.SignedTransfer, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(raw, "0x"))
+	if err != nil || len(b) != rawTxSize || b[0] != rawTxType {
+		return bank.SignedTransfer{}, ErrInvalidRawTransaction
+	}
+	b = b[1:]
+	t := bank.SignedTransfer{
+		From: hex.EncodeToString(b[:ed25519.PublicKeySize]),
+		To:   hex.EncodeToString(b[ed25519.PublicKeySize : 2*ed25519.PublicKeySize]),
+	}
+	b = b[2*ed25519.PublicKeySize:]
+	t.Amount = float64(binary.BigEndian.Uint64(b[:8])) / 100
+	t.Nonce = binary.BigEndian.Uint64(b[8:16])
+	t.Signature = hex.EncodeToString(b[16:])
+	return t, nil
+}
+
+// toWei converts a ledger amount to wei
+func toWei(amount float64) *big.Int {
+	cents := big.NewInt(int64(math.Round(amount * 100)))
+	return cents.Mul(cents, weiPerCent)
+}
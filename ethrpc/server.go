@@ -0,0 +1,367 @@
+package ethrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/chain"
+	"gorm.io/gorm"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeServerError    = -32000
+)
+
+// maxBodySize limits the size of a request body
+const maxBodySize = 1 << 20
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error object
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("ethrpc: %d %s", e.Code, e.Message) }
+
+func invalidParams(format string, args ...interface{}) *Error {
+	return &Error{Code: codeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// Server answers a subset of the Ethereum JSON-RPC API from the ledger and the chain node:
+// eth_chainId, net_version, eth_blockNumber, eth_getBalance, eth_getTransactionByHash,
+// eth_getTransactionReceipt and eth_sendRawTransaction. Addresses are the 32 byte ed25519
+// public keys of wallet accounts, balances are reported in wei with 1.00 = 1 ether
+type Server struct {
+	db      *gorm.DB
+	node    *chain.Node
+	ChainID uint64
+	methods map[string]func(params json.RawMessage) (interface{}, error)
+}
+
+// NewServer creates a server for node, use it as an http.Handler
+func NewServer(db *gorm.DB, node *chain.Node) *Server {
+	s := &Server{db: db, node: node, ChainID: 1337}
+	s.methods = map[string]func(json.RawMessage) (interface{}, error){
+		"eth_chainId":               s.chainID,
+		"net_version":               s.netVersion,
+		"eth_blockNumber":           s.blockNumber,
+		"eth_getBalance":            s.getBalance,
+		"eth_getTransactionByHash":  s.getTransactionByHash,
+		"eth_getTransactionReceipt": s.getTransactionReceipt,
+		"eth_sendRawTransaction":    s.sendRawTransaction,
+	}
+	return s
+}
+
+// ServeHTTP handles single and batch JSON-RPC requests sent with POST
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result interface{}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			result = errorResponse(nil, &Error{Code: codeParseError, Message: "invalid batch"})
+		} else {
+			responses := make([]response, len(batch))
+			for i, raw := range batch {
+				responses[i] = s.handle(raw)
+			}
+			result = responses
+		}
+	} else {
+		result = s.handle(body)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func errorResponse(id json.RawMessage, err *Error) response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return response{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// handle runs one request
+func (s *Server) handle(raw json.RawMessage) response {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return errorResponse(nil, &Error{Code: codeParseError, Message: err.Error()})
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, &Error{Code: codeInvalidRequest, Message: "invalid request"})
+	}
+	method, ok := s.methods[req.Method]
+	if !ok {
+		return errorResponse(req.ID, &Error{Code: codeMethodNotFound, Message: "the method " + req.Method + " does not exist"})
+	}
+	result, err := method(req.Params)
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: codeServerError, Message: err.Error()}
+		}
+		return errorResponse(req.ID, rpcErr)
+	}
+	if result == nil {
+		// unknown transactions and receipts are a null result, not an error
+		return response{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage("null")}
+	}
+	return response{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// parseParams decodes the positional params into dst, missing trailing params keep their zero value
+func parseParams(params json.RawMessage, required int, dst ...interface{}) error {
+	var list []json.RawMessage
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &list); err != nil {
+			return invalidParams("params must be an array")
+		}
+	}
+	if len(list) < required {
+		return invalidParams("missing value for required argument %d", len(list))
+	}
+	for i := 0; i < len(dst) && i < len(list); i++ {
+		if err := json.Unmarshal(list[i], dst[i]); err != nil {
+			return invalidParams("invalid argument %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// quantity encodes a number as a hex JSON-RPC quantity
+func quantity(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+// data encodes a ledger hash or key as hex JSON-RPC data
+func data(hexString string) string {
+	return "0x" + hexString
+}
+
+// parseData strips the 0x prefix of a hash or address and checks its length in bytes
+func parseData(s string, size int) (string, error) {
+	s = strings.ToLower(strings.TrimPrefix(s, "0x"))
+	if len(s) != 2*size {
+		return "", invalidParams("expected %d bytes of hex data", size)
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return "", invalidParams("invalid hex data")
+		}
+	}
+	return s, nil
+}
+
+func (s *Server) chainID(json.RawMessage) (interface{}, error) {
+	return quantity(s.ChainID), nil
+}
+
+func (s *Server) netVersion(json.RawMessage) (interface{}, error) {
+	return strconv.FormatUint(s.ChainID, 10), nil
+}
+
+func (s *Server) blockNumber(json.RawMessage) (interface{}, error) {
+	return quantity(s.node.Head().Number), nil
+}
+
+// getBalance only knows the current state, historical block numbers are rejected
+func (s *Server) getBalance(params json.RawMessage) (interface{}, error) {
+	var address, block string
+	if err := parseParams(params, 1, &address, &block); err != nil {
+		return nil, err
+	}
+	switch block {
+	case "", "latest", "pending", "safe", "finalized", quantity(s.node.Head().Number):
+	default:
+		return nil, invalidParams("historical state is not available for block %s", block)
+	}
+	key, err := parseData(address, 32)
+	if err != nil {
+		return nil, err
+	}
+	wallet, err := bank.FindWallet(s.db, key)
+	if errors.Is(err, bank.ErrWalletNotFound) {
+		// unknown addresses have an empty balance, like on Ethereum
+		return "0x0", nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return "0x" + toWei(wallet.Balance).Text(16), nil
+}
+
+// txObject is the result of eth_getTransactionByHash
+type txObject struct {
+	Hash             string  `json:"hash"`
+	Nonce            string  `json:"nonce"`
+	BlockHash        *string `json:"blockHash"`
+	BlockNumber      *string `json:"blockNumber"`
+	TransactionIndex *string `json:"transactionIndex"`
+	From             string  `json:"from"`
+	To               string  `json:"to"`
+	Value            string  `json:"value"`
+	Gas              string  `json:"gas"`
+	GasPrice         string  `json:"gasPrice"`
+	Input            string  `json:"input"`
+	ChainID          string  `json:"chainId"`
+}
+
+// receiptObject is the result of eth_getTransactionReceipt
+type receiptObject struct {
+	TransactionHash   string        `json:"transactionHash"`
+	TransactionIndex  string        `json:"transactionIndex"`
+	BlockHash         string        `json:"blockHash"`
+	BlockNumber       string        `json:"blockNumber"`
+	From              string        `json:"from"`
+	To                string        `json:"to"`
+	CumulativeGasUsed string        `json:"cumulativeGasUsed"`
+	GasUsed           string        `json:"gasUsed"`
+	EffectiveGasPrice string        `json:"effectiveGasPrice"`
+	ContractAddress   *string       `json:"contractAddress"`
+	Logs              []interface{} `json:"logs"`
+	LogsBloom         string        `json:"logsBloom"`
+	Type              string        `json:"type"`
+	Status            string        `json:"status"`
+}
+
+func (s *Server) newTxObject(hash string, t bank.SignedTransfer) *txObject {
+	return &txObject{
+		Hash:     data(hash),
+		Nonce:    quantity(t.Nonce),
+		From:     data(t.From),
+		To:       data(t.To),
+		Value:    "0x" + toWei(t.Amount).Text(16),
+		Gas:      "0x0",
+		GasPrice: "0x0",
+		Input:    "0x",
+		ChainID:  quantity(s.ChainID),
+	}
+}
+
+// findReceipt returns the receipt and block of a mined transfer, nil when it is not mined
+func (s *Server) findReceipt(params json.RawMessage) (string, *chain.Receipt, *chain.Block, error) {
+	var hash string
+	if err := parseParams(params, 1, &hash); err != nil {
+		return "", nil, nil, err
+	}
+	hash, err := parseData(hash, 32)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	receipt, err := s.node.Receipt(hash)
+	if errors.Is(err, chain.ErrReceiptNotFound) {
+		return hash, nil, nil, nil
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+	block, err := s.node.BlockByNumber(receipt.BlockNumber)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return hash, receipt, block, nil
+}
+
+func (s *Server) getTransactionByHash(params json.RawMessage) (interface{}, error) {
+	hash, receipt, block, err := s.findReceipt(params)
+	if err != nil {
+		return nil, err
+	}
+	if receipt == nil {
+		if t, ok := s.node.PendingTransfer(hash); ok {
+			return s.newTxObject(hash, t), nil
+		}
+		return nil, nil
+	}
+	tx := s.newTxObject(hash, bank.SignedTransfer{
+		From:   receipt.From,
+		To:     receipt.To,
+		Amount: receipt.Amount,
+		Nonce:  receipt.Nonce,
+	})
+	blockHash, blockNumber, index := data(block.Hash), quantity(block.Number), quantity(uint64(receipt.Position))
+	tx.BlockHash, tx.BlockNumber, tx.TransactionIndex = &blockHash, &blockNumber, &index
+	return tx, nil
+}
+
+func (s *Server) getTransactionReceipt(params json.RawMessage) (interface{}, error) {
+	_, receipt, block, err := s.findReceipt(params)
+	if err != nil || receipt == nil {
+		return nil, err
+	}
+	status := "0x1"
+	if receipt.Status != chain.ReceiptStatusSuccess {
+		status = "0x0"
+	}
+	return &receiptObject{
+		TransactionHash:   data(receipt.TxHash),
+		TransactionIndex:  quantity(uint64(receipt.Position)),
+		BlockHash:         data(block.Hash),
+		BlockNumber:       quantity(block.Number),
+		From:              data(receipt.From),
+		To:                data(receipt.To),
+		CumulativeGasUsed: "0x0",
+		GasUsed:           "0x0",
+		EffectiveGasPrice: "0x0",
+		Logs:              []interface{}{},
+		LogsBloom:         "0x" + strings.Repeat("0", 512),
+		Type:              "0x0",
+		Status:            status,
+	}, nil
+}
+
+// sendRawTransaction decodes a raw ledger transaction (see EncodeRawTransaction) and submits it
+func (s *Server) sendRawTransaction(params json.RawMessage) (interface{}, error) {
+	var raw string
+	if err := parseParams(params, 1, &raw); err != nil {
+		return nil, err
+	}
+	t, err := DecodeRawTransaction(raw)
+	if err != nil {
+		return nil, invalidParams("%v", err)
+	}
+	hash, err := s.node.Submit(t)
+	if err != nil {
+		return nil, err
+	}
+	return data(hash), nil
+}
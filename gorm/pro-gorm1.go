@@ -6,115 +6,11 @@ import (
 
 	"gorm.io/gorm"
 
+	"github.com/test/init_project/blog"
 	"github.com/test/init_project/config"
 )
 
-// User model definition
-type User struct {
-	gorm.Model
-	Name     string
-	Email    string `gorm:"uniqueIndex;size:255"`
-	Password string
-	Posts    []Post `gorm:"foreignKey:UserID"`
-}
-
-// Post model definition
-type Post struct {
-	gorm.Model
-	Title         string `gorm:"size:255"`
-	Content       string `gorm:"type:text"`
-	UserID        uint
-	User          User
-	Comments      []Comment `gorm:"foreignKey:PostID"`
-	CommentCount  int       `gorm:"default:0;check:comment_count >= 0"`                   // add constraint to ensure the count is not negative
-	CommentStatus string    `gorm:"default:'有评论';check:comment_status IN ('有评论', '无评论')"` // add constraint to ensure the status is valid
-}
-
-// Comment model definition
-type Comment struct {
-	gorm.Model
-	Content string `gorm:"type:text"`
-	UserID  uint
-	PostID  uint
-	User    User `gorm:"foreignKey:UserID"`
-	Post    Post `gorm:"foreignKey:PostID"`
-}
-
-// safer comment create hook function
-func (c *Comment) AfterCreate(tx *gorm.DB) error {
-	// use atomic operation to increase the comment count, avoid race condition
-	result := tx.Model(&Post{}).
-		Where("id = ?", c.PostID).
-		UpdateColumn("comment_count", gorm.Expr("comment_count + ?", 1))
-
-	if result.Error != nil {
-		return fmt.Errorf("update post comment count failed: %v", result.Error)
-	}
-
-	// check the updated rows, ensure the post exists
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("post with id %d not found", c.PostID)
-	}
-
-	// use transaction level query to check the status
-	var post Post
-	if err := tx.Select("comment_count, comment_status").
-		Where("id = ?", c.PostID).
-		First(&post).Error; err != nil {
-		return fmt.Errorf("query post failed: %v", err)
-	}
-
-	// if the comment count is 1, update the status
-	if post.CommentCount == 1 && post.CommentStatus != "有评论" {
-		if err := tx.Model(&Post{}).
-			Where("id = ? AND comment_status != ?", c.PostID, "有评论").
-			Update("comment_status", "有评论").Error; err != nil {
-			return fmt.Errorf("update post status failed: %v", err)
-		}
-		fmt.Printf("post id: %d received the first comment, status updated to '有评论'\n", c.PostID)
-	}
-
-	return nil
-}
-
-// safer comment delete hook function
-func (c *Comment) AfterDelete(tx *gorm.DB) error {
-	// use atomic operation to reduce the comment count, avoid race condition
-	result := tx.Model(&Post{}).
-		Where("id = ?", c.PostID).
-		UpdateColumn("comment_count", gorm.Expr("comment_count - ?", 1))
-
-	if result.Error != nil {
-		return fmt.Errorf("update post comment count failed: %v", result.Error)
-	}
-
-	// check the updated rows, ensure the post exists
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("post with id %d not found", c.PostID)
-	}
-
-	// use transaction level query to check the status
-	var post Post
-	if err := tx.Select("comment_count, comment_status").
-		Where("id = ?", c.PostID).
-		First(&post).Error; err != nil {
-		return fmt.Errorf("query post failed: %v", err)
-	}
-
-	// if the comment count is 0, update the status
-	if post.CommentCount == 0 && post.CommentStatus != "无评论" {
-		if err := tx.Model(&Post{}).
-			Where("id = ? AND comment_status != ?", c.PostID, "无评论").
-			Update("comment_status", "无评论").Error; err != nil {
-			return fmt.Errorf("update post status failed: %v", err)
-		}
-		fmt.Printf("post id: %d comment is empty, status updated to '无评论'\n", c.PostID)
-	}
-
-	return nil
-}
-
-func printUserPostsWithComments(user User) {
+func printUserPostsWithComments(user blog.User) {
 	fmt.Printf("user %s's posts:\n", user.Name)
 	for _, post := range user.Posts {
 		fmt.Printf("- %s (comment count: %d):\n", post.Title, len(post.Comments))
@@ -127,13 +23,13 @@ func printUserPostsWithComments(user User) {
 func insertTestData(db *gorm.DB) error {
 	// check if there is data
 	var userCount int64
-	db.Model(&User{}).Count(&userCount)
+	db.Model(&blog.User{}).Count(&userCount)
 	if userCount > 0 {
 		return nil // there is data, not insert again
 	}
 
 	// create users
-	users := []User{
+	users := []blog.User{
 		{Name: "张三", Email: "zhangsan@example.com", Password: "123456"},
 		{Name: "李四", Email: "lisi@example.com", Password: "123456"},
 		{Name: "王五", Email: "wangwu@example.com", Password: "123456"},
@@ -144,11 +40,11 @@ func insertTestData(db *gorm.DB) error {
 		}
 	}
 
-	// create posts
-	posts := []Post{
-		{Title: "GORM 入门教程", Content: "这是一篇关于GORM的入门教程...", UserID: 1, CommentCount: 2},
-		{Title: "Go语言实战", Content: "介绍Go语言的高级特性和最佳实践...", UserID: 1, CommentCount: 1},
-		{Title: "微服务架构", Content: "讨论微服务设计模式和实现方法...", UserID: 2, CommentCount: 3},
+	// create posts, the comment hooks count their comments
+	posts := []blog.Post{
+		{Title: "GORM 入门教程", Content: "这是一篇关于GORM的入门教程...", UserID: 1},
+		{Title: "Go语言实战", Content: "介绍Go语言的高级特性和最佳实践...", UserID: 1},
+		{Title: "微服务架构", Content: "讨论微服务设计模式和实现方法...", UserID: 2},
 	}
 	for _, post := range posts {
 		if err := db.Create(&post).Error; err != nil {
@@ -157,7 +53,7 @@ func insertTestData(db *gorm.DB) error {
 	}
 
 	// create comments
-	comments := []Comment{
+	comments := []blog.Comment{
 		{Content: "非常实用的教程！", UserID: 2, PostID: 1},
 		{Content: "期待后续更新！", UserID: 3, PostID: 1},
 		{Content: "适合中级开发者", UserID: 2, PostID: 2},
@@ -170,6 +66,31 @@ func insertTestData(db *gorm.DB) error {
 			return err
 		}
 	}
+	var commented []blog.Post
+	if err := db.Select("id", "comment_count", "comment_status").Order("id").Find(&commented).Error; err != nil {
+		return err
+	}
+	for _, post := range commented {
+		fmt.Printf("post id: %d comment count: %d, status: '%s'\n", post.ID, post.CommentCount, post.CommentStatus)
+	}
+
+	// likes go through the counter buffer of Engagement, flushed at the end
+	engagement := blog.NewEngagement(db)
+	likes := map[uint][]uint{1: {2, 3}, 2: {1, 2, 3}, 3: {1}} // post: users
+	for postID, userIDs := range likes {
+		for _, userID := range userIDs {
+			user, err := blog.GetUser(db, userID)
+			if err != nil {
+				return err
+			}
+			if _, err := engagement.Like(user, blog.TargetPost, postID); err != nil {
+				return err
+			}
+		}
+	}
+	if err := engagement.Flush(); err != nil {
+		return err
+	}
 
 	fmt.Println("insert test data successfully")
 	return nil
@@ -180,7 +101,7 @@ func main() {
 	db := config.GetDB()
 
 	// auto migrate model to database
-	err := db.AutoMigrate(&User{}, &Post{}, &Comment{})
+	err := blog.AutoMigrate(db)
 	if err != nil {
 		log.Fatal("table structure migration failed:", err)
 	}
@@ -192,18 +113,49 @@ func main() {
 		log.Fatal("insert test data failed:", err)
 	}
 
-	var user User
-	if err := db.Preload("Posts.Comments").First(&user, 1).Error; err != nil {
+	// fix counters that drifted, such as those of data seeded with hand
+	// written counts or changed by statements that skip the hooks
+	report, err := blog.ReconcileCommentCounts(db, true)
+	if err != nil {
+		log.Fatal("reconcile comment counts failed:", err)
+	}
+	for _, d := range report.Drifts {
+		fmt.Printf("post id: %d comment count %d fixed to %d\n", d.PostID, d.StoredCount, d.ActualCount)
+	}
+
+	// load the user's newest posts and their first comments page by page,
+	// instead of preloading every post and comment of the user
+	user, err := blog.GetUser(db, 1)
+	if err != nil {
 		log.Fatal("user not found:", err)
 	}
-	printUserPostsWithComments(user)
+	posts, err := blog.ListUserPosts(db, user.ID, blog.PageRequest{Limit: 10})
+	if err != nil {
+		log.Fatal("query posts failed:", err)
+	}
+	for _, post := range posts.Items {
+		comments, err := blog.ListPostComments(db, post.ID, blog.PageRequest{Limit: 10})
+		if err != nil {
+			log.Fatal("query comments failed:", err)
+		}
+		post.Comments = comments.Items
+		user.Posts = append(user.Posts, post)
+	}
+	printUserPostsWithComments(*user)
 
 	// query the most commented article
-	var topPost Post
-	if err := db.Model(&Post{}).
-		Order("comment_count DESC").
-		First(&topPost).Error; err != nil {
+	top, err := blog.ListPosts(db, blog.PageRequest{Sort: "-comment_count", Limit: 1})
+	if err != nil || len(top.Items) == 0 {
 		log.Fatal("query failed:", err)
 	}
+	topPost := top.Items[0]
 	fmt.Printf("\nthe most commented article: %s (comment count: %d)\n", topPost.Title, topPost.CommentCount)
+
+	// query the most liked article
+	top, err = blog.ListPosts(db, blog.PageRequest{Sort: "-like_count", Limit: 1})
+	if err != nil || len(top.Items) == 0 {
+		log.Fatal("query failed:", err)
+	}
+	topPost = top.Items[0]
+	fmt.Printf("the most liked article: %s (like count: %d)\n", topPost.Title, topPost.LikeCount)
 }
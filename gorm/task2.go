@@ -1,84 +1,31 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"log"
+	"os"
+	"time"
 
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 
+	"github.com/test/init_project/bank"
 	"github.com/test/init_project/config"
 )
 
-// Account account model
-type Account struct {
-	ID      uint    `gorm:"primaryKey"`
-	Balance float64 `gorm:"type:decimal(10,2)"`
-}
-
-// Transaction transaction model
-type Transaction struct {
-	ID            uint `gorm:"primaryKey"`
-	FromAccountID uint
-	ToAccountID   uint
-	Amount        float64 `gorm:"type:decimal(10,2)"`
-}
-
-func transferMoney(db *gorm.DB, fromAccountID, toAccountID uint, amount float64) error {
-	return db.Transaction(func(tx *gorm.DB) error {
-		// query the balance of the from account (pessimistic lock)
-		var fromAccount Account
-		if err := tx.Model(&Account{}).Where("id = ?", fromAccountID).
-			Clauses(clause.Locking{Strength: "UPDATE"}).Find(&fromAccount).Error; err != nil {
-			return err
-		}
-
-		// check the balance
-		if fromAccount.Balance < amount {
-			return fmt.Errorf("balance not enough, current balance: %.2f", fromAccount.Balance)
-		}
-
-		// deduct the balance of the from account
-		if err := tx.Model(&Account{}).
-			Where("id = ? AND balance >= ?", fromAccountID, amount).
-			Update("balance", gorm.Expr("balance - ?", amount)).Error; err != nil {
-			return err
-		}
-
-		// increase the balance of the to account
-		if err := tx.Model(&Account{}).
-			Where("id = ?", toAccountID).
-			Update("balance", gorm.Expr("balance + ?", amount)).Error; err != nil {
-			return err
-		}
-
-		// record transaction
-		transaction := Transaction{
-			FromAccountID: fromAccountID,
-			ToAccountID:   toAccountID,
-			Amount:        amount,
-		}
-		if err := tx.Create(&transaction).Error; err != nil {
-			return err
-		}
-
-		return nil
-	})
-}
-
 // init test data (optional)
 func initTestData(db *gorm.DB) {
 	// check if there is data
 	var count int64
-	db.Model(&Account{}).Count(&count)
+	db.Model(&bank.Account{}).Count(&count)
 	if count > 0 {
 		return
 	}
 
 	// create test accounts
-	accounts := []Account{
+	accounts := []bank.Account{
 		{ID: 1, Balance: 1000}, // account A
 		{ID: 2, Balance: 500},  // account B
+		{ID: 3, Balance: 0},    // account C
 	}
 	db.Create(&accounts)
 	log.Println("init test accounts success")
@@ -89,7 +36,7 @@ func main() {
 	db := config.GetDB()
 
 	// auto migrate table structure
-	if err := db.AutoMigrate(&Account{}, &Transaction{}); err != nil {
+	if err := bank.AutoMigrate(db); err != nil {
 		log.Fatal("table structure migration failed:", err)
 	}
 
@@ -97,9 +44,41 @@ func main() {
 	initTestData(db)
 
 	// transfer money
-	err := transferMoney(db, 1, 2, 100.0)
-	if err != nil {
+	err := bank.TransferMoney(db, 1, 2, 100.0)
+	switch {
+	case err == nil:
+		log.Println("transfer money success")
+	case errors.Is(err, bank.ErrInsufficientFunds):
+		log.Fatal("transfer money rejected, balance not enough:", err)
+	case errors.Is(err, bank.ErrAccountNotFound), errors.Is(err, bank.ErrAccountFrozen):
+		log.Fatal("transfer money rejected, account unavailable:", err)
+	case errors.Is(err, bank.ErrRuleViolation):
+		log.Fatal("transfer money rejected by risk rule:", err)
+	default:
 		log.Fatal("transfer money failed:", err)
 	}
-	log.Println("transfer money success")
+
+	// pay two accounts from account A in one atomic batch
+	batch, err := bank.BatchTransfer(db, bank.BatchRequest{
+		Reference:     "payroll",
+		ExpectedTotal: 150,
+		Legs: []bank.TransferLeg{
+			{FromAccountID: 1, ToAccountID: 2, Amount: 100},
+			{FromAccountID: 1, ToAccountID: 3, Amount: 50},
+		},
+	})
+	if err != nil {
+		log.Fatal("batch transfer failed:", err)
+	}
+	log.Printf("batch transfer success, batch id: %d, legs: %d, total: %.2f", batch.ID, batch.LegCount, batch.TotalAmount)
+
+	// print the statement of account A for the last 24 hours
+	now := time.Now()
+	statement, err := bank.GetStatement(db, 1, now.Add(-24*time.Hour), now.Add(time.Second))
+	if err != nil {
+		log.Fatal("query statement failed:", err)
+	}
+	if err := statement.WriteCSV(os.Stdout); err != nil {
+		log.Fatal("export statement failed:", err)
+	}
 }
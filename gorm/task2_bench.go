@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/config"
+)
+
+// throughput benchmark of the two lock modes of bank.TransferMoney. Every transfer, in both
+// modes, chains its transaction under the single bank.LedgerHead row lock before commit, so the
+// commits are serialized either way and the modes only differ in how they wait for the account
+// rows before that: optimistic mode saves the row locks but pays with retries on conflicts.
+// Expect close numbers at every contention level, the ledger head is the bottleneck
+
+// benchmark settings, every scenario runs the same number of transfers
+const (
+	benchTransfers = 2000
+	benchWorkers   = 16
+)
+
+// benchScenario describes one contention level: fewer accounts means more transfers hit the same rows
+type benchScenario struct {
+	name     string
+	accounts int
+}
+
+var benchScenarios = []benchScenario{
+	{name: "high contention", accounts: 2},
+	{name: "medium contention", accounts: 10},
+	{name: "low contention", accounts: 200},
+}
+
+// benchResult is the outcome of one mode under one scenario
+type benchResult struct {
+	elapsed   time.Duration
+	succeeded int64
+	rejected  int64
+	failed    int64
+}
+
+func (r benchResult) throughput() float64 {
+	return float64(r.succeeded+r.rejected) / r.elapsed.Seconds()
+}
+
+// createBenchAccounts creates n accounts funded well enough that most transfers succeed
+func createBenchAccounts(db *gorm.DB, n int) ([]uint, error) {
+	accounts := make([]bank.Account, n)
+	for i := range accounts {
+		accounts[i].Balance = 100000
+	}
+	if err := db.Create(&accounts).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, n)
+	for i, account := range accounts {
+		ids[i] = account.ID
+	}
+	return ids, nil
+}
+
+// runBench performs benchTransfers random transfers between ids with the given lock mode
+func runBench(db *gorm.DB, ids []uint, mode bank.LockMode) benchResult {
+	var result benchResult
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	begin := time.Now()
+	for w := 0; w < benchWorkers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for range jobs {
+				from := ids[rnd.Intn(len(ids))]
+				to := ids[rnd.Intn(len(ids))]
+				for to == from {
+					to = ids[rnd.Intn(len(ids))]
+				}
+				amount := float64(rnd.Intn(1000)+1) / 100
+
+				err := bank.TransferMoney(db, from, to, amount, bank.WithLockMode(mode))
+				switch {
+				case err == nil:
+					atomic.AddInt64(&result.succeeded, 1)
+				case errors.Is(err, bank.ErrInsufficientFunds):
+					atomic.AddInt64(&result.rejected, 1)
+				default:
+					atomic.AddInt64(&result.failed, 1)
+				}
+			}
+		}(int64(w))
+	}
+	for i := 0; i < benchTransfers; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	result.elapsed = time.Since(begin)
+	return result
+}
+
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	modes := []struct {
+		name string
+		mode bank.LockMode
+	}{
+		{"pessimistic", bank.LockPessimistic},
+		{"optimistic", bank.LockOptimistic},
+	}
+
+	fmt.Printf("%d transfers, %d workers, commits serialized by the ledger head in both modes\n", benchTransfers, benchWorkers)
+	fmt.Printf("%-18s %-12s %10s %12s %8s\n", "scenario", "mode", "elapsed", "transfers/s", "failed")
+	for _, scenario := range benchScenarios {
+		for _, m := range modes {
+			// every run gets fresh accounts so earlier runs do not skew balances
+			ids, err := createBenchAccounts(db, scenario.accounts)
+			if err != nil {
+				log.Fatal("create benchmark accounts failed:", err)
+			}
+			result := runBench(db, ids, m.mode)
+			fmt.Printf("%-18s %-12s %10v %12.1f %8d\n", scenario.name, m.name,
+				result.elapsed.Round(time.Millisecond), result.throughput(), result.failed)
+		}
+	}
+}
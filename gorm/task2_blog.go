@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/test/init_project/blog"
+	"github.com/test/init_project/config"
+)
+
+// address of the blog API
+const blogAddr = "127.0.0.1:8080"
+
+// how often the like, reaction and view counters are written
+const counterFlushInterval = time.Second
+
+// how often scheduled posts are checked for publishing
+const publishInterval = 30 * time.Second
+
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	handler := blog.NewHandler(db)
+	flushed := make(chan struct{})
+	go func() {
+		// flushes once more after ctx is cancelled
+		handler.Engagement().Run(ctx, counterFlushInterval)
+		close(flushed)
+	}()
+	go blog.RunPublisher(ctx, db, publishInterval)
+
+	server := &http.Server{Addr: blogAddr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("blog API listening on http://%s", blogAddr)
+	log.Printf("try: curl -s -X POST http://%s/users -d '{\"name\":\"张三\",\"email\":\"zhangsan@example.com\",\"password\":\"123456\"}'", blogAddr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal("serve failed:", err)
+	}
+	<-flushed
+}
@@ -0,0 +1,496 @@
+package main
+
+// integration check of the blog API against a fresh SQLite database:
+//
+//	go run gorm/task2_blog_check.go
+//
+// every request is compared with the expected status code and body, the
+// program exits with status 1 when any check fails
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/test/init_project/blog"
+)
+
+type checker struct {
+	server *httptest.Server
+	failed int
+}
+
+type response struct {
+	status int
+	body   map[string]interface{}
+	raw    string
+}
+
+func (c *checker) do(method, path, body string) response {
+	return c.doAs("", method, path, body)
+}
+
+// doAs sends the request with a session token
+func (c *checker) doAs(token, method, path, body string) response {
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	r := response{status: resp.StatusCode, raw: string(bytes.TrimSpace(raw))}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &r.body); err != nil {
+			c.fail("%s %s: body is not a JSON object: %s", method, path, raw)
+		}
+	}
+	return r
+}
+
+// login returns the session token of a user
+func (c *checker) login(email, password string) string {
+	r := c.do("POST", "/login", fmt.Sprintf(`{"email":%q,"password":%q}`, email, password))
+	token, _ := r.body["token"].(string)
+	if r.status != http.StatusCreated || token == "" {
+		c.fail("login %s: status %d (%s)", email, r.status, r.raw)
+	}
+	return token
+}
+
+func (c *checker) fail(format string, args ...interface{}) {
+	c.failed++
+	fmt.Printf("FAIL "+format+"\n", args...)
+}
+
+// expect checks the status code, and for error statuses the error code of the body
+func (c *checker) expect(name string, r response, status int, errCode string) {
+	if r.status != status {
+		c.fail("%s: status %d, want %d (%s)", name, r.status, status, r.raw)
+		return
+	}
+	if errCode != "" {
+		detail, _ := r.body["error"].(map[string]interface{})
+		if detail == nil || detail["code"] != errCode || detail["message"] == "" {
+			c.fail("%s: error body %s, want code %q", name, r.raw, errCode)
+			return
+		}
+	}
+	fmt.Printf("ok   %s\n", name)
+}
+
+func (c *checker) field(name string, r response, key string, want interface{}) {
+	if got := r.body[key]; fmt.Sprint(got) != fmt.Sprint(want) {
+		c.fail("%s: %s = %v, want %v", name, key, got, want)
+	}
+}
+
+func (c *checker) length(name string, r response, want int) {
+	data, ok := r.body["data"].([]interface{})
+	if !ok || len(data) != want {
+		c.fail("%s: data %s, want %d items", name, r.raw, want)
+	}
+}
+
+func (c *checker) invalidFields(name string, r response, fields ...string) {
+	detail, _ := r.body["error"].(map[string]interface{})
+	got, _ := detail["fields"].(map[string]interface{})
+	for _, field := range fields {
+		if _, ok := got[field]; !ok {
+			c.fail("%s: field %s is not reported in %s", name, field, r.raw)
+		}
+	}
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "blog-check")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "blog.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatal("open database failed:", err)
+	}
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	c := &checker{server: httptest.NewServer(blog.NewHandler(db))}
+	defer c.server.Close()
+
+	// users
+	r := c.do("POST", "/users", `{"name":"张三","email":"zhangsan@example.com","password":"123456"}`)
+	c.expect("create user", r, http.StatusCreated, "")
+	c.field("create user", r, "id", 1)
+	if _, ok := r.body["password"]; ok {
+		c.fail("create user: password is exposed in %s", r.raw)
+	}
+	c.expect("create second user", c.do("POST", "/users", `{"name":"李四","email":"lisi@example.com","password":"654321"}`), http.StatusCreated, "")
+	t1 := c.login("zhangsan@example.com", "123456")
+	t2 := c.login("lisi@example.com", "654321")
+	c.expect("duplicate email", c.do("POST", "/users", `{"name":"王五","email":"zhangsan@example.com","password":"123456"}`), http.StatusConflict, "conflict")
+	r = c.do("POST", "/users", `{"name":" ","email":"nope","password":"1"}`)
+	c.expect("invalid user", r, http.StatusUnprocessableEntity, "invalid_input")
+	c.invalidFields("invalid user", r, "name", "email", "password")
+	c.expect("malformed JSON", c.do("POST", "/users", `{"name":`), http.StatusBadRequest, "bad_request")
+	c.expect("unknown field", c.do("POST", "/users", `{"name":"a","email":"a@example.com","password":"123456","admin":true}`), http.StatusBadRequest, "bad_request")
+	r = c.do("GET", "/users", "")
+	c.expect("list users", r, http.StatusOK, "")
+	c.length("list users", r, 2)
+	r = c.do("GET", "/users/1", "")
+	c.expect("get user", r, http.StatusOK, "")
+	if _, ok := r.body["email"]; ok {
+		c.fail("get user anonymously: email is exposed in %s", r.raw)
+	}
+	c.field("get own user", c.doAs(t1, "GET", "/users/1", ""), "email", "zhangsan@example.com")
+	if r = c.doAs(t2, "GET", "/users/1", ""); r.body["email"] != nil {
+		c.fail("get another user: email is exposed in %s", r.raw)
+	}
+	r = c.doAs(t1, "GET", "/users", "")
+	if data, _ := r.body["data"].([]interface{}); len(data) != 2 || data[0].(map[string]interface{})["email"] != "zhangsan@example.com" || data[1].(map[string]interface{})["email"] != nil {
+		c.fail("list users: emails of other users are exposed in %s", r.raw)
+	}
+	r = c.doAs(t1, "GET", "/users?sort=email", "")
+	c.expect("sort users by email", r, http.StatusUnprocessableEntity, "invalid_input")
+	c.invalidFields("sort users by email", r, "sort")
+	c.expect("missing user", c.do("GET", "/users/99", ""), http.StatusNotFound, "not_found")
+	c.expect("bad id", c.do("GET", "/users/abc", ""), http.StatusBadRequest, "bad_request")
+	r = c.doAs(t1, "PATCH", "/users/1", `{"name":"张三丰"}`)
+	c.expect("update user", r, http.StatusOK, "")
+	c.field("update user", r, "name", "张三丰")
+	c.field("update user", r, "email", "zhangsan@example.com")
+	c.expect("update email to a taken one", c.doAs(t1, "PATCH", "/users/1", `{"email":"lisi@example.com"}`), http.StatusConflict, "conflict")
+	c.expect("update user anonymously", c.do("PATCH", "/users/1", `{"name":"x"}`), http.StatusUnauthorized, "unauthorized")
+	c.expect("update another user", c.doAs(t2, "PATCH", "/users/1", `{"name":"x"}`), http.StatusForbidden, "forbidden")
+	c.expect("set own role", c.doAs(t2, "PATCH", "/users/2", `{"role":"admin"}`), http.StatusForbidden, "forbidden")
+
+	// posts
+	r = c.doAs(t1, "POST", "/users/1/posts", `{"title":"GORM 入门教程","content":"这是一篇关于GORM的入门教程..."}`)
+	c.expect("create post", r, http.StatusCreated, "")
+	c.field("create post", r, "user_id", 1)
+	c.field("create post", r, "comment_count", 0)
+	c.field("create post", r, "comment_status", blog.CommentStatusNone)
+	c.expect("create post anonymously", c.do("POST", "/users/1/posts", `{"title":"t","content":"c"}`), http.StatusUnauthorized, "unauthorized")
+	c.expect("create post for another user", c.doAs(t2, "POST", "/users/1/posts", `{"title":"t","content":"c"}`), http.StatusForbidden, "forbidden")
+	r = c.doAs(t1, "POST", "/users/1/posts", `{"title":"","content":""}`)
+	c.expect("invalid post", r, http.StatusUnprocessableEntity, "invalid_input")
+	c.invalidFields("invalid post", r, "title", "content")
+	c.expect("create second post", c.doAs(t2, "POST", "/users/2/posts", `{"title":"微服务架构","content":"讨论微服务设计模式和实现方法..."}`), http.StatusCreated, "")
+	r = c.do("GET", "/users/1/posts", "")
+	c.expect("list user posts", r, http.StatusOK, "")
+	c.length("list user posts", r, 1)
+	r = c.do("GET", "/posts", "")
+	c.expect("list posts", r, http.StatusOK, "")
+	c.length("list posts", r, 2)
+	r = c.do("GET", "/posts?limit=1&total=true&sort=id", "")
+	c.expect("first page", r, http.StatusOK, "")
+	c.length("first page", r, 1)
+	c.field("first page", r, "total", 2)
+	c.field("first page", r, "has_more", true)
+	next, _ := r.body["next_cursor"].(string)
+	r = c.do("GET", "/posts?limit=1&sort=id&cursor="+next, "")
+	c.expect("second page", r, http.StatusOK, "")
+	c.length("second page", r, 1)
+	c.field("second page", r, "has_more", false)
+	if _, ok := r.body["total"]; ok {
+		c.fail("second page: total without total=true: %s", r.raw)
+	}
+	c.expect("cursor of another sort", c.do("GET", "/posts?limit=1&sort=-id&cursor="+next, ""), http.StatusUnprocessableEntity, "invalid_input")
+	r = c.do("GET", "/posts?limit=abc&total=maybe", "")
+	c.expect("invalid page request", r, http.StatusUnprocessableEntity, "invalid_input")
+	c.invalidFields("invalid page request", r, "limit", "total")
+	r = c.do("GET", "/posts?sort=password", "")
+	c.expect("invalid sort", r, http.StatusUnprocessableEntity, "invalid_input")
+	c.invalidFields("invalid sort", r, "sort")
+	c.expect("update post of another author", c.doAs(t2, "PATCH", "/posts/1", `{"title":"x"}`), http.StatusForbidden, "forbidden")
+	c.expect("delete post of another author", c.doAs(t2, "DELETE", "/posts/1", ""), http.StatusForbidden, "forbidden")
+	r = c.doAs(t1, "PATCH", "/posts/1", `{"title":"GORM 进阶教程"}`)
+	c.expect("update post", r, http.StatusOK, "")
+	c.field("update post", r, "title", "GORM 进阶教程")
+	c.field("update post", r, "content", "这是一篇关于GORM的入门教程...")
+
+	// comments
+	r = c.doAs(t2, "POST", "/posts/1/comments", `{"content":"非常实用的教程！"}`)
+	c.expect("create comment", r, http.StatusCreated, "")
+	c.field("create comment", r, "post_id", 1)
+	c.field("create comment", r, "user_id", 2)
+	// comments of other users wait for the author of the post
+	c.field("create comment", r, "state", blog.CommentPending)
+	c.expect("pending comment is hidden", c.do("GET", "/comments/1", ""), http.StatusNotFound, "not_found")
+	c.expect("pending comment is shown to its author", c.doAs(t2, "GET", "/comments/1", ""), http.StatusOK, "")
+	r = c.do("GET", "/posts/1", "")
+	c.field("pending comments are not counted", r, "comment_count", 0)
+	r = c.doAs(t1, "GET", "/moderation/comments", "")
+	c.expect("moderation queue", r, http.StatusOK, "")
+	c.length("moderation queue", r, 1)
+	c.expect("moderate a comment on another post", c.doAs(t2, "POST", "/comments/1/moderation", `{"state":"approved"}`), http.StatusForbidden, "forbidden")
+	r = c.doAs(t1, "POST", "/comments/1/moderation", `{"state":"published"}`)
+	c.expect("invalid moderation state", r, http.StatusUnprocessableEntity, "invalid_input")
+	c.invalidFields("invalid moderation state", r, "state")
+	r = c.doAs(t1, "POST", "/comments/1/moderation", `{"state":"approved"}`)
+	c.expect("approve comment", r, http.StatusOK, "")
+	c.field("approve comment", r, "state", blog.CommentApproved)
+	r = c.doAs(t1, "POST", "/posts/1/comments", `{"user_id":1,"content":"期待后续更新！"}`)
+	c.expect("create second comment", r, http.StatusCreated, "")
+	c.field("comment of the author of the post", r, "state", blog.CommentApproved)
+	r = c.do("GET", "/posts/1", "")
+	c.field("comment counter", r, "comment_count", 2)
+	c.field("comment counter", r, "comment_status", blog.CommentStatusHas)
+	r = c.doAs(t1, "POST", "/posts/1/comments", `{"parent_id":1,"content":"谢谢！"}`)
+	c.expect("reply", r, http.StatusCreated, "")
+	c.field("reply", r, "parent_id", 1)
+	c.field("reply", r, "depth", 1)
+	r = c.doAs(t1, "POST", "/posts/1/comments", `{"parent_id":99,"content":"?"}`)
+	c.expect("reply to a missing comment", r, http.StatusUnprocessableEntity, "invalid_input")
+	c.invalidFields("reply to a missing comment", r, "parent_id")
+	r = c.do("GET", "/posts/1/thread", "")
+	c.expect("post thread", r, http.StatusOK, "")
+	c.length("post thread", r, 2)
+	r = c.do("GET", "/comments/1/thread", "")
+	c.expect("comment thread", r, http.StatusOK, "")
+	if replies, _ := r.body["replies"].([]interface{}); len(replies) != 1 {
+		c.fail("comment thread: replies of %s, want 1", r.raw)
+	}
+	c.expect("comment on missing post", c.doAs(t1, "POST", "/posts/99/comments", `{"content":"?"}`), http.StatusNotFound, "not_found")
+	c.expect("comment as another user", c.doAs(t1, "POST", "/posts/1/comments", `{"user_id":2,"content":"?"}`), http.StatusForbidden, "forbidden")
+	r = c.doAs(t1, "POST", "/posts/1/comments", `{"content":" "}`)
+	c.expect("invalid comment", r, http.StatusUnprocessableEntity, "invalid_input")
+	c.invalidFields("invalid comment", r, "content")
+	r = c.do("GET", "/posts/1/comments", "")
+	c.expect("list post comments", r, http.StatusOK, "")
+	c.length("list post comments", r, 3)
+	c.expect("update comment of another user", c.doAs(t1, "PATCH", "/comments/1", `{"content":"x"}`), http.StatusForbidden, "forbidden")
+	r = c.doAs(t2, "PATCH", "/comments/1", `{"content":"非常实用！"}`)
+	c.expect("update comment", r, http.StatusOK, "")
+	c.field("update comment", r, "content", "非常实用！")
+	c.expect("delete comment", c.doAs(t2, "DELETE", "/comments/1", ""), http.StatusNoContent, "")
+	c.expect("reply goes with its comment", c.do("GET", "/comments/3", ""), http.StatusNotFound, "not_found")
+	c.expect("delete comment again", c.doAs(t2, "DELETE", "/comments/1", ""), http.StatusNotFound, "not_found")
+	c.expect("delete second comment", c.doAs(t1, "DELETE", "/comments/2", ""), http.StatusNoContent, "")
+	r = c.do("GET", "/posts/1", "")
+	c.field("comment counter after delete", r, "comment_count", 0)
+	c.field("comment counter after delete", r, "comment_status", blog.CommentStatusNone)
+
+	// search
+	r = c.do("GET", "/search?q=GORM&kind=post", "")
+	c.expect("search", r, http.StatusOK, "")
+	c.length("search", r, 1)
+	c.field("search", r, "total", 1)
+	if !strings.Contains(r.raw, `\u003cmark\u003eGORM\u003c/mark\u003e`) {
+		c.fail("search: no highlighted match in %s", r.raw)
+	}
+	r = c.do("GET", "/search?q=&kind=user", "")
+	c.expect("invalid search", r, http.StatusUnprocessableEntity, "invalid_input")
+	c.invalidFields("invalid search", r, "q", "kind")
+
+	// passwords and sessions
+	var stored blog.User
+	db.First(&stored, 1)
+	if !strings.HasPrefix(stored.Password, "$2") || stored.CheckPassword("wrong") || !stored.CheckPassword("123456") {
+		c.fail("stored password is not a bcrypt hash of the password: %q", stored.Password)
+	}
+	if raw, _ := json.Marshal(stored); strings.Contains(string(raw), stored.Password) {
+		c.fail("marshalled user contains the password hash: %s", raw)
+	}
+	db.Model(&stored).Update("password", "updated-by-map")
+	db.First(&stored, 1)
+	if !stored.CheckPassword("updated-by-map") || stored.Password == "updated-by-map" {
+		c.fail("Update(\"password\") stored %q", stored.Password)
+	}
+	// a password that looks like a bcrypt hash is still a password
+	shaped, _ := blog.HashPassword("something else")
+	if _, err := blog.UpdateUser(db, &stored, 1, blog.UserPatch{Password: &shaped}); err != nil {
+		c.fail("update to a bcrypt shaped password: %v", err)
+	}
+	db.First(&stored, 1)
+	if stored.Password == shaped || !stored.CheckPassword(shaped) || stored.CheckPassword("something else") {
+		c.fail("bcrypt shaped password was stored as is: %q", stored.Password)
+	}
+	db.Model(&stored).Update("password", shaped)
+	db.First(&stored, 1)
+	if stored.Password == shaped || !stored.CheckPassword(shaped) {
+		c.fail("Update(\"password\") stored the bcrypt shaped password as is: %q", stored.Password)
+	}
+	db.Save(&stored)
+	db.First(&stored, 1)
+	if !stored.CheckPassword(shaped) {
+		c.fail("Save hashed the stored hash again: %q", stored.Password)
+	}
+	db.Model(&stored).Update("password", "123456")
+	c.expect("login with wrong password", c.do("POST", "/login", `{"email":"zhangsan@example.com","password":"12345x"}`), http.StatusUnauthorized, "unauthorized")
+	c.expect("login with unknown email", c.do("POST", "/login", `{"email":"nobody@example.com","password":"123456"}`), http.StatusUnauthorized, "unauthorized")
+	r = c.do("POST", "/login", `{"email":"zhangsan@example.com","password":"123456"}`)
+	c.expect("login", r, http.StatusCreated, "")
+	token, _ := r.body["token"].(string)
+	r = c.doAs(token, "GET", "/me", "")
+	c.expect("me", r, http.StatusOK, "")
+	c.field("me", r, "id", 1)
+	c.expect("me without token", c.do("GET", "/me", ""), http.StatusUnauthorized, "unauthorized")
+	c.expect("me with bad token", c.doAs("nope", "GET", "/me", ""), http.StatusUnauthorized, "unauthorized")
+	c.expect("logout", c.doAs(token, "POST", "/logout", ""), http.StatusNoContent, "")
+	c.expect("me after logout", c.doAs(token, "GET", "/me", ""), http.StatusUnauthorized, "unauthorized")
+	c.expect("logout again", c.doAs(token, "POST", "/logout", ""), http.StatusUnauthorized, "unauthorized")
+
+	r = c.do("POST", "/login", `{"email":"zhangsan@example.com","password":"123456"}`)
+	token, _ = r.body["token"].(string)
+	c.expect("change password", c.doAs(token, "PATCH", "/users/1", `{"password":"new-secret"}`), http.StatusOK, "")
+	c.expect("me after password change", c.doAs(token, "GET", "/me", ""), http.StatusUnauthorized, "unauthorized")
+	c.expect("login with old password", c.do("POST", "/login", `{"email":"zhangsan@example.com","password":"123456"}`), http.StatusUnauthorized, "unauthorized")
+	r = c.do("POST", "/login", `{"email":"zhangsan@example.com","password":"new-secret"}`)
+	c.expect("login with new password", r, http.StatusCreated, "")
+	token, _ = r.body["token"].(string)
+	db.Model(&blog.Session{}).Where("user_id = ?", 1).Update("expires_at", time.Now().Add(-time.Minute))
+	c.expect("me with expired session", c.doAs(token, "GET", "/me", ""), http.StatusUnauthorized, "unauthorized")
+
+	// a row written before hashing is upgraded by the first successful login
+	db.Exec("UPDATE users SET password = ? WHERE id = 1", "legacy-plain")
+	c.expect("login with legacy password", c.do("POST", "/login", `{"email":"zhangsan@example.com","password":"legacy-plain"}`), http.StatusCreated, "")
+	db.First(&stored, 1)
+	if !strings.HasPrefix(stored.Password, "$2") {
+		c.fail("legacy password was not rehashed: %q", stored.Password)
+	}
+
+	// roles
+	db.Model(&blog.User{}).Where("id = ?", 1).Update("role", blog.RoleAdmin)
+	admin := c.login("zhangsan@example.com", "legacy-plain")
+	r = c.doAs(admin, "PATCH", "/users/2", `{"role":"reader"}`)
+	c.expect("admin demotes a user", r, http.StatusOK, "")
+	c.field("admin demotes a user", r, "role", blog.RoleReader)
+	c.field("admin sees every email", r, "email", "lisi@example.com")
+	r = c.doAs(admin, "GET", "/users?sort=-email", "")
+	c.expect("admin sorts users by email", r, http.StatusOK, "")
+	if data, _ := r.body["data"].([]interface{}); len(data) != 2 || data[0].(map[string]interface{})["email"] != "zhangsan@example.com" {
+		c.fail("admin sorts users by email: %s", r.raw)
+	}
+	c.expect("reader writes a post", c.doAs(t2, "POST", "/users/2/posts", `{"title":"t","content":"c"}`), http.StatusForbidden, "forbidden")
+	c.expect("reader comments", c.doAs(t2, "POST", "/posts/1/comments", `{"content":"读者评论"}`), http.StatusCreated, "")
+	c.expect("reader edits an own post", c.doAs(t2, "PATCH", "/posts/2", `{"content":"更新"}`), http.StatusOK, "")
+	c.expect("invalid role", c.doAs(admin, "PATCH", "/users/2", `{"role":"root"}`), http.StatusUnprocessableEntity, "invalid_input")
+	c.expect("admin edits any post", c.doAs(admin, "PATCH", "/posts/2", `{"title":"管理员修改"}`), http.StatusOK, "")
+
+	// tags and categories
+	r = c.doAs(admin, "POST", "/posts/1/tags", `{"tags":["Go","GORM"]}`)
+	c.expect("tag a post", r, http.StatusOK, "")
+	c.length("tag a post", r, 2)
+	c.expect("tag a post of another user", c.doAs(t2, "POST", "/posts/1/tags", `{"tags":["x"]}`), http.StatusForbidden, "forbidden")
+	r = c.do("GET", "/tags", "")
+	c.expect("list tags", r, http.StatusOK, "")
+	c.length("list tags", r, 2)
+	r = c.do("GET", "/tags/gorm/posts", "")
+	c.expect("posts by tag", r, http.StatusOK, "")
+	c.length("posts by tag", r, 1)
+	c.expect("untag a post", c.doAs(admin, "DELETE", "/posts/1/tags/gorm", ""), http.StatusNoContent, "")
+	c.length("untag a post", c.do("GET", "/posts/1/tags", ""), 1)
+	c.expect("category as an author", c.doAs(t2, "POST", "/categories", `{"name":"编程"}`), http.StatusForbidden, "forbidden")
+	r = c.doAs(admin, "POST", "/categories", `{"name":"编程"}`)
+	c.expect("create category", r, http.StatusCreated, "")
+	c.field("create category", r, "slug", "编程")
+	r = c.doAs(admin, "POST", "/categories", `{"name":"Go","parent_id":1}`)
+	c.expect("create subcategory", r, http.StatusCreated, "")
+	c.field("create subcategory", r, "depth", 1)
+	c.expect("duplicate category", c.doAs(admin, "POST", "/categories", `{"name":"go"}`), http.StatusConflict, "conflict")
+	r = c.do("GET", "/categories", "")
+	c.expect("category tree", r, http.StatusOK, "")
+	c.length("category tree", r, 1)
+	c.expect("file a post", c.doAs(admin, "POST", "/posts/1/categories", `{"category_ids":[2]}`), http.StatusOK, "")
+	r = c.do("GET", "/categories/1/posts", "")
+	c.expect("posts in a category subtree", r, http.StatusOK, "")
+	c.length("posts in a category subtree", r, 1)
+	c.expect("unfile a post", c.doAs(admin, "DELETE", "/posts/1/categories/2", ""), http.StatusNoContent, "")
+	c.length("unfile a post", c.do("GET", "/categories/1/posts", ""), 0)
+	c.expect("bad category id", c.doAs(admin, "DELETE", "/posts/1/categories/x", ""), http.StatusBadRequest, "bad_request")
+
+	// likes and reactions
+	r = c.doAs(admin, "POST", "/posts/1/like", "")
+	c.expect("like", r, http.StatusOK, "")
+	c.field("like", r, "likes", 1)
+	c.field("like", r, "changed", true)
+	r = c.doAs(admin, "POST", "/posts/1/like", "")
+	c.field("like twice", r, "likes", 1)
+	c.field("like twice", r, "changed", false)
+	c.expect("anonymous like", c.do("POST", "/posts/1/like", ""), http.StatusUnauthorized, "unauthorized")
+	r = c.doAs(admin, "POST", "/posts/1/reactions", `{"emoji":"🎉"}`)
+	c.expect("react", r, http.StatusOK, "")
+	c.field("react", r, "reactions", map[string]interface{}{"🎉": 1})
+	r = c.doAs(admin, "POST", "/posts/1/reactions", `{"emoji":"x"}`)
+	c.expect("unknown reaction", r, http.StatusUnprocessableEntity, "invalid_input")
+	c.invalidFields("unknown reaction", r, "emoji")
+	c.field("unreact", c.doAs(admin, "DELETE", "/posts/1/reactions/🎉", ""), "changed", true)
+	c.expect("like a missing comment", c.doAs(admin, "POST", "/comments/99/like", ""), http.StatusNotFound, "not_found")
+	r = c.do("GET", "/posts/1/stats", "")
+	c.expect("stats", r, http.StatusOK, "")
+	c.field("stats", r, "likes", 1)
+	if err := c.server.Config.Handler.(*blog.Handler).Engagement().Flush(); err != nil {
+		c.fail("flush counters: %v", err)
+	}
+	c.field("like count of a post", c.do("GET", "/posts/1", ""), "like_count", 1)
+
+	// drafts and revisions
+	r = c.doAs(admin, "POST", "/users/1/posts", `{"title":"草稿","content":"v1","status":"draft"}`)
+	c.expect("create draft", r, http.StatusCreated, "")
+	c.field("create draft", r, "status", blog.PostDraft)
+	c.field("create draft", r, "publish_at", nil)
+	draft := fmt.Sprintf("/posts/%v", r.body["id"])
+	c.expect("draft for anonymous callers", c.do("GET", draft, ""), http.StatusNotFound, "not_found")
+	c.expect("own draft", c.doAs(admin, "GET", draft, ""), http.StatusOK, "")
+	c.length("drafts are not listed", c.do("GET", "/users/1/posts", ""), 1)
+	c.length("list drafts", c.doAs(admin, "GET", "/users/1/posts?status=draft", ""), 1)
+	c.expect("list drafts anonymously", c.do("GET", "/users/1/posts?status=draft", ""), http.StatusUnauthorized, "unauthorized")
+	c.invalidFields("unknown status", c.doAs(admin, "GET", "/users/1/posts?status=secret", ""), "status")
+	c.expect("comment on a draft", c.doAs(admin, "POST", draft+"/comments", `{"content":"c"}`), http.StatusConflict, "conflict")
+	c.expect("edit draft", c.doAs(admin, "PATCH", draft, `{"content":"v2"}`), http.StatusOK, "")
+	r = c.doAs(admin, "GET", draft+"/revisions", "")
+	c.expect("revisions", r, http.StatusOK, "")
+	c.length("revisions", r, 2)
+	r = c.doAs(admin, "GET", draft+"/revisions/2", "")
+	c.field("revision", r, "number", 2)
+	c.field("revision", r, "diff", "--- revision 1\n+++ revision 2\n@@ -1,3 +1,3 @@\n 草稿\n \n-v1\n+v2\n")
+	c.expect("missing revision", c.doAs(admin, "GET", draft+"/revisions/9", ""), http.StatusNotFound, "not_found")
+	c.expect("bad revision number", c.doAs(admin, "GET", draft+"/revisions/x", ""), http.StatusBadRequest, "bad_request")
+	r = c.doAs(admin, "POST", draft+"/revisions/1/restore", "")
+	c.expect("restore revision", r, http.StatusOK, "")
+	c.field("restore revision", r, "content", "v1")
+	c.field("restored revision", c.doAs(admin, "GET", draft+"/revisions/3", ""), "restored_from", 1)
+	c.invalidFields("schedule in the past", c.doAs(admin, "PATCH", draft, `{"status":"scheduled","publish_at":"2000-01-01T00:00:00Z"}`), "publish_at")
+	r = c.doAs(admin, "PATCH", draft, `{"status":"published"}`)
+	c.expect("publish draft", r, http.StatusOK, "")
+	c.field("publish draft", r, "status", blog.PostPublished)
+	c.expect("published draft", c.do("GET", draft, ""), http.StatusOK, "")
+	c.expect("history of another user", c.doAs(t2, "GET", draft+"/revisions", ""), http.StatusForbidden, "forbidden")
+
+	// deletes and routing
+	c.expect("delete post", c.doAs(t2, "DELETE", "/posts/2", ""), http.StatusNoContent, "")
+	c.expect("get deleted post", c.do("GET", "/posts/2", ""), http.StatusNotFound, "not_found")
+	c.expect("delete user", c.doAs(t2, "DELETE", "/users/2", ""), http.StatusNoContent, "")
+	c.expect("session of deleted user", c.doAs(t2, "GET", "/me", ""), http.StatusUnauthorized, "unauthorized")
+	c.expect("delete user again", c.doAs(admin, "DELETE", "/users/2", ""), http.StatusNotFound, "not_found")
+	c.expect("unknown route", c.do("GET", "/nothing", ""), http.StatusNotFound, "not_found")
+	r = c.do("PUT", "/users/1", `{}`)
+	c.expect("wrong method", r, http.StatusMethodNotAllowed, "method_not_allowed")
+
+	if c.failed > 0 {
+		fmt.Printf("%d checks failed\n", c.failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
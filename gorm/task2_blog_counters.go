@@ -0,0 +1,214 @@
+package main
+
+// check of the comment counters of posts against SQLite:
+//
+//	go run gorm/task2_blog_counters.go
+//
+// exits with status 1 on any failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/test/init_project/blog"
+)
+
+var failed int
+
+func check(name string, ok bool, format string, args ...interface{}) {
+	if !ok {
+		failed++
+		fmt.Printf("FAIL %s: "+format+"\n", append([]interface{}{name}, args...)...)
+		return
+	}
+	fmt.Printf("ok   %s\n", name)
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "blog-counters")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "blog.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatal("open database failed:", err)
+	}
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	// the seed data of the old insertTestData: hand written counts on top of
+	// the counts of the comment hooks
+	user := blog.User{Name: "张三", Email: "zhangsan@example.com", Password: "123456"}
+	db.Create(&user)
+	posts := []blog.Post{
+		{Title: "GORM 入门教程", Content: "...", UserID: user.ID, CommentCount: 2},
+		{Title: "Go语言实战", Content: "...", UserID: user.ID, CommentCount: 1},
+		{Title: "微服务架构", Content: "...", UserID: user.ID, CommentCount: 3},
+		{Title: "空文章", Content: "...", UserID: user.ID},
+	}
+	for i := range posts {
+		db.Create(&posts[i])
+	}
+	for _, postID := range []uint{1, 1, 2, 3, 3, 3} {
+		db.Create(&blog.Comment{Content: "评论", UserID: user.ID, PostID: postID})
+	}
+	state := func(postID uint) (int, string) {
+		var post blog.Post
+		if err := db.Select("comment_count", "comment_status").First(&post, postID).Error; err != nil {
+			log.Fatal(err)
+		}
+		return post.CommentCount, post.CommentStatus
+	}
+	expectCount := func(name string, postID uint, want int) {
+		count, status := state(postID)
+		wantStatus := blog.CommentStatusNone
+		if want > 0 {
+			wantStatus = blog.CommentStatusHas
+		}
+		check(name, count == want && status == wantStatus, "post %d: %d %s, want %d %s", postID, count, status, want, wantStatus)
+	}
+	reconcile := func(fix bool) *blog.CounterReport {
+		report, err := blog.ReconcileCommentCounts(db, fix)
+		if err != nil {
+			log.Fatal("reconcile failed:", err)
+		}
+		return report
+	}
+
+	count, status := state(4)
+	check("new post without comments", count == 0 && status == blog.CommentStatusNone, "%d %s", count, status)
+
+	report := reconcile(false)
+	check("drift is reported", report.Checked == 4 && len(report.Drifts) == 3, "%d checked, drifts %+v", report.Checked, report.Drifts)
+	if len(report.Drifts) > 0 {
+		d := report.Drifts[0]
+		check("drift of the first post", d.PostID == 1 && d.StoredCount == 4 && d.ActualCount == 2, "%+v", d)
+	}
+	count, _ = state(1)
+	check("report only", count == 4, "comment_count %d", count)
+	report = reconcile(true)
+	check("drift is fixed", len(report.Drifts) == 3 && report.Fixed, "%+v", report)
+	expectCount("fixed count", 1, 2)
+	expectCount("fixed count", 3, 3)
+	check("no drift after the fix", len(reconcile(false).Drifts) == 0, "%+v", reconcile(false).Drifts)
+
+	// deleting a comment twice, or hard deleting it after a soft delete,
+	// takes it off once
+	var comment blog.Comment
+	db.Where("post_id = ?", 1).First(&comment)
+	db.Delete(&comment)
+	db.Delete(&comment)
+	expectCount("delete twice", 1, 1)
+	db.Unscoped().Delete(&comment)
+	expectCount("hard delete after soft delete", 1, 1)
+	db.Delete(&blog.Comment{Model: gorm.Model{ID: comment.ID + 1}})
+	expectCount("delete by primary key only", 1, 0)
+
+	// batch creates count every comment
+	batch := []blog.Comment{
+		{Content: "批量一", UserID: user.ID, PostID: 2},
+		{Content: "批量二", UserID: user.ID, PostID: 2},
+		{Content: "批量三", UserID: user.ID, PostID: 4},
+		{Content: "待审核", UserID: user.ID, PostID: 4, State: blog.CommentPending},
+	}
+	if err := db.Create(&batch).Error; err != nil {
+		log.Fatal(err)
+	}
+	expectCount("batch create", 2, 3)
+	expectCount("batch create, pending not counted", 4, 1)
+	more := make([]blog.Comment, 25)
+	for i := range more {
+		more[i] = blog.Comment{Content: fmt.Sprintf("分批 %d", i), UserID: user.ID, PostID: 4}
+	}
+	if err := db.CreateInBatches(&more, 10).Error; err != nil {
+		log.Fatal(err)
+	}
+	expectCount("create in batches", 4, 26)
+
+	// deletes by condition recount the posts they touch
+	if err := db.Where("post_id = ? AND content LIKE ?", 4, "分批%").Delete(&blog.Comment{}).Error; err != nil {
+		log.Fatal(err)
+	}
+	expectCount("delete by condition", 4, 1)
+	if err := db.Delete(&blog.Comment{}, batch[0].ID).Error; err != nil {
+		log.Fatal(err)
+	}
+	expectCount("delete by inline condition", 2, 2)
+	if err := db.Delete(&batch).Error; err != nil {
+		log.Fatal(err)
+	}
+	expectCount("delete a slice", 2, 1)
+	expectCount("delete a slice", 4, 0)
+
+	// a slice delete recounts the posts of its comments, so deleting it
+	// again, or with a comment deleted before, takes nothing off twice
+	slice := []blog.Comment{
+		{Content: "切片一", UserID: user.ID, PostID: 1},
+		{Content: "切片二", UserID: user.ID, PostID: 1},
+		{Content: "切片三", UserID: user.ID, PostID: 4},
+		{Content: "切片四", UserID: user.ID, PostID: 4},
+	}
+	if err := db.Create(&slice).Error; err != nil {
+		log.Fatal(err)
+	}
+	expectCount("slice created", 1, 2)
+	expectCount("slice created", 4, 2)
+	db.Delete(&slice[3])
+	expectCount("slice element deleted first", 4, 1)
+	if err := db.Delete(slice[1:]).Error; err != nil {
+		log.Fatal(err)
+	}
+	expectCount("delete a slice across posts", 1, 1)
+	expectCount("delete a slice across posts", 4, 0)
+	if err := db.Delete(slice[1:]).Error; err != nil {
+		log.Fatal(err)
+	}
+	expectCount("delete a slice twice", 1, 1)
+	expectCount("delete a slice twice", 4, 0)
+	if err := db.Unscoped().Delete(slice).Error; err != nil {
+		log.Fatal(err)
+	}
+	expectCount("hard delete a slice after soft delete", 1, 0)
+	expectCount("hard delete a slice after soft delete", 4, 0)
+	result, err := blog.NewSearch(db).Search("切片", blog.SearchOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("deleted slice leaves search", result.Total == 0, "%d hits", result.Total)
+	result, err = blog.NewSearch(db).Search("分批", blog.SearchOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("deleted comments leave search", result.Total == 0, "%d hits", result.Total)
+
+	// statements that skip the hooks are caught by the reconciler
+	db.Exec("DELETE FROM comments WHERE post_id = ?", 3)
+	db.Exec("UPDATE posts SET comment_count = 7 WHERE id = ?", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- blog.RunCounterReconciler(ctx, db, 10*time.Millisecond) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		check("reconciler stops", false, "error %v", err)
+	}
+	expectCount("reconciler", 3, 0)
+	expectCount("reconciler", 2, 1)
+
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
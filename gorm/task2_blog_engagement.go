@@ -0,0 +1,237 @@
+package main
+
+// check of likes, reactions, views and their write-behind counters against SQLite:
+//
+//	go run gorm/task2_blog_engagement.go
+//
+// exits with status 1 on any failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/test/init_project/blog"
+)
+
+var failed int
+
+func check(name string, ok bool, format string, args ...interface{}) {
+	if !ok {
+		failed++
+		fmt.Printf("FAIL %s: "+format+"\n", append([]interface{}{name}, args...)...)
+		return
+	}
+	fmt.Printf("ok   %s\n", name)
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "blog-engagement")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "blog.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatal("open database failed:", err)
+	}
+	// SQLite takes one writer at a time
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	var users []*blog.User
+	for i := 0; i < 5; i++ {
+		user, err := blog.CreateUser(db, blog.UserInput{Name: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i), Password: "123456"})
+		if err != nil {
+			log.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	for _, title := range []string{"GORM 入门教程", "Go语言实战", "微服务架构"} {
+		if _, err := blog.CreatePost(db, users[0], users[0].ID, blog.PostInput{Title: title, Content: "..."}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	comment, err := blog.CreateComment(db, users[0], 1, blog.CommentInput{Content: "作者的评论"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	pending, err := blog.CreateComment(db, users[1], 1, blog.CommentInput{Content: "待审核的评论"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	e := blog.NewEngagement(db)
+	stored := func(postID uint) (int64, int64) {
+		var post blog.Post
+		db.Select("like_count", "view_count").First(&post, postID)
+		return post.LikeCount, post.ViewCount
+	}
+	stats := func(viewer *blog.User, target string, id uint) *blog.Stats {
+		s, err := e.Stats(viewer, target, id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return s
+	}
+
+	// one like per user, however often it is sent, also concurrently
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	liked := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			changed, err := e.Like(users[1], blog.TargetPost, 1)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if changed {
+				mu.Lock()
+				liked++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	check("concurrent likes of one user", liked == 1, "%d likes took effect", liked)
+	for _, user := range users[2:] {
+		if _, err := e.Like(user, blog.TargetPost, 1); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, err := e.Like(users[2], blog.TargetPost, 2); err != nil {
+		log.Fatal(err)
+	}
+	likes, _ := stored(1)
+	check("likes are written behind", likes == 0, "like_count %d before the flush", likes)
+	check("stats include unflushed likes", stats(nil, blog.TargetPost, 1).Likes == 4, "%+v", stats(nil, blog.TargetPost, 1))
+
+	// views are buffered in memory only
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.View(1)
+		}()
+	}
+	wg.Wait()
+	check("views are buffered", e.Counters().Pending() == 3, "%d counters pending", e.Counters().Pending())
+	if err := e.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	likes, views := stored(1)
+	check("flush", likes == 4 && views == 1000, "like_count %d view_count %d", likes, views)
+	check("nothing pending after the flush", e.Counters().Pending() == 0, "%d pending", e.Counters().Pending())
+	check("stats after the flush", stats(nil, blog.TargetPost, 1).Likes == 4, "%+v", stats(nil, blog.TargetPost, 1))
+
+	changed, err := e.Unlike(users[4], blog.TargetPost, 1)
+	check("unlike", err == nil && changed, "%v %v", changed, err)
+	changed, err = e.Unlike(users[4], blog.TargetPost, 1)
+	check("unlike twice", err == nil && !changed, "%v %v", changed, err)
+	check("unlike is counted", stats(nil, blog.TargetPost, 1).Likes == 3, "%+v", stats(nil, blog.TargetPost, 1))
+
+	_, err = e.Like(nil, blog.TargetPost, 1)
+	check("anonymous like", errors.Is(err, blog.ErrUnauthenticated), "error %v", err)
+	_, err = e.Like(users[1], blog.TargetPost, 99)
+	check("like a missing post", errors.Is(err, blog.ErrNotFound), "error %v", err)
+	_, err = e.Like(users[2], blog.TargetComment, pending.ID)
+	check("like a comment waiting for moderation", errors.Is(err, blog.ErrNotFound), "error %v", err)
+	if _, err := e.Like(users[2], blog.TargetComment, comment.ID); err != nil {
+		log.Fatal(err)
+	}
+
+	// reactions, one per user and emoji
+	for _, r := range []struct {
+		user  int
+		emoji string
+	}{{1, "👍"}, {1, "👍"}, {1, "❤️"}, {2, "👍"}, {3, "🎉"}} {
+		if _, err := e.React(users[r.user], blog.TargetPost, 1, r.emoji); err != nil {
+			log.Fatal(err)
+		}
+	}
+	_, err = e.React(users[1], blog.TargetPost, 1, "💩")
+	var invalid *blog.ValidationError
+	check("unknown reaction", errors.As(err, &invalid), "error %v", err)
+	if _, err := e.Unreact(users[3], blog.TargetPost, 1, "🎉"); err != nil {
+		log.Fatal(err)
+	}
+	want := map[string]int64{"👍": 2, "❤️": 1}
+	check("reactions before the flush", reflect.DeepEqual(stats(nil, blog.TargetPost, 1).Reactions, want), "%v", stats(nil, blog.TargetPost, 1).Reactions)
+
+	// a failed flush keeps the increments for the next one
+	db.Migrator().DropTable(&blog.ReactionCount{})
+	if err := e.Flush(); err == nil {
+		check("failed flush", false, "no error without the reaction_counts table")
+	}
+	check("failed flush keeps the increments", e.Counters().Pending() > 0, "%d pending", e.Counters().Pending())
+	likes, _ = stored(1)
+	check("failed flush is rolled back", likes == 4, "like_count %d", likes)
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := e.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	likes, _ = stored(1)
+	check("flush after the failure", likes == 3 && reflect.DeepEqual(stats(nil, blog.TargetPost, 1).Reactions, want), "like_count %d reactions %v", likes, stats(nil, blog.TargetPost, 1).Reactions)
+	check("comment likes", stats(users[0], blog.TargetComment, comment.ID).Likes == 1, "%+v", stats(users[0], blog.TargetComment, comment.ID))
+
+	// Run flushes on every tick and once more when it stops
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.Run(ctx, time.Hour) }()
+	for _, user := range users[1:] {
+		if _, err := e.Like(user, blog.TargetPost, 3); err != nil {
+			log.Fatal(err)
+		}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		check("run stops", false, "error %v", err)
+	}
+	likes, _ = stored(3)
+	check("final flush", likes == 4, "like_count %d", likes)
+
+	// the most liked post, like the most commented one
+	top, err := blog.ListPosts(db, blog.PageRequest{Sort: "-like_count", Limit: 2})
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("most liked", len(top.Items) == 2 && top.Items[0].ID == 3 && top.Items[1].ID == 1, "%d, %d", top.Items[0].ID, top.Items[1].ID)
+
+	// counters lost with a crashed process are recounted from the likes and reactions
+	e.Like(users[4], blog.TargetPost, 2)
+	db.Exec("UPDATE posts SET like_count = 0")
+	db.Exec("DELETE FROM reaction_counts")
+	if err := blog.RecomputeEngagementCounts(db); err != nil {
+		log.Fatal(err)
+	}
+	recount := blog.NewEngagement(db)
+	s, err := recount.Stats(nil, blog.TargetPost, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	likes2, _ := stored(2)
+	check("recompute", s.Likes == 3 && reflect.DeepEqual(s.Reactions, want) && likes2 == 2, "%+v, post 2 like_count %d", s, likes2)
+
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
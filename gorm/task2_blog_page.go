@@ -0,0 +1,159 @@
+package main
+
+// check of the cursor pagination of the blog listings against SQLite:
+//
+//	go run gorm/task2_blog_page.go
+//
+// walks every sort order page by page and compares the result with a full
+// ordered query, with many posts sharing the same created_at and title so
+// that the id tie-break is exercised; exits with status 1 on any mismatch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/test/init_project/blog"
+)
+
+var failed int
+
+func fail(format string, args ...interface{}) {
+	failed++
+	fmt.Printf("FAIL "+format+"\n", args...)
+}
+
+// walk reads every page of the posts of user 1 and returns the ids in page order
+func walk(db *gorm.DB, sort string, limit int) ([]uint, int) {
+	var ids []uint
+	pages := 0
+	req := blog.PageRequest{Sort: sort, Limit: limit}
+	for {
+		page, err := blog.ListUserPosts(db, 1, req)
+		if err != nil {
+			log.Fatalf("list %s failed: %v", sort, err)
+		}
+		pages++
+		for _, post := range page.Items {
+			ids = append(ids, post.ID)
+		}
+		if page.HasMore != (page.NextCursor != "") {
+			fail("%s: has_more=%v with cursor %q", sort, page.HasMore, page.NextCursor)
+		}
+		if !page.HasMore {
+			return ids, pages
+		}
+		req.Cursor = page.NextCursor
+	}
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "blog-page")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "blog.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatal("open database failed:", err)
+	}
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	for _, name := range []string{"张三", "李四"} {
+		if _, err := blog.CreateUser(db, blog.UserInput{Name: name, Email: fmt.Sprintf("%x@example.com", name), Password: "123456"}); err != nil {
+			log.Fatal("create user failed:", err)
+		}
+	}
+	// 237 posts in groups of 10 with the same created_at, 7 distinct titles and counters
+	base := time.Now().Truncate(time.Second)
+	var posts []blog.Post
+	for i := 0; i < 237; i++ {
+		created := base.Add(time.Duration(i/10) * time.Second)
+		posts = append(posts, blog.Post{
+			Title:         fmt.Sprintf("post %d", i%7),
+			Content:       "content",
+			UserID:        1,
+			CommentCount:  i % 5,
+			CommentStatus: blog.CommentStatusNone,
+			Model:         gorm.Model{CreatedAt: created, UpdatedAt: created},
+		})
+	}
+	posts = append(posts, blog.Post{Title: "other user", Content: "content", UserID: 2, CommentStatus: blog.CommentStatusNone})
+	if err := db.CreateInBatches(posts, 100).Error; err != nil {
+		log.Fatal("create posts failed:", err)
+	}
+
+	for _, sort := range []string{"created_at", "-created_at", "title", "-title", "comment_count", "-comment_count", "id", "-id", "updated_at"} {
+		column, order := sort, "ASC"
+		if sort[0] == '-' {
+			column, order = sort[1:], "DESC"
+		}
+		var want []uint
+		db.Model(&blog.Post{}).Where("user_id = ?", 1).
+			Order(fmt.Sprintf("%s %s, id %s", column, order, order)).
+			Pluck("id", &want)
+		for _, limit := range []int{1, 7, 10, 100} {
+			got, pages := walk(db, sort, limit)
+			if !reflect.DeepEqual(got, want) {
+				fail("sort %s limit %d: %d ids in %d pages differ from the full query (%d ids)", sort, limit, len(got), pages, len(want))
+			}
+		}
+		fmt.Printf("ok   sort %-15s %d posts\n", sort, len(want))
+	}
+
+	// a page stays consistent while posts are inserted and deleted in front of the cursor
+	first, _ := blog.ListUserPosts(db, 1, blog.PageRequest{Sort: "created_at", Limit: 50})
+	db.Create(&blog.Post{Title: "early", Content: "c", UserID: 1, CommentStatus: blog.CommentStatusNone,
+		Model: gorm.Model{CreatedAt: base.Add(-time.Hour)}})
+	db.Delete(&first.Items[0])
+	second, _ := blog.ListUserPosts(db, 1, blog.PageRequest{Sort: "created_at", Limit: 50, Cursor: first.NextCursor})
+	if second.Items[0].ID != first.Items[49].ID+1 {
+		fail("page after concurrent changes starts at %d, want %d", second.Items[0].ID, first.Items[49].ID+1)
+	} else {
+		fmt.Println("ok   cursor is stable under inserts and deletes")
+	}
+
+	// total count, size cap and default size
+	page, _ := blog.ListUserPosts(db, 1, blog.PageRequest{Limit: 1000, WithTotal: true})
+	if page.Total == nil || *page.Total != 237 || len(page.Items) != blog.MaxPageSize {
+		fail("total %v and %d items, want 237 and %d", page.Total, len(page.Items), blog.MaxPageSize)
+	}
+	page, _ = blog.ListPosts(db, blog.PageRequest{})
+	if page.Total != nil || len(page.Items) != blog.DefaultPageSize {
+		fail("default page has total %v and %d items", page.Total, len(page.Items))
+	} else {
+		fmt.Println("ok   page size cap, default size and total")
+	}
+
+	// invalid requests
+	var invalid *blog.ValidationError
+	for name, req := range map[string]blog.PageRequest{
+		"unknown sort":       {Sort: "password"},
+		"negative limit":     {Limit: -1},
+		"garbage cursor":     {Cursor: "!!"},
+		"cursor of sort":     {Sort: "title", Cursor: first.NextCursor},
+		"cursor value type":  {Sort: "title", Cursor: "eyJzIjoidGl0bGUiLCJ2IjoxLCJpZCI6MX0"},
+		"injection via sort": {Sort: "id; DROP TABLE posts"},
+	} {
+		if _, err := blog.ListPosts(db, req); !errors.As(err, &invalid) {
+			fail("%s: error %v, want a validation error", name, err)
+		}
+	}
+	fmt.Println("ok   invalid page requests are rejected")
+
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
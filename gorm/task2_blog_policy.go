@@ -0,0 +1,212 @@
+package main
+
+// permission matrix check of the blog policy layer:
+//
+//	go run gorm/task2_blog_policy.go
+//
+// the first part checks Authorize for every role, action and ownership, the
+// second part runs the service functions against SQLite to make sure they
+// enforce the same matrix; the program exits with status 1 on any mismatch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/test/init_project/blog"
+)
+
+// expected permissions, "own" is a resource of the actor, "other" one of another user
+type permission struct{ own, other bool }
+
+var matrix = map[string]map[blog.Action]permission{
+	blog.RoleAdmin: {
+		blog.ActionCreatePost: {true, true}, blog.ActionUpdatePost: {true, true}, blog.ActionDeletePost: {true, true}, blog.ActionPublishPost: {true, true},
+		blog.ActionCreateComment: {true, true}, blog.ActionUpdateComment: {true, true}, blog.ActionDeleteComment: {true, true}, blog.ActionModerateComment: {true, true},
+		blog.ActionReact: {true, true}, blog.ActionUpdateUser: {true, true}, blog.ActionDeleteUser: {true, true}, blog.ActionSetRole: {true, true},
+		blog.ActionManageCategories: {true, true},
+	},
+	blog.RoleAuthor: {
+		blog.ActionCreatePost: {true, false}, blog.ActionUpdatePost: {true, false}, blog.ActionDeletePost: {true, false}, blog.ActionPublishPost: {true, false},
+		blog.ActionCreateComment: {true, false}, blog.ActionUpdateComment: {true, false}, blog.ActionDeleteComment: {true, false}, blog.ActionModerateComment: {true, false},
+		blog.ActionReact: {true, false}, blog.ActionUpdateUser: {true, false}, blog.ActionDeleteUser: {true, false}, blog.ActionSetRole: {false, false},
+		blog.ActionManageCategories: {false, false},
+	},
+	blog.RoleReader: {
+		blog.ActionCreatePost: {false, false}, blog.ActionUpdatePost: {true, false}, blog.ActionDeletePost: {true, false}, blog.ActionPublishPost: {false, false},
+		blog.ActionCreateComment: {true, false}, blog.ActionUpdateComment: {true, false}, blog.ActionDeleteComment: {true, false}, blog.ActionModerateComment: {true, false},
+		blog.ActionReact: {true, false}, blog.ActionUpdateUser: {true, false}, blog.ActionDeleteUser: {true, false}, blog.ActionSetRole: {false, false},
+		blog.ActionManageCategories: {false, false},
+	},
+}
+
+var roles = []string{blog.RoleAdmin, blog.RoleAuthor, blog.RoleReader}
+
+var failed int
+
+func check(name string, got, want bool) {
+	if got != want {
+		failed++
+		fmt.Printf("FAIL %s: allowed=%v, want %v\n", name, got, want)
+	}
+}
+
+func mark(ok bool) string {
+	if ok {
+		return "yes"
+	}
+	return "no"
+}
+
+// allowed turns a service error into a decision, other errors are fatal
+func allowed(name string, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, blog.ErrForbidden), errors.Is(err, blog.ErrUnauthenticated):
+		return false
+	}
+	log.Fatalf("%s: unexpected error: %v", name, err)
+	return false
+}
+
+func main() {
+	// policy matrix
+	fmt.Printf("%-18s", "action")
+	for _, role := range roles {
+		fmt.Printf("%-14s", role)
+	}
+	fmt.Println("anonymous   (own/other)")
+	for _, action := range blog.Actions {
+		fmt.Printf("%-18s", action)
+		for _, role := range roles {
+			actor := &blog.User{Role: role}
+			actor.ID = 1
+			own, other := blog.Can(actor, action, 1), blog.Can(actor, action, 2)
+			want := matrix[role][action]
+			check(fmt.Sprintf("%s %s own", role, action), own, want.own)
+			check(fmt.Sprintf("%s %s other", role, action), other, want.other)
+			fmt.Printf("%-14s", fmt.Sprintf("%s/%s", mark(own), mark(other)))
+		}
+		anonymous := blog.Can(nil, action, 0)
+		check(fmt.Sprintf("anonymous %s", action), anonymous, false)
+		fmt.Println(mark(anonymous))
+	}
+
+	// service functions
+	dir, err := os.MkdirTemp("", "blog-policy")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "blog.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatal("open database failed:", err)
+	}
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	newUser := func(name, role string) *blog.User {
+		user, err := blog.CreateUser(db, blog.UserInput{Name: name, Email: name + "@example.com", Password: "123456"})
+		if err != nil {
+			log.Fatal("create user failed:", err)
+		}
+		if err := db.Model(user).Update("role", role).Error; err != nil {
+			log.Fatal("set role failed:", err)
+		}
+		return user
+	}
+	owner := newUser("owner", blog.RoleAuthor)
+
+	for _, role := range roles {
+		actor := newUser(role, role)
+		want := matrix[role]
+
+		// posts and comments of the actor and of the owner, created directly so
+		// that setting up does not depend on the policy under test
+		posts := map[bool]*blog.Post{}
+		drafts := map[bool]*blog.Post{}
+		comments := map[bool]*blog.Comment{}
+		for _, own := range []bool{true, false} {
+			author := owner
+			if own {
+				author = actor
+			}
+			post := blog.Post{Title: "t", Content: "c", UserID: author.ID, CommentStatus: blog.CommentStatusNone}
+			db.Create(&post)
+			draft := blog.Post{Title: "t", Content: "c", UserID: author.ID, Status: blog.PostDraft}
+			db.Create(&draft)
+			comment := blog.Comment{Content: "c", UserID: author.ID, PostID: post.ID}
+			db.Create(&comment)
+			posts[own], drafts[own], comments[own] = &post, &draft, &comment
+		}
+
+		for _, own := range []bool{true, false} {
+			owned := map[bool]string{true: "own", false: "other"}[own]
+			expect := func(action blog.Action, err error) {
+				name := fmt.Sprintf("service %s %s %s", role, action, owned)
+				got := allowed(name, err)
+				if own {
+					check(name, got, want[action].own)
+				} else {
+					check(name, got, want[action].other)
+				}
+			}
+			userID := owner.ID
+			if own {
+				userID = actor.ID
+			}
+			title := "edited"
+			_, err := blog.CreatePost(db, actor, userID, blog.PostInput{Title: "t", Content: "c"})
+			expect(blog.ActionCreatePost, err)
+			_, err = blog.UpdatePost(db, actor, posts[own].ID, blog.PostPatch{Title: &title})
+			expect(blog.ActionUpdatePost, err)
+			published := blog.PostPublished
+			_, err = blog.UpdatePost(db, actor, drafts[own].ID, blog.PostPatch{Status: &published})
+			expect(blog.ActionPublishPost, err)
+			_, err = blog.CreateComment(db, actor, posts[own].ID, blog.CommentInput{UserID: userID, Content: "c"})
+			expect(blog.ActionCreateComment, err)
+			_, err = blog.UpdateComment(db, actor, comments[own].ID, blog.CommentPatch{Content: &title})
+			expect(blog.ActionUpdateComment, err)
+			// comments[own] is on posts[own], whose author moderates it
+			_, err = blog.ModerateComment(db, actor, comments[own].ID, blog.CommentRejected)
+			expect(blog.ActionModerateComment, err)
+			expect(blog.ActionDeleteComment, blog.DeleteComment(db, actor, comments[own].ID))
+			expect(blog.ActionDeletePost, blog.DeletePost(db, actor, posts[own].ID))
+			_, err = blog.UpdateUser(db, actor, userID, blog.UserPatch{Name: &title})
+			expect(blog.ActionUpdateUser, err)
+			reader := blog.RoleReader
+			_, err = blog.UpdateUser(db, actor, userID, blog.UserPatch{Role: &reader})
+			expect(blog.ActionSetRole, err)
+			_, err = blog.CreateCategory(db, actor, blog.CategoryInput{Name: fmt.Sprintf("%s %s", role, owned)})
+			expect(blog.ActionManageCategories, err)
+		}
+
+		// anonymous callers can do nothing
+		_, err := blog.CreatePost(db, nil, owner.ID, blog.PostInput{Title: "t", Content: "c"})
+		check("service anonymous create post", allowed("anonymous", err), false)
+		_, err = blog.NewEngagement(db).Like(nil, blog.TargetPost, owner.ID)
+		check("service anonymous like", allowed("anonymous", err), false)
+		// restore the role the loop may have changed and check deleting users last
+		db.Model(actor).Update("role", role)
+		db.Model(owner).Update("role", blog.RoleAuthor)
+		check(fmt.Sprintf("service %s user:delete other", role), allowed("delete", blog.DeleteUser(db, actor, owner.ID)), want[blog.ActionDeleteUser].other)
+		if want[blog.ActionDeleteUser].other {
+			db.Unscoped().Model(owner).Update("deleted_at", nil)
+		}
+		check(fmt.Sprintf("service %s user:delete own", role), allowed("delete", blog.DeleteUser(db, actor, actor.ID)), want[blog.ActionDeleteUser].own)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("permission matrix holds")
+}
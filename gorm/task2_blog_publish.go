@@ -0,0 +1,261 @@
+package main
+
+// check of post drafts, scheduled publishing and revisions against SQLite:
+//
+//	go run gorm/task2_blog_publish.go
+//
+// exits with status 1 on any failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/test/init_project/blog"
+)
+
+var failed int
+
+func check(name string, ok bool, format string, args ...interface{}) {
+	if !ok {
+		failed++
+		fmt.Printf("FAIL %s: "+format+"\n", append([]interface{}{name}, args...)...)
+		return
+	}
+	fmt.Printf("ok   %s\n", name)
+}
+
+func postIDs(page *blog.Page[blog.Post], err error) []uint {
+	if err != nil {
+		log.Fatal(err)
+	}
+	ids := []uint{}
+	for _, post := range page.Items {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
+
+func numbers(page *blog.Page[blog.PostRevision], err error) []int {
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := []int{}
+	for _, r := range page.Items {
+		out = append(out, r.Number)
+	}
+	return out
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "blog-publish")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "blog.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatal("open database failed:", err)
+	}
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+	search := blog.NewSearch(db)
+	found := func(q string) bool {
+		result, err := search.Search(q, blog.SearchOptions{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return result.Total > 0
+	}
+
+	author, err := blog.CreateUser(db, blog.UserInput{Name: "张三", Email: "zhangsan@example.com", Password: "123456"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	other, err := blog.CreateUser(db, blog.UserInput{Name: "李四", Email: "lisi@example.com", Password: "654321"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	live, err := blog.CreatePost(db, author, author.ID, blog.PostInput{Title: "GORM 入门教程", Content: "published right away"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("posts are published by default", live.Status == blog.PostPublished && live.PublishAt != nil, "%s %v", live.Status, live.PublishAt)
+
+	// drafts are only seen by their editors
+	draft, err := blog.CreatePost(db, author, author.ID, blog.PostInput{Title: "草稿", Content: "unfinished draft", Status: blog.PostDraft})
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("draft", draft.Status == blog.PostDraft && draft.PublishAt == nil, "%s %v", draft.Status, draft.PublishAt)
+	check("drafts are not listed", reflect.DeepEqual(postIDs(blog.ListPosts(db, blog.PageRequest{})), []uint{live.ID}), "%v", postIDs(blog.ListPosts(db, blog.PageRequest{})))
+	_, err = blog.GetVisiblePost(db, other, draft.ID)
+	check("draft of another user", errors.Is(err, blog.ErrNotFound), "error %v", err)
+	_, err = blog.GetVisiblePost(db, nil, draft.ID)
+	check("draft for anonymous callers", errors.Is(err, blog.ErrNotFound), "error %v", err)
+	_, err = blog.GetVisiblePost(db, author, draft.ID)
+	check("own draft", err == nil, "error %v", err)
+	drafts := postIDs(blog.ListUserPostsByStatus(db, author, author.ID, blog.PostDraft, blog.PageRequest{}))
+	check("list own drafts", reflect.DeepEqual(drafts, []uint{draft.ID}), "%v", drafts)
+	_, err = blog.ListUserPostsByStatus(db, other, author.ID, blog.PostDraft, blog.PageRequest{})
+	check("list drafts of another user", errors.Is(err, blog.ErrForbidden), "error %v", err)
+	_, err = blog.CreateComment(db, author, draft.ID, blog.CommentInput{Content: "评论"})
+	check("comment on a draft", errors.Is(err, blog.ErrConflict), "error %v", err)
+	_, err = blog.NewEngagement(db).Like(other, blog.TargetPost, draft.ID)
+	check("like a draft of another user", errors.Is(err, blog.ErrNotFound), "error %v", err)
+	check("drafts are not searchable", !found("unfinished"), "draft found")
+
+	var invalid *blog.ValidationError
+	_, err = blog.CreatePost(db, author, author.ID, blog.PostInput{Title: "t", Content: "c", Status: "hidden"})
+	check("unknown status", errors.As(err, &invalid), "error %v", err)
+	past := time.Now().Add(-time.Hour)
+	_, err = blog.CreatePost(db, author, author.ID, blog.PostInput{Title: "t", Content: "c", Status: blog.PostScheduled, PublishAt: &past})
+	check("scheduled in the past", errors.As(err, &invalid), "error %v", err)
+	_, err = blog.CreatePost(db, author, author.ID, blog.PostInput{Title: "t", Content: "c", PublishAt: &past})
+	check("publish time of a published post", errors.As(err, &invalid), "error %v", err)
+	archived := blog.PostArchived
+	_, err = blog.UpdatePost(db, author, draft.ID, blog.PostPatch{Status: &archived})
+	check("archive a draft", errors.As(err, &invalid), "error %v", err)
+
+	// publishing a draft
+	published := blog.PostPublished
+	post, err := blog.UpdatePost(db, author, draft.ID, blog.PostPatch{Status: &published})
+	check("publish a draft", err == nil && post.Status == blog.PostPublished && post.PublishAt != nil, "%+v %v", post, err)
+	check("published drafts are searchable", found("unfinished"), "not found")
+	reader := &blog.User{Role: blog.RoleReader}
+	reader.ID = author.ID
+	back := blog.PostDraft
+	_, err = blog.UpdatePost(db, reader, draft.ID, blog.PostPatch{Status: &back})
+	check("readers take their posts back", err == nil, "error %v", err)
+	_, err = blog.UpdatePost(db, reader, draft.ID, blog.PostPatch{Status: &published})
+	check("readers do not publish", errors.Is(err, blog.ErrForbidden), "error %v", err)
+
+	// scheduled posts go live with the publisher
+	at := time.Now().Add(time.Hour)
+	scheduled, err := blog.CreatePost(db, author, author.ID, blog.PostInput{Title: "定时发布", Content: "scheduled announcement", Status: blog.PostScheduled, PublishAt: &at})
+	if err != nil {
+		log.Fatal(err)
+	}
+	later := at.Add(time.Hour)
+	if _, err := blog.UpdatePost(db, author, scheduled.ID, blog.PostPatch{PublishAt: &later}); err != nil {
+		check("reschedule", false, "error %v", err)
+	}
+	_, err = blog.UpdatePost(db, author, live.ID, blog.PostPatch{PublishAt: &later})
+	check("schedule a published post without the status", errors.As(err, &invalid), "error %v", err)
+	n, err := blog.PublishDue(db, at.Add(time.Minute))
+	check("nothing due", err == nil && n == 0, "%d published, error %v", n, err)
+	check("scheduled posts are not searchable", !found("announcement"), "scheduled post found")
+	n, err = blog.PublishDue(db, later)
+	check("publish due posts", err == nil && n == 1, "%d published, error %v", n, err)
+	post, _ = blog.GetVisiblePost(db, nil, scheduled.ID)
+	check("scheduled post is live", post != nil && post.Status == blog.PostPublished && post.PublishAt.Equal(later), "%+v", post)
+	check("scheduled post is searchable", found("announcement"), "not found")
+	n, _ = blog.PublishDue(db, later)
+	check("published once", n == 0, "%d published again", n)
+
+	soon := time.Now().Add(50 * time.Millisecond)
+	scheduled, err = blog.CreatePost(db, author, author.ID, blog.PostInput{Title: "马上发布", Content: "c", Status: blog.PostScheduled, PublishAt: &soon})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	done := make(chan error)
+	go func() { done <- blog.RunPublisher(ctx, db, 20*time.Millisecond) }()
+	for ctx.Err() == nil {
+		if post, _ := blog.GetPost(db, scheduled.ID); post.Status == blog.PostPublished {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	check("publisher", ctx.Err() == nil, "post %d was not published", scheduled.ID)
+	cancel()
+	<-done
+
+	// archived posts stay readable but leave the listings
+	comment, err := blog.CreateComment(db, author, live.ID, blog.CommentInput{Content: "searchable comment"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	firstPublished := *live.PublishAt
+	if _, err := blog.UpdatePost(db, author, live.ID, blog.PostPatch{Status: &archived}); err != nil {
+		log.Fatal(err)
+	}
+	_, err = blog.GetVisiblePost(db, nil, live.ID)
+	check("archived posts are readable", err == nil, "error %v", err)
+	listed := postIDs(blog.ListPosts(db, blog.PageRequest{Sort: "id"}))
+	check("archived posts are not listed", reflect.DeepEqual(listed, []uint{3, 4}), "%v", listed)
+	_, err = blog.CreateComment(db, other, live.ID, blog.CommentInput{Content: "late"})
+	check("archived posts take no comments", errors.Is(err, blog.ErrConflict), "error %v", err)
+	check("comments of archived posts are not searchable", !found("searchable"), "comment %d found", comment.ID)
+	post, err = blog.UpdatePost(db, author, live.ID, blog.PostPatch{Status: &published})
+	check("unarchive keeps the publish time", err == nil && post.PublishAt.Equal(firstPublished), "%v %v", post.PublishAt, err)
+	check("comments come back with their post", found("searchable"), "comment %d not found", comment.ID)
+
+	// revisions
+	edit := func(title, content string) {
+		if _, err := blog.UpdatePost(db, author, live.ID, blog.PostPatch{Title: &title, Content: &content}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	edit("GORM 入门教程", "line 1\nline 2\nline 3")
+	edit("GORM 入门", "line 1\nline two\nline 3\nline 4")
+	edit("GORM 入门", "line 1\nline two\nline 3\nline 4")
+	history := numbers(blog.ListRevisions(db, author, live.ID, blog.PageRequest{}))
+	check("history", reflect.DeepEqual(history, []int{3, 2, 1}), "%v", history)
+	first, err := blog.GetRevision(db, author, live.ID, 1)
+	check("first revision", err == nil && first.Content == "published right away" && first.UserID == author.ID &&
+		first.Diff == "--- /dev/null\n+++ revision 1\n@@ -0,0 +1,3 @@\n+GORM 入门教程\n+\n+published right away\n", "%+v %v", first, err)
+	third, _ := blog.GetRevision(db, author, live.ID, 3)
+	wantDiff := "--- revision 2\n+++ revision 3\n" +
+		"@@ -1,5 +1,6 @@\n-GORM 入门教程\n+GORM 入门\n \n line 1\n-line 2\n+line two\n line 3\n+line 4\n"
+	check("diff", third != nil && third.Diff == wantDiff, "%q", third.Diff)
+	_, err = blog.ListRevisions(db, other, live.ID, blog.PageRequest{})
+	check("history of another user", errors.Is(err, blog.ErrForbidden), "error %v", err)
+	_, err = blog.GetRevision(db, author, live.ID, 99)
+	check("missing revision", errors.Is(err, blog.ErrNotFound), "error %v", err)
+
+	post, err = blog.RestoreRevision(db, author, live.ID, 1)
+	check("restore", err == nil && post.Title == "GORM 入门教程" && post.Content == "published right away", "%+v %v", post, err)
+	restored, _ := blog.GetRevision(db, author, live.ID, 4)
+	check("restore is a revision", restored != nil && restored.RestoredFrom != nil && *restored.RestoredFrom == 1, "%+v", restored)
+	if _, err := blog.RestoreRevision(db, author, live.ID, 4); err != nil {
+		log.Fatal(err)
+	}
+	history = numbers(blog.ListRevisions(db, author, live.ID, blog.PageRequest{Sort: "number"}))
+	check("restoring the current version", reflect.DeepEqual(history, []int{1, 2, 3, 4}), "%v", history)
+	_, err = blog.RestoreRevision(db, other, live.ID, 1)
+	check("restore a post of another user", errors.Is(err, blog.ErrForbidden), "error %v", err)
+
+	// a post written without revisions gets the version it replaces as revision 1
+	seed := blog.Post{Title: "seed", Content: "old", UserID: author.ID}
+	db.Create(&seed)
+	newContent := "new"
+	if _, err := blog.UpdatePost(db, other, seed.ID, blog.PostPatch{Content: &newContent}); !errors.Is(err, blog.ErrForbidden) {
+		check("edit a post of another user", false, "error %v", err)
+	}
+	admin := &blog.User{Role: blog.RoleAdmin}
+	admin.ID = 99
+	if _, err := blog.UpdatePost(db, admin, seed.ID, blog.PostPatch{Content: &newContent}); err != nil {
+		log.Fatal(err)
+	}
+	baseline, _ := blog.GetRevision(db, author, seed.ID, 1)
+	edited, _ := blog.GetRevision(db, author, seed.ID, 2)
+	check("baseline revision", baseline != nil && baseline.Content == "old" && baseline.UserID == author.ID &&
+		edited != nil && edited.UserID == admin.ID && edited.Content == "new", "%+v %+v", baseline, edited)
+
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
@@ -0,0 +1,230 @@
+package main
+
+// check of the blog full-text search against SQLite:
+//
+//	go run gorm/task2_blog_search.go                                      # in-memory index
+//	go run -tags sqlite_fts5 gorm/task2_blog_search.go -engine sqlite-fts5  # SQLite FTS5
+//
+// go-sqlite3 only compiles FTS5 in with the sqlite_fts5 build tag, without
+// it the search falls back to the in-memory index. The check fails when the
+// engine in use is not the one the build offers, or not the one -engine
+// asks for. Both engines must give the same answers; exits with status 1
+// on any failure
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/test/init_project/blog"
+)
+
+var failed int
+
+func fail(format string, args ...interface{}) {
+	failed++
+	fmt.Printf("FAIL "+format+"\n", args...)
+}
+
+type hit struct {
+	kind string
+	id   uint
+}
+
+func run(search *blog.Search, q string, opts blog.SearchOptions) *blog.SearchResult {
+	result, err := search.Search(q, opts)
+	if err != nil {
+		log.Fatalf("search %q failed: %v", q, err)
+	}
+	return result
+}
+
+// expect compares the hits of a query, in rank order
+func expect(search *blog.Search, q string, want ...hit) *blog.SearchResult {
+	result := run(search, q, blog.SearchOptions{})
+	got := []hit{}
+	for _, h := range result.Hits {
+		got = append(got, hit{h.Kind, h.ID})
+	}
+	if want == nil {
+		want = []hit{}
+	}
+	if !reflect.DeepEqual(got, want) || result.Total != int64(len(want)) {
+		fail("%q: hits %v (total %d), want %v", q, got, result.Total, want)
+	} else {
+		fmt.Printf("ok   %-28q %v\n", q, got)
+	}
+	return result
+}
+
+// expectSet compares the hits of a query in any order, for near ties the
+// engines may rank differently
+func expectSet(search *blog.Search, q string, want ...hit) {
+	result := run(search, q, blog.SearchOptions{})
+	got := map[hit]bool{}
+	for _, h := range result.Hits {
+		got[hit{h.Kind, h.ID}] = true
+	}
+	for _, h := range want {
+		if !got[h] {
+			fail("%q: %v is missing from %v", q, h, result.Hits)
+			return
+		}
+	}
+	if len(got) != len(want) {
+		fail("%q: %d hits, want %d", q, len(got), len(want))
+		return
+	}
+	fmt.Printf("ok   %-28q %v (any order)\n", q, want)
+}
+
+func post(id uint) hit    { return hit{blog.SearchKindPost, id} }
+func comment(id uint) hit { return hit{blog.SearchKindComment, id} }
+
+func main() {
+	wantEngine := flag.String("engine", "", "engine the search must use, memory or sqlite-fts5")
+	flag.Parse()
+
+	dir, err := os.MkdirTemp("", "blog-search")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "blog.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatal("open database failed:", err)
+	}
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	author, err := blog.CreateUser(db, blog.UserInput{Name: "张三", Email: "zhangsan@example.com", Password: "123456"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, in := range []blog.PostInput{
+		{Title: "GORM 入门教程", Content: "这是一篇关于GORM的入门教程，介绍模型定义、关联和钩子。"},
+		{Title: "Go语言实战", Content: "介绍Go语言的高级特性和最佳实践，例如 goroutine 与 channel。GORM 也会顺带提到。"},
+		{Title: "微服务架构", Content: "讨论微服务设计模式和实现方法，" + strings.Repeat("服务拆分需要权衡。", 30) + "最后总结 <script> 注入的防护。"},
+		{Title: "Database tuning", Content: "Indexes, query plans and the GORM preload trap. Tuning MySQL for writes."},
+	} {
+		if _, err := blog.CreatePost(db, author, author.ID, in); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, in := range []struct {
+		post    uint
+		content string
+	}{
+		{1, "非常实用的教程！"},
+		{1, "期待后续更新 GORM 进阶内容"},
+		{3, "微服务确实复杂"},
+	} {
+		if _, err := blog.CreateComment(db, author, in.post, blog.CommentInput{Content: in.content}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	search := blog.NewSearch(db)
+	fmt.Println("engine:", search.Engine())
+	var fts5 bool
+	if err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5).Error; err != nil {
+		log.Fatal("query compile options failed:", err)
+	}
+	available := "memory"
+	if fts5 {
+		available = "sqlite-fts5"
+	}
+	if search.Engine() != available {
+		fail("engine %s in use, the build offers %s", search.Engine(), available)
+	}
+	if *wantEngine != "" && search.Engine() != *wantEngine {
+		fail("engine %s in use, want %s", search.Engine(), *wantEngine)
+	}
+
+	// a title match outranks content matches, a short text outranks a long one
+	expect(search, "gorm", post(1), comment(2), post(4), post(2))
+	expect(search, "GORM 入门", post(1))
+	expect(search, "入门教程", post(1))
+	expect(search, "教程", post(1), comment(1))
+	expect(search, `"GORM 入门教程"`, post(1))
+	// the words exist but not as this phrase
+	expect(search, `"入门 GORM"`)
+	expect(search, `"query plans"`, post(4))
+	expect(search, `"plans query"`)
+	expect(search, "tun*", post(4))
+	expectSet(search, "微", post(3), comment(3))
+	expect(search, "微服务 拆分", post(3))
+	expect(search, "不存在的词")
+
+	// filters and paging
+	result := run(search, "gorm", blog.SearchOptions{Kinds: []string{blog.SearchKindComment}})
+	if len(result.Hits) != 1 || result.Hits[0].PostID != 1 {
+		fail("comment filter: %+v", result.Hits)
+	}
+	result = run(search, "gorm", blog.SearchOptions{Limit: 2, Offset: 1})
+	if len(result.Hits) != 2 || result.Total != 4 || result.Hits[0].ID != 2 || result.Hits[1].ID != 4 {
+		fail("paging: %+v total %d", result.Hits, result.Total)
+	}
+
+	// highlighted and escaped snippets
+	result = run(search, "入门", blog.SearchOptions{})
+	if h := result.Hits[0]; h.Title != "GORM <mark>入门</mark>教程" || !strings.Contains(h.Snippet, "GORM的<mark>入门</mark>教程") {
+		fail("highlight: title %q snippet %q", h.Title, h.Snippet)
+	}
+	result = run(search, "注入", blog.SearchOptions{})
+	if s := result.Hits[0].Snippet; !strings.HasPrefix(s, "…") || !strings.Contains(s, "&lt;script&gt; <mark>注入</mark>") {
+		fail("snippet of a long text: %q", s)
+	} else {
+		fmt.Println("ok   snippets", s)
+	}
+
+	// updates and deletes are visible to the next search
+	title := "GORM 进阶教程"
+	if _, err := blog.UpdatePost(db, author, 1, blog.PostPatch{Title: &title}); err != nil {
+		log.Fatal(err)
+	}
+	expect(search, "进阶", post(1), comment(2))
+	expect(search, `"GORM 入门教程"`)
+	content := "已经修改的评论"
+	if _, err := blog.UpdateComment(db, author, 1, blog.CommentPatch{Content: &content}); err != nil {
+		log.Fatal(err)
+	}
+	expect(search, "修改", comment(1))
+	if err := blog.DeleteComment(db, author, 1); err != nil {
+		log.Fatal(err)
+	}
+	expect(search, "修改")
+	if err := blog.DeletePost(db, author, 3); err != nil {
+		log.Fatal(err)
+	}
+	// the comment of the deleted post goes too
+	expect(search, "微服务")
+
+	// rows written without hooks are picked up by Reindex
+	db.Exec("UPDATE posts SET content = ? WHERE id = 4", "Rewritten behind the back of the hooks")
+	expect(search, "rewritten")
+	if err := blog.Reindex(db); err != nil {
+		log.Fatal("reindex failed:", err)
+	}
+	expect(search, "rewritten", post(4))
+	expect(search, "微服务")
+
+	if _, err := search.Search(`""`, blog.SearchOptions{}); err == nil {
+		fail("empty query is accepted")
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
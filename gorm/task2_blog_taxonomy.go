@@ -0,0 +1,214 @@
+package main
+
+// check of post tags and the category tree against SQLite:
+//
+//	go run gorm/task2_blog_taxonomy.go
+//
+// exits with status 1 on any failure
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/test/init_project/blog"
+)
+
+var failed int
+
+func check(name string, ok bool, format string, args ...interface{}) {
+	if !ok {
+		failed++
+		fmt.Printf("FAIL %s: "+format+"\n", append([]interface{}{name}, args...)...)
+		return
+	}
+	fmt.Printf("ok   %s\n", name)
+}
+
+func slugs(tags []blog.Tag) []string {
+	out := []string{}
+	for _, tag := range tags {
+		out = append(out, tag.Slug)
+	}
+	return out
+}
+
+func postIDs(page *blog.Page[blog.Post], err error) []uint {
+	if err != nil {
+		log.Fatal(err)
+	}
+	ids := []uint{}
+	for _, post := range page.Items {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "blog-taxonomy")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "blog.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatal("open database failed:", err)
+	}
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	author, err := blog.CreateUser(db, blog.UserInput{Name: "张三", Email: "zhangsan@example.com", Password: "123456"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	other, err := blog.CreateUser(db, blog.UserInput{Name: "李四", Email: "lisi@example.com", Password: "654321"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	admin := &blog.User{Role: blog.RoleAdmin}
+	for _, title := range []string{"GORM 入门教程", "Go语言实战", "微服务架构"} {
+		if _, err := blog.CreatePost(db, author, author.ID, blog.PostInput{Title: title, Content: "..."}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// tags are created on first use and shared by slug
+	tags, err := blog.AttachTags(db, author, 1, []string{"Go", "GORM", "数据库", "go"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("attach tags", reflect.DeepEqual(slugs(tags), []string{"go", "gorm", "数据库"}), "%v", slugs(tags))
+	if _, err := blog.AttachTags(db, author, 1, []string{"GORM"}); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := blog.AttachTags(db, author, 2, []string{"Go", "Best Practices!"}); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := blog.AttachTags(db, author, 3, []string{"go"}); err != nil {
+		log.Fatal(err)
+	}
+	tag, err := blog.GetTag(db, "best-practices")
+	check("slug of a name with spaces", err == nil && tag.Name == "Best Practices!", "%v %v", tag, err)
+	var tagCount int64
+	db.Model(&blog.Tag{}).Count(&tagCount)
+	check("tags are not duplicated", tagCount == 4, "%d tags", tagCount)
+
+	_, err = blog.AttachTags(db, other, 1, []string{"spam"})
+	check("tag a post of another user", errors.Is(err, blog.ErrForbidden), "error %v", err)
+	_, err = blog.AttachTags(db, author, 1, []string{"!!!"})
+	var invalid *blog.ValidationError
+	check("tag without letters", errors.As(err, &invalid), "error %v", err)
+	many := []string{}
+	for i := 0; i < blog.MaxPostTags; i++ {
+		many = append(many, fmt.Sprintf("tag%d", i))
+	}
+	_, err = blog.AttachTags(db, author, 1, many)
+	check("too many tags", errors.As(err, &invalid), "error %v", err)
+	tags, _ = blog.PostTags(db, 1)
+	check("too many tags are rolled back", len(tags) == 3, "%v", slugs(tags))
+
+	counts, err := blog.ListTags(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, c := range counts {
+		got[c.Slug] = c.PostCount
+	}
+	check("tag counts", counts[0].Slug == "go" && reflect.DeepEqual(got, map[string]int64{"go": 3, "gorm": 1, "数据库": 1, "best-practices": 1}), "%v", got)
+	check("posts by tag", reflect.DeepEqual(postIDs(blog.ListTagPosts(db, "go", blog.PageRequest{})), []uint{3, 2, 1}), "%v", postIDs(blog.ListTagPosts(db, "go", blog.PageRequest{})))
+	check("posts by tag, paged", reflect.DeepEqual(postIDs(blog.ListTagPosts(db, "go", blog.PageRequest{Limit: 2, Sort: "id"})), []uint{1, 2}), "%v", postIDs(blog.ListTagPosts(db, "go", blog.PageRequest{Limit: 2, Sort: "id"})))
+	_, err = blog.ListTagPosts(db, "nothing", blog.PageRequest{})
+	check("unknown tag", errors.Is(err, blog.ErrNotFound), "error %v", err)
+
+	if err := blog.DetachTag(db, author, 2, "go"); err != nil {
+		log.Fatal(err)
+	}
+	if err := blog.DeletePost(db, author, 3); err != nil {
+		log.Fatal(err)
+	}
+	counts, _ = blog.ListTags(db)
+	check("detached and deleted posts are not counted", counts[0].PostCount == 1, "%+v", counts[0])
+	if _, err := blog.GetTag(db, "go"); err != nil {
+		check("detached tag stays", false, "error %v", err)
+	}
+
+	// the category tree
+	_, err = blog.CreateCategory(db, author, blog.CategoryInput{Name: "编程"})
+	check("only admins create categories", errors.Is(err, blog.ErrForbidden), "error %v", err)
+	newCategory := func(name string, parent *blog.Category) *blog.Category {
+		in := blog.CategoryInput{Name: name}
+		if parent != nil {
+			in.ParentID = &parent.ID
+		}
+		c, err := blog.CreateCategory(db, admin, in)
+		if err != nil {
+			log.Fatalf("create category %s failed: %v", name, err)
+		}
+		return c
+	}
+	programming := newCategory("编程", nil)
+	golang := newCategory("Go Language", programming)
+	orm := newCategory("ORM", golang)
+	architecture := newCategory("架构", nil)
+	check("category depth", orm.Depth == 2 && golang.Depth == 1, "%+v", orm)
+	check("category slug", golang.Slug == "go-language", "%q", golang.Slug)
+	_, err = blog.CreateCategory(db, admin, blog.CategoryInput{Name: "orm"})
+	check("duplicate category", errors.Is(err, blog.ErrConflict), "error %v", err)
+	missing := uint(99)
+	_, err = blog.CreateCategory(db, admin, blog.CategoryInput{Name: "x", ParentID: &missing})
+	check("missing parent", errors.As(err, &invalid), "error %v", err)
+	_, err = blog.CreateCategory(db, admin, blog.CategoryInput{Name: "x", Slug: "Not A Slug"})
+	check("invalid slug", errors.As(err, &invalid), "error %v", err)
+
+	tree, err := blog.CategoryTree(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("category tree", len(tree) == 2 && tree[0].ID == programming.ID && len(tree[0].Children) == 1 &&
+		tree[0].Children[0].Children[0].ID == orm.ID && tree[1].ID == architecture.ID, "%+v", tree)
+
+	if _, err := blog.AttachCategories(db, author, 1, []uint{orm.ID}); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := blog.AttachCategories(db, author, 2, []uint{golang.ID, architecture.ID}); err != nil {
+		log.Fatal(err)
+	}
+	_, err = blog.AttachCategories(db, author, 2, []uint{missing})
+	check("attach a missing category", errors.As(err, &invalid), "error %v", err)
+	_, err = blog.AttachCategories(db, other, 2, []uint{orm.ID})
+	check("file a post of another user", errors.Is(err, blog.ErrForbidden), "error %v", err)
+	categories, err := blog.PostCategories(db, 2)
+	check("post categories", err == nil && len(categories) == 2 && categories[0].ID == golang.ID, "%+v %v", categories, err)
+
+	subtree := func(c *blog.Category) []uint {
+		return postIDs(blog.ListCategoryPosts(db, c.ID, blog.PageRequest{Sort: "id"}))
+	}
+	check("subtree of the root", reflect.DeepEqual(subtree(programming), []uint{1, 2}), "%v", subtree(programming))
+	check("subtree of a child", reflect.DeepEqual(subtree(golang), []uint{1, 2}), "%v", subtree(golang))
+	check("leaf", reflect.DeepEqual(subtree(orm), []uint{1}), "%v", subtree(orm))
+	check("sibling tree", reflect.DeepEqual(subtree(architecture), []uint{2}), "%v", subtree(architecture))
+	if err := blog.DetachCategory(db, author, 2, golang.ID); err != nil {
+		log.Fatal(err)
+	}
+	check("detach category", reflect.DeepEqual(subtree(programming), []uint{1}), "%v", subtree(programming))
+
+	// associations load like the other relations
+	var post blog.Post
+	db.Preload("Tags").Preload("Categories").Preload("User").First(&post, 1)
+	check("preload", len(post.Tags) == 3 && len(post.Categories) == 1 && post.User.ID == author.ID, "%d tags %d categories", len(post.Tags), len(post.Categories))
+
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
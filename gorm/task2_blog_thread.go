@@ -0,0 +1,209 @@
+package main
+
+// check of threaded comments and moderation against SQLite:
+//
+//	go run gorm/task2_blog_thread.go
+//
+// exits with status 1 on any failure
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/test/init_project/blog"
+)
+
+var failed int
+
+func check(name string, ok bool, format string, args ...interface{}) {
+	if !ok {
+		failed++
+		fmt.Printf("FAIL %s: "+format+"\n", append([]interface{}{name}, args...)...)
+		return
+	}
+	fmt.Printf("ok   %s\n", name)
+}
+
+// flatten lists the ids of a thread depth first, the order a reader sees them
+func flatten(nodes []*blog.CommentNode) []uint {
+	ids := []uint{}
+	for _, n := range nodes {
+		ids = append(ids, n.ID)
+		ids = append(ids, flatten(n.Replies)...)
+	}
+	return ids
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "blog-thread")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "blog.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatal("open database failed:", err)
+	}
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	author, err := blog.CreateUser(db, blog.UserInput{Name: "张三", Email: "zhangsan@example.com", Password: "123456"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	reader, err := blog.CreateUser(db, blog.UserInput{Name: "李四", Email: "lisi@example.com", Password: "654321"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	post, err := blog.CreatePost(db, author, author.ID, blog.PostInput{Title: "GORM 入门教程", Content: "关联和钩子"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	comment := func(actor *blog.User, parent *uint, content string) *blog.Comment {
+		c, err := blog.CreateComment(db, actor, post.ID, blog.CommentInput{ParentID: parent, Content: content})
+		if err != nil {
+			log.Fatalf("comment %q failed: %v", content, err)
+		}
+		return c
+	}
+	count := func() int {
+		p, err := blog.GetPost(db, post.ID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return p.CommentCount
+	}
+
+	// a chain of replies deeper than one base 36 digit of ids, and a second
+	// top level comment in between so that paths must sort level by level
+	first := comment(author, nil, "第一条")
+	parent := first.ID
+	chain := []uint{first.ID}
+	var second *blog.Comment
+	for i := 0; i < 40; i++ {
+		if i == 20 {
+			second = comment(author, nil, "第二条")
+		}
+		reply := comment(author, &parent, fmt.Sprintf("回复 %d", i))
+		parent = reply.ID
+		chain = append(chain, reply.ID)
+	}
+	deepest, err := blog.GetComment(db, parent)
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("depth and root", deepest.Depth == 40 && deepest.RootID == first.ID, "depth %d root %d", deepest.Depth, deepest.RootID)
+	check("approved comments are counted", count() == 42, "comment_count %d", count())
+
+	page, err := blog.ListThreads(db, nil, post.ID, blog.PageRequest{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	want := append(append([]uint{}, chain...), second.ID)
+	check("thread order", reflect.DeepEqual(flatten(page.Items), want), "%v, want %v", flatten(page.Items), want)
+
+	// a reply of the reader waits for moderation and is only shown to them and the author
+	pending := comment(reader, &second.ID, "读者的回复")
+	check("reply of a reader is pending", pending.State == blog.CommentPending, "state %s", pending.State)
+	check("pending reply is not counted", count() == 42, "comment_count %d", count())
+	for _, v := range []struct {
+		name    string
+		viewer  *blog.User
+		visible bool
+	}{
+		{"anonymous", nil, false},
+		{"its author", reader, true},
+		{"the author of the post", author, true},
+	} {
+		thread, err := blog.GetThread(db, v.viewer, second.ID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		check("pending reply seen by "+v.name, (len(thread.Replies) == 1) == v.visible, "%d replies", len(thread.Replies))
+	}
+	_, err = blog.CreateComment(db, reader, post.ID, blog.CommentInput{ParentID: &pending.ID, Content: "?"})
+	var invalid *blog.ValidationError
+	check("reply to a pending comment", errors.As(err, &invalid), "error %v", err)
+	_, err = blog.ModerateComment(db, reader, pending.ID, blog.CommentApproved)
+	check("reader cannot moderate the post", errors.Is(err, blog.ErrForbidden), "error %v", err)
+
+	queue, err := blog.ListModerationQueue(db, author, blog.PageRequest{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("moderation queue", len(queue.Items) == 1 && queue.Items[0].ID == pending.ID, "%d items", len(queue.Items))
+
+	// moving between the states keeps the count in line
+	for _, step := range []struct {
+		state string
+		count int
+	}{
+		{blog.CommentApproved, 43},
+		{blog.CommentApproved, 43},
+		{blog.CommentSpam, 42},
+		{blog.CommentRejected, 42},
+		{blog.CommentApproved, 43},
+	} {
+		if _, err := blog.ModerateComment(db, author, pending.ID, step.state); err != nil {
+			log.Fatal(err)
+		}
+		check("moderate to "+step.state, count() == step.count, "comment_count %d, want %d", count(), step.count)
+	}
+	_, err = blog.ModerateComment(db, author, pending.ID, "deleted")
+	check("unknown state", errors.As(err, &invalid), "error %v", err)
+
+	// a rejected comment hides its approved replies
+	if _, err := blog.ModerateComment(db, author, chain[10], blog.CommentRejected); err != nil {
+		log.Fatal(err)
+	}
+	page, err = blog.ListThreads(db, nil, post.ID, blog.PageRequest{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("rejected comment hides its subtree", len(flatten(page.Items)) == 10+2, "%d comments visible", len(flatten(page.Items)))
+	if _, err := blog.ModerateComment(db, author, chain[10], blog.CommentApproved); err != nil {
+		log.Fatal(err)
+	}
+
+	// deleting a comment deletes its replies
+	if err := blog.DeleteComment(db, author, chain[30]); err != nil {
+		log.Fatal(err)
+	}
+	check("subtree delete", count() == 43-11, "comment_count %d", count())
+	if _, err := blog.GetComment(db, chain[40]); !errors.Is(err, blog.ErrNotFound) {
+		check("deepest reply is deleted", false, "error %v", err)
+	}
+
+	// threads are paged by their top level comments
+	page, err = blog.ListThreads(db, nil, post.ID, blog.PageRequest{Limit: 1})
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("paged threads", len(page.Items) == 1 && page.HasMore && len(flatten(page.Items)) == 30, "%d threads, %d comments", len(page.Items), len(flatten(page.Items)))
+
+	// comments written before threading are placed on the next migration
+	db.Model(&blog.Comment{}).Where("id = ?", second.ID).UpdateColumns(map[string]interface{}{"path": "", "root_id": 0})
+	if err := blog.AutoMigrate(db); err != nil {
+		log.Fatal(err)
+	}
+	backfilled, err := blog.GetComment(db, second.ID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	check("backfill", backfilled.RootID == second.ID && backfilled.Path != "", "root %d path %q", backfilled.RootID, backfilled.Path)
+
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
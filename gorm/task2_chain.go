@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/chain"
+	"github.com/test/init_project/config"
+)
+
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+	if err := chain.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	// a sender and a receiver wallet
+	senderPub, senderKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		log.Fatal("generate key failed:", err)
+	}
+	receiverPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		log.Fatal("generate key failed:", err)
+	}
+	if _, err := bank.CreateWallet(db, senderPub, 100); err != nil {
+		log.Fatal("create wallet failed:", err)
+	}
+	if _, err := bank.CreateWallet(db, receiverPub, 0); err != nil {
+		log.Fatal("create wallet failed:", err)
+	}
+	receiver := hex.EncodeToString(receiverPub)
+
+	// start producing a block every 500ms
+	node, err := chain.NewNode(db)
+	if err != nil {
+		log.Fatal("start node failed:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go node.Run(ctx, 500*time.Millisecond)
+
+	// submit out of nonce order, the mempool holds nonce 1 back until nonce 0 arrives
+	var hashes []string
+	for _, nonce := range []uint64{1, 0, 2} {
+		transfer, err := bank.SignTransfer(senderKey, receiver, float64(10*(nonce+1)), nonce)
+		if err != nil {
+			log.Fatal("sign transfer failed:", err)
+		}
+		hash, err := node.Submit(transfer)
+		if err != nil {
+			log.Fatal("submit transfer failed:", err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	// wait until everything is mined
+	for node.PendingCount() > 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, hash := range hashes {
+		receipt, err := node.Receipt(hash)
+		if err != nil {
+			log.Fatal("get receipt failed:", err)
+		}
+		block, err := node.BlockByNumber(receipt.BlockNumber)
+		if err != nil {
+			log.Fatal("get block failed:", err)
+		}
+		log.Printf("tx %s: nonce %d, %s in block %d (%s) at position %d",
+			hash[:10], receipt.Nonce, receipt.Status, block.Number, block.Hash[:10], receipt.Position)
+	}
+
+	// from here on the node is driven by hand with its own clock
+	cancel()
+	now := time.Now()
+	node.Now = func() time.Time { return now }
+	submit := func(nonce uint64, amount float64, upper bool) string {
+		transfer, err := bank.SignTransfer(senderKey, receiver, amount, nonce)
+		if err != nil {
+			log.Fatal("sign transfer failed:", err)
+		}
+		if upper {
+			transfer.From = strings.ToUpper(transfer.From)
+		}
+		hash, err := node.Submit(transfer)
+		if err != nil {
+			log.Fatal("submit transfer failed:", err)
+		}
+		return hash
+	}
+	produce := func() *chain.Block {
+		block, err := node.ProduceBlock()
+		if err != nil {
+			log.Fatal("produce block failed:", err)
+		}
+		return block
+	}
+
+	// the sender spelled in upper case is the same sender with the same nonces
+	submit(3, 5, true)
+	submit(4, 5, false)
+	if block := produce(); block == nil || block.TxCount != 2 {
+		log.Fatalf("mixed case transfers of one sender were not mined together: %+v", block)
+	}
+	log.Println("mixed case sender mined in one block")
+
+	// a failed transfer leaves its nonce unused, the transfers behind it are dropped
+	submit(5, 1000, false)
+	behind := submit(6, 1, false)
+	if block := produce(); block == nil || block.TxCount != 1 || node.PendingCount() != 0 {
+		log.Fatalf("transfer behind a failed one was not dropped: block %+v, %d pending", block, node.PendingCount())
+	}
+	if _, err := node.Receipt(behind); !errors.Is(err, chain.ErrReceiptNotFound) {
+		log.Fatal("transfer behind a failed one was mined:", err)
+	}
+	log.Println("transfer behind a failed nonce dropped")
+
+	// a transfer waiting for a nonce that never arrives expires
+	submit(7, 1, false)
+	if block := produce(); block != nil || node.PendingCount() != 1 {
+		log.Fatalf("transfer waiting for nonce 5 was mined or dropped early: block %+v, %d pending", block, node.PendingCount())
+	}
+	now = now.Add(node.PendingTTL + time.Second)
+	if produce(); node.PendingCount() != 0 {
+		log.Fatal("transfer waiting for a missing nonce did not expire")
+	}
+	log.Println("transfer waiting for a missing nonce expired")
+}
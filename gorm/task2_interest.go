@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/config"
+)
+
+// interest job settings, the funding account pays the interest of every other active account
+var interestPolicy = bank.InterestPolicy{
+	AnnualRate:       1.5,
+	DaysInYear:       365,
+	FundingAccountID: 1,
+	MinBalance:       100,
+}
+
+// daily interest accrual job, run it once a day (e.g. from cron shortly after midnight);
+// running it again for the same day does not post anything twice
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	// accrue the day that just ended
+	day := time.Now().AddDate(0, 0, -1)
+	report, err := bank.AccrueInterest(db, day, interestPolicy)
+	if err != nil {
+		log.Fatal("accrue interest failed:", err)
+	}
+	log.Printf("interest accrued for %s: %d accounts, %d already done, %.2f posted",
+		report.Day, report.Accrued, report.Skipped, report.TotalPosted)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/config"
+	"github.com/test/init_project/hdwallet"
+	"gorm.io/gorm"
+)
+
+// key management command, passphrases are read from the environment so they stay out of the shell history:
+//
+//	go run task2_keys.go mnemonic                        print a new 24 word mnemonic
+//	go run task2_keys.go derive -first 0 -count 3        derive accounts from $HD_MNEMONIC into the keystore and link them
+//	go run task2_keys.go list                            list the addresses in the keystore
+//	go run task2_keys.go export -address <hex> > k.json  print an encrypted key file
+//	go run task2_keys.go import -file k.json             import a key file encrypted with $KEYSTORE_OLD_PASSPHRASE and link it
+//
+// environment: HD_MNEMONIC, HD_PASSPHRASE (optional BIP-39 passphrase), KEYSTORE_PASSPHRASE, KEYSTORE_OLD_PASSPHRASE
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal("usage: task2_keys.go mnemonic|derive|list|export|import [flags]")
+	}
+	command, args := os.Args[1], os.Args[2:]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	dir := flags.String("keystore", "keystore", "keystore directory")
+	light := flags.Bool("light", false, "use light scrypt parameters (for testing only)")
+	first := flags.Uint("first", 0, "first account index to derive")
+	count := flags.Uint("count", 1, "number of accounts to derive")
+	address := flags.String("address", "", "address to export")
+	file := flags.String("file", "", "key file to import")
+	flags.Parse(args)
+
+	ks := hdwallet.NewKeyStore(*dir)
+	if *light {
+		ks.ScryptN, ks.ScryptP = hdwallet.LightScryptN, hdwallet.LightScryptP
+	}
+
+	switch command {
+	case "mnemonic":
+		mnemonic, err := hdwallet.NewMnemonic()
+		if err != nil {
+			log.Fatal("generate mnemonic failed:", err)
+		}
+		fmt.Println(mnemonic)
+
+	case "derive":
+		seed, err := hdwallet.SeedFromMnemonic(os.Getenv("HD_MNEMONIC"), os.Getenv("HD_PASSPHRASE"))
+		if err != nil {
+			log.Fatal("read mnemonic failed:", err)
+		}
+		db := migratedDB()
+		accounts, err := hdwallet.DeriveAccounts(db, ks, seed, uint32(*first), uint32(*count), passphrase("KEYSTORE_PASSPHRASE"))
+		if err != nil {
+			log.Fatal("derive accounts failed:", err)
+		}
+		for i, account := range accounts {
+			log.Printf("%s -> account %d, address %s", hdwallet.AccountPath(uint32(*first)+uint32(i)), account.ID, *account.PublicKey)
+		}
+
+	case "list":
+		addresses, err := ks.List()
+		if err != nil {
+			log.Fatal("list keystore failed:", err)
+		}
+		for _, a := range addresses {
+			f, err := ks.Read(a)
+			if err != nil {
+				log.Fatal("read key file failed:", err)
+			}
+			fmt.Printf("%s %s\n", f.Address, f.Path)
+		}
+
+	case "export":
+		content, err := ks.Export(*address)
+		if err != nil {
+			log.Fatal("export key failed:", err)
+		}
+		fmt.Println(string(content))
+
+	case "import":
+		content, err := os.ReadFile(*file)
+		if err != nil {
+			log.Fatal("read key file failed:", err)
+		}
+		imported, err := ks.Import(content, passphrase("KEYSTORE_OLD_PASSPHRASE"), passphrase("KEYSTORE_PASSPHRASE"))
+		if err != nil {
+			log.Fatal("import key failed:", err)
+		}
+		f, err := ks.Read(imported)
+		if err != nil {
+			log.Fatal("read key file failed:", err)
+		}
+		key, err := hex.DecodeString(imported)
+		if err != nil {
+			log.Fatal("decode address failed:", err)
+		}
+		account, err := hdwallet.LinkAccount(migratedDB(), ed25519.PublicKey(key), f.Path)
+		if err != nil {
+			log.Fatal("link account failed:", err)
+		}
+		log.Printf("imported %s as account %d", imported, account.ID)
+
+	default:
+		log.Fatalf("unknown command %q", command)
+	}
+}
+
+// passphrase reads a required passphrase from the environment
+func passphrase(name string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		log.Fatalf("%s is not set", name)
+	}
+	return value
+}
+
+// migratedDB connects to the database and makes sure the wallet tables exist
+func migratedDB() *gorm.DB {
+	// use global config to get database connection
+	db := config.GetDB()
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+	if err := hdwallet.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+	return db
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/config"
+)
+
+// ledger verification command:
+//
+//	go run task2_ledger.go        walk the hash chain and the blocks, exit 1 on the first tampered record
+//	go run task2_ledger.go <id>   print the inclusion proof of transaction <id>
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	if len(os.Args) > 1 {
+		id, err := strconv.ParseUint(os.Args[1], 10, 64)
+		if err != nil {
+			log.Fatal("invalid transaction id:", os.Args[1])
+		}
+		proof, err := bank.ProveTransaction(db, uint(id))
+		if err != nil {
+			log.Fatal("prove transaction failed:", err)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(proof); err != nil {
+			log.Fatal("write proof failed:", err)
+		}
+		log.Printf("proof valid: %t", proof.Verify())
+		return
+	}
+
+	report, err := bank.VerifyLedger(db)
+	if err != nil {
+		log.Fatal("verify ledger failed:", err)
+	}
+	if !report.OK() {
+		log.Printf("ledger tampered, first bad record: %s", report.Tampered)
+		os.Exit(1)
+	}
+	log.Printf("ledger intact: %d transactions, %d blocks, %d legacy transactions not chained",
+		report.Checked, report.Blocks, report.Legacy)
+}
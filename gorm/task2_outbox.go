@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/config"
+)
+
+// webhookReceiver is a local endpoint that drops redelivered events by their id
+type webhookReceiver struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (h *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg bank.OutboxMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.seen[msg.EventID] {
+		log.Printf("webhook: duplicate event %s ignored", msg.EventID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	h.seen[msg.EventID] = true
+	log.Printf("webhook: #%d %s %s", msg.Sequence, msg.Type, msg.Payload)
+	w.WriteHeader(http.StatusOK)
+}
+
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	// local webhook endpoint
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal("listen failed:", err)
+	}
+	go http.Serve(listener, &webhookReceiver{seen: make(map[string]bool)})
+	url := "http://" + listener.Addr().String() + "/events"
+
+	// a few transfers, each one writes its outbox event in the same database transaction
+	accounts := []bank.Account{{Balance: 1000}, {Balance: 0}}
+	if err := db.Create(&accounts).Error; err != nil {
+		log.Fatal("create accounts failed:", err)
+	}
+	for _, amount := range []float64{10, 20, 30} {
+		if err := bank.TransferMoney(db, accounts[0].ID, accounts[1].ID, amount); err != nil {
+			log.Fatal("transfer money failed:", err)
+		}
+	}
+
+	// deliver everything that is still unsent
+	relay := bank.NewOutboxRelay(db, bank.NewWebhookSink(url))
+	for {
+		sent, err := relay.RelayOnce(context.Background())
+		if err != nil {
+			log.Fatal("relay outbox failed:", err)
+		}
+		log.Printf("relayed %d events", sent)
+		if sent < relay.BatchSize {
+			break
+		}
+	}
+}
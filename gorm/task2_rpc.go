@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/chain"
+	"github.com/test/init_project/config"
+	"github.com/test/init_project/ethrpc"
+)
+
+// address of the local JSON-RPC endpoint
+const rpcAddr = "127.0.0.1:8545"
+
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+	if err := chain.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	node, err := chain.NewNode(db)
+	if err != nil {
+		log.Fatal("start node failed:", err)
+	}
+	go node.Run(context.Background(), time.Second)
+
+	// a funded wallet and a signed transfer to try eth_sendRawTransaction with
+	senderPub, senderKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		log.Fatal("generate key failed:", err)
+	}
+	receiverPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		log.Fatal("generate key failed:", err)
+	}
+	if _, err := bank.CreateWallet(db, senderPub, 100); err != nil {
+		log.Fatal("create wallet failed:", err)
+	}
+	if _, err := bank.CreateWallet(db, receiverPub, 0); err != nil {
+		log.Fatal("create wallet failed:", err)
+	}
+	transfer, err := bank.SignTransfer(senderKey, hex.EncodeToString(receiverPub), 12.5, 0)
+	if err != nil {
+		log.Fatal("sign transfer failed:", err)
+	}
+	raw, err := ethrpc.EncodeRawTransaction(transfer)
+	if err != nil {
+		log.Fatal("encode transaction failed:", err)
+	}
+
+	log.Printf("JSON-RPC endpoint: http://%s", rpcAddr)
+	log.Printf("sender address: 0x%s", transfer.From)
+	log.Printf(`try: curl -s -X POST http://%s -d '{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["%s"]}'`, rpcAddr, raw)
+	log.Printf(`then: curl -s -X POST http://%s -d '{"jsonrpc":"2.0","id":2,"method":"eth_getBalance","params":["0x%s","latest"]}'`, rpcAddr, transfer.From)
+	log.Fatal(http.ListenAndServe(rpcAddr, ethrpc.NewServer(db, node)))
+}
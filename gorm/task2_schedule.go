@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/config"
+)
+
+// scheduler demo settings
+const (
+	scheduleRunners  = 3
+	scheduleDuration = 5 * time.Second
+	schedulePoll     = 200 * time.Millisecond
+)
+
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	// a landlord and a tenant paying rent every second for the demo
+	accounts := []bank.Account{{Balance: 1000}, {Balance: 0}}
+	if err := db.Create(&accounts).Error; err != nil {
+		log.Fatal("create accounts failed:", err)
+	}
+	tenant, landlord := accounts[0].ID, accounts[1].ID
+
+	rent := bank.ScheduledTransfer{
+		FromAccountID:   tenant,
+		ToAccountID:     landlord,
+		Amount:          100,
+		IntervalSeconds: 1,
+		OnFailure:       bank.OnFailureSkip,
+	}
+	start := time.Now().Truncate(time.Second).Add(time.Second)
+	if err := bank.CreateScheduledTransfer(db, &rent, start); err != nil {
+		log.Fatal("create scheduled transfer failed:", err)
+	}
+	log.Printf("scheduled transfer %d created, first run at %s", rent.ID, rent.NextRunAt.Format(time.RFC3339))
+
+	// several runners poll the same table, every occurrence must still be paid once
+	ctx, cancel := context.WithTimeout(context.Background(), scheduleDuration)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < scheduleRunners; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bank.NewTransferRunner(db).Run(ctx, schedulePoll)
+		}()
+	}
+	wg.Wait()
+
+	schedule, runs, err := bank.GetScheduledTransfer(db, rent.ID)
+	if err != nil {
+		log.Fatal("query scheduled transfer failed:", err)
+	}
+	var paid int64
+	if err := db.Model(&bank.Transaction{}).
+		Where("from_account_id = ? AND to_account_id = ?", tenant, landlord).
+		Count(&paid).Error; err != nil {
+		log.Fatal("query transactions failed:", err)
+	}
+	log.Printf("schedule status: %s, successful runs: %d, attempts recorded: %d, transactions: %d",
+		schedule.Status, schedule.RunCount, len(runs), paid)
+	if int64(schedule.RunCount) != paid {
+		log.Fatal("scheduled transfer executed more or less than once per occurrence")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/config"
+)
+
+// stress test settings
+const (
+	stressAccounts       = 10
+	stressInitialBalance = 1000.0
+	stressTransfers      = 5000
+	stressWorkers        = 32
+	stressMaxAmountCents = 5000
+)
+
+// createStressAccounts creates fresh accounts for this run and returns their ids
+func createStressAccounts(db *gorm.DB) ([]uint, error) {
+	accounts := make([]bank.Account, stressAccounts)
+	for i := range accounts {
+		accounts[i].Balance = stressInitialBalance
+	}
+	if err := db.Create(&accounts).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(accounts))
+	for i, account := range accounts {
+		ids[i] = account.ID
+	}
+	return ids, nil
+}
+
+// toCents converts a decimal(10,2) amount to integer cents
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// checkConservation verifies that no money was created or lost: the sum of balances is unchanged
+// and every account equals its initial balance plus incoming minus outgoing transactions
+func checkConservation(db *gorm.DB, ids []uint, startID uint) error {
+	var accounts []bank.Account
+	if err := db.Where("id IN ?", ids).Find(&accounts).Error; err != nil {
+		return err
+	}
+	var transactions []bank.Transaction
+	if err := db.Where("id > ? AND (from_account_id IN ? OR to_account_id IN ?)", startID, ids, ids).
+		Find(&transactions).Error; err != nil {
+		return err
+	}
+
+	expected := make(map[uint]int64, len(ids))
+	for _, id := range ids {
+		expected[id] = toCents(stressInitialBalance)
+	}
+	for _, t := range transactions {
+		expected[t.FromAccountID] -= toCents(t.Amount)
+		expected[t.ToAccountID] += toCents(t.Amount)
+	}
+
+	var total int64
+	for _, account := range accounts {
+		balance := toCents(account.Balance)
+		total += balance
+		if balance < 0 {
+			return errors.New("negative balance detected")
+		}
+		if balance != expected[account.ID] {
+			log.Printf("account %d: balance %d cents, expected %d cents", account.ID, balance, expected[account.ID])
+			return errors.New("account balance does not match transaction history")
+		}
+	}
+	if want := toCents(stressInitialBalance) * stressAccounts; total != want {
+		log.Printf("total %d cents, expected %d cents", total, want)
+		return errors.New("total money is not conserved")
+	}
+	return nil
+}
+
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	// remember where the transaction log was before this run
+	var startID uint
+	if err := db.Model(&bank.Transaction{}).Select("COALESCE(MAX(id), 0)").Scan(&startID).Error; err != nil {
+		log.Fatal("query transaction log failed:", err)
+	}
+
+	ids, err := createStressAccounts(db)
+	if err != nil {
+		log.Fatal("create stress accounts failed:", err)
+	}
+
+	var succeeded, rejected, failed int64
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	begin := time.Now()
+	for w := 0; w < stressWorkers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for range jobs {
+				from := ids[rnd.Intn(len(ids))]
+				to := ids[rnd.Intn(len(ids))]
+				for to == from {
+					to = ids[rnd.Intn(len(ids))]
+				}
+				amount := float64(rnd.Intn(stressMaxAmountCents)+1) / 100
+				// mix both locking strategies, they must stay correct against each other
+				mode := bank.LockPessimistic
+				if rnd.Intn(2) == 0 {
+					mode = bank.LockOptimistic
+				}
+
+				err := bank.TransferMoney(db, from, to, amount, bank.WithLockMode(mode))
+				switch {
+				case err == nil:
+					atomic.AddInt64(&succeeded, 1)
+				case errors.Is(err, bank.ErrInsufficientFunds):
+					atomic.AddInt64(&rejected, 1)
+				default:
+					atomic.AddInt64(&failed, 1)
+					log.Printf("transfer %d -> %d failed: %v", from, to, err)
+				}
+			}
+		}(time.Now().UnixNano() + int64(w))
+	}
+	for i := 0; i < stressTransfers; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+
+	log.Printf("%d transfers in %v: %d succeeded, %d rejected for insufficient funds, %d failed",
+		stressTransfers, time.Since(begin), succeeded, rejected, failed)
+
+	if err := checkConservation(db, ids, startID); err != nil {
+		log.Fatal("stress test failed: ", err)
+	}
+	if failed > 0 {
+		log.Fatalf("stress test failed: %d transfers returned unexpected errors", failed)
+	}
+	log.Println("stress test passed, total money is conserved")
+}
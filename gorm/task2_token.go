@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/config"
+)
+
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	// issuer, holder and an exchange acting as spender
+	accounts := []bank.Account{{}, {}, {}}
+	if err := db.Create(&accounts).Error; err != nil {
+		log.Fatal("create accounts failed:", err)
+	}
+	issuer, holder, exchange := accounts[0].ID, accounts[1].ID, accounts[2].ID
+
+	// 1,000,000.00 tokens with 2 decimals, minted to the issuer
+	token, err := bank.CreateToken(db, "DEMO", "Demo token", 2, issuer, 100_000_000)
+	if err != nil {
+		log.Fatal("create token failed:", err)
+	}
+
+	if err := bank.TransferToken(db, token.ID, issuer, holder, 50_000); err != nil {
+		log.Fatal("transfer token failed:", err)
+	}
+
+	// the holder lets the exchange move up to 100.00 tokens
+	if err := bank.ApproveToken(db, token.ID, holder, exchange, 10_000); err != nil {
+		log.Fatal("approve token failed:", err)
+	}
+	if err := bank.TransferTokenFrom(db, token.ID, exchange, holder, issuer, 7_500); err != nil {
+		log.Fatal("transfer token from failed:", err)
+	}
+	if err := bank.TransferTokenFrom(db, token.ID, exchange, holder, issuer, 7_500); errors.Is(err, bank.ErrInsufficientTokenAllowance) {
+		log.Println("second spend rejected:", err)
+	} else {
+		log.Fatal("second spend was not rejected:", err)
+	}
+
+	// account 0 only takes part in mints and burns, a transfer cannot create or destroy tokens
+	if err := bank.TransferToken(db, token.ID, 0, holder, 1_000); errors.Is(err, bank.ErrInvalidTokenAccount) {
+		log.Println("transfer from account 0 rejected:", err)
+	} else {
+		log.Fatal("transfer from account 0 was not rejected:", err)
+	}
+	if err := bank.TransferToken(db, token.ID, holder, 0, 1_000); errors.Is(err, bank.ErrInvalidTokenAccount) {
+		log.Println("transfer to account 0 rejected:", err)
+	} else {
+		log.Fatal("transfer to account 0 was not rejected:", err)
+	}
+	if err := bank.ApproveToken(db, token.ID, holder, 0, 1_000); errors.Is(err, bank.ErrInvalidTokenAccount) {
+		log.Println("approval for account 0 rejected:", err)
+	} else {
+		log.Fatal("approval for account 0 was not rejected:", err)
+	}
+	if err := bank.MintToken(db, token.ID, issuer, 0, 1_000); errors.Is(err, bank.ErrInvalidTokenAccount) {
+		log.Println("mint to account 0 rejected:", err)
+	} else {
+		log.Fatal("mint to account 0 was not rejected:", err)
+	}
+
+	// only the issuer may change the supply
+	if err := bank.MintToken(db, token.ID, holder, holder, 1); errors.Is(err, bank.ErrNotTokenOwner) {
+		log.Println("mint by holder rejected:", err)
+	} else {
+		log.Fatal("mint by holder was not rejected:", err)
+	}
+	if err := bank.BurnToken(db, token.ID, issuer, 1_000_000); err != nil {
+		log.Fatal("burn token failed:", err)
+	}
+
+	token, err = bank.GetToken(db, token.ID)
+	if err != nil {
+		log.Fatal("get token failed:", err)
+	}
+	log.Printf("%s total supply: %s", token.Symbol, bank.FormatTokenAmount(token.TotalSupply, token.Decimals))
+	for _, id := range []uint{issuer, holder, exchange} {
+		balance, err := bank.TokenBalanceOf(db, token.ID, id)
+		if err != nil {
+			log.Fatal("get token balance failed:", err)
+		}
+		log.Printf("account %d: %s %s", id, bank.FormatTokenAmount(balance, token.Decimals), token.Symbol)
+	}
+}
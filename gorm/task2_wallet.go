@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/test/init_project/bank"
+	"github.com/test/init_project/config"
+)
+
+func main() {
+	// use global config to get database connection
+	db := config.GetDB()
+
+	if err := bank.AutoMigrate(db); err != nil {
+		log.Fatal("table structure migration failed:", err)
+	}
+
+	// two wallets, alice holds the private key of the sender
+	alicePub, alicePriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		log.Fatal("generate key failed:", err)
+	}
+	bobPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		log.Fatal("generate key failed:", err)
+	}
+	alice, err := bank.CreateWallet(db, alicePub, 100)
+	if err != nil {
+		log.Fatal("create wallet failed:", err)
+	}
+	if _, err := bank.CreateWallet(db, bobPub, 0); err != nil {
+		log.Fatal("create wallet failed:", err)
+	}
+	bobAddress := hex.EncodeToString(bobPub)
+
+	// sign with the current nonce of alice and submit
+	transfer, err := bank.SignTransfer(alicePriv, bobAddress, 25, alice.Nonce)
+	if err != nil {
+		log.Fatal("sign transfer failed:", err)
+	}
+	transaction, err := bank.TransferSigned(db, transfer)
+	if err != nil {
+		log.Fatal("signed transfer failed:", err)
+	}
+	log.Printf("signed transfer success, transaction id: %d, nonce: %d", transaction.ID, *transaction.Nonce)
+
+	// replaying the same signed transfer is rejected
+	if _, err := bank.TransferSigned(db, transfer); errors.Is(err, bank.ErrInvalidNonce) {
+		log.Println("replay rejected:", err)
+	} else {
+		log.Fatal("replay was not rejected:", err)
+	}
+
+	// changing the amount breaks the signature
+	tampered := transfer
+	tampered.Amount = 50
+	tampered.Nonce++
+	if _, err := bank.TransferSigned(db, tampered); errors.Is(err, bank.ErrInvalidSignature) {
+		log.Println("tampered transfer rejected:", err)
+	} else {
+		log.Fatal("tampered transfer was not rejected:", err)
+	}
+
+	// hex addresses may be written in upper case, they name the same wallets
+	upper, err := bank.SignTransfer(alicePriv, strings.ToUpper(bobAddress), 5, alice.Nonce+1)
+	if err != nil {
+		log.Fatal("sign transfer failed:", err)
+	}
+	upper.From = strings.ToUpper(upper.From)
+	transaction, err = bank.TransferSigned(db, upper)
+	if err != nil {
+		log.Fatal("signed transfer to an upper case address failed:", err)
+	}
+	log.Printf("upper case addresses accepted, transaction id: %d, nonce: %d", transaction.ID, *transaction.Nonce)
+	if _, err := bank.FindWallet(db, strings.ToUpper(bobAddress)); err != nil {
+		log.Fatal("find wallet by upper case address failed:", err)
+	}
+
+	// money only leaves a wallet through a transfer its key signed, whatever rules the caller picks
+	bob, err := bank.FindWallet(db, bobAddress)
+	if err != nil {
+		log.Fatal("find wallet failed:", err)
+	}
+	if err := bank.TransferMoney(db, alice.ID, bob.ID, 10); errors.Is(err, bank.ErrUnsignedDebit) {
+		log.Println("unsigned transfer rejected:", err)
+	} else {
+		log.Fatal("unsigned transfer was not rejected:", err)
+	}
+	_, err = bank.BatchTransfer(db, bank.BatchRequest{Legs: []bank.TransferLeg{{FromAccountID: alice.ID, ToAccountID: bob.ID, Amount: 10}}},
+		bank.WithRuleEngine(bank.NewRuleEngine()))
+	if errors.Is(err, bank.ErrUnsignedDebit) {
+		log.Println("unsigned batch rejected:", err)
+	} else {
+		log.Fatal("unsigned batch was not rejected:", err)
+	}
+}
@@ -0,0 +1,73 @@
+package hdwallet
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/test/init_project/bank"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// KeyRecord links a wallet account to where its key comes from, it never holds key material
+type KeyRecord struct {
+	AccountID uint   `gorm:"primaryKey;autoIncrement:false"`
+	Address   string `gorm:"size:64;uniqueIndex"`
+	Path      string `gorm:"size:64"` // derivation path, empty for imported keys without one
+	CreatedAt time.Time
+}
+
+// AutoMigrate creates or updates the tables used by the hdwallet package
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(&KeyRecord{})
+}
+
+// LinkAccount returns the wallet account of key, creating it with a zero balance when it does
+// not exist yet, and records the derivation path of the key
+func LinkAccount(db *gorm.DB, key ed25519.PublicKey, path string) (*bank.Account, error) {
+	var account *bank.Account
+	err := db.Transaction(func(tx *gorm.DB) error {
+		address := hex.EncodeToString(key)
+		var err error
+		account, err = bank.FindWallet(tx, address)
+		if errors.Is(err, bank.ErrWalletNotFound) {
+			account, err = bank.CreateWallet(tx, key, 0)
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&KeyRecord{
+			AccountID: account.ID,
+			Address:   address,
+			Path:      path,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// DeriveAccounts derives count consecutive BIP-44 accounts starting at index first, stores each key
+// in ks encrypted with passphrase and links it to its wallet account
+func DeriveAccounts(db *gorm.DB, ks *KeyStore, seed []byte, first, count uint32, passphrase string) ([]bank.Account, error) {
+	accounts := make([]bank.Account, 0, count)
+	for n := first; n < first+count; n++ {
+		path := AccountPath(n)
+		key, err := DeriveKey(seed, path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := ks.Store(key, path, passphrase); err != nil {
+			return nil, err
+		}
+		account, err := LinkAccount(db, key.Public().(ed25519.PublicKey), path)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *account)
+	}
+	return accounts, nil
+}
@@ -0,0 +1,72 @@
+package hdwallet
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPath is returned for derivation paths that cannot be parsed or used
+var ErrInvalidPath = errors.New("hdwallet: invalid derivation path")
+
+// hardenedOffset is added to the index of hardened path elements
+const hardenedOffset = 0x80000000
+
+// CoinType is the BIP-44 coin type of ledger wallets, 1 is reserved for test networks
+const CoinType = 1
+
+// AccountPath returns the BIP-44 path of the n-th ledger account: m/44'/1'/n'/0'/0'.
+// Every level is hardened because ed25519 derivation has no public child keys
+func AccountPath(n uint32) string {
+	return fmt.Sprintf("m/44'/%d'/%d'/0'/0'", CoinType, n)
+}
+
+// ParsePath parses a path like m/44'/1'/0'/0'/0' into child indexes, all elements must be hardened
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		if !strings.HasSuffix(part, "'") && !strings.HasSuffix(part, "h") {
+			return nil, fmt.Errorf("%w: %q is not hardened, ed25519 only supports hardened derivation", ErrInvalidPath, part)
+		}
+		n, err := strconv.ParseUint(part[:len(part)-1], 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, part)
+		}
+		indexes = append(indexes, uint32(n)+hardenedOffset)
+	}
+	return indexes, nil
+}
+
+// DeriveKey derives the ed25519 key at path from a BIP-39 seed. BIP-32 itself is defined for
+// secp256k1, ed25519 keys are derived with its SLIP-0010 variant (hardened children only)
+func DeriveKey(seed []byte, path string) (ed25519.PrivateKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha512.New, []byte("ed25519 seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	key, chainCode := sum[:32], sum[32:]
+
+	for _, index := range indexes {
+		data := make([]byte, 0, 1+32+4)
+		data = append(data, 0)
+		data = append(data, key...)
+		data = binary.BigEndian.AppendUint32(data, index)
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		key, chainCode = sum[:32], sum[32:]
+	}
+	return ed25519.NewKeyFromSeed(key), nil
+}
@@ -0,0 +1,303 @@
+package hdwallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// errors of the keystore
+var (
+	ErrKeyNotFound     = errors.New("hdwallet: key not found")
+	ErrWrongPassphrase = errors.New("hdwallet: wrong passphrase or corrupted key file")
+	ErrInvalidKeyFile  = errors.New("hdwallet: invalid key file")
+)
+
+// scrypt cost parameters, the standard ones take about a second per key on a laptop
+const (
+	StandardScryptN = 1 << 18
+	StandardScryptP = 1
+	LightScryptN    = 1 << 12
+	LightScryptP    = 6
+	scryptR         = 8
+	scryptKeyLen    = 32
+	// maxScryptN bounds the cost of a key file, with r = 8 it takes 1 GiB of memory per derivation
+	maxScryptN = 1 << 20
+)
+
+// keyFileVersion is the version of the key file format
+const keyFileVersion = 1
+
+// KeyFile is the JSON document of an encrypted key. The ed25519 seed is encrypted with AES-256-GCM
+// under a key derived from the passphrase with scrypt, the address is authenticated as additional data
+type KeyFile struct {
+	Version int        `json:"version"`
+	Address string     `json:"address"` // hex ed25519 public key, the same as Account.PublicKey
+	Path    string     `json:"path,omitempty"`
+	Crypto  CryptoJSON `json:"crypto"`
+}
+
+// CryptoJSON holds the cipher and kdf parameters of a KeyFile
+type CryptoJSON struct {
+	Cipher     string       `json:"cipher"`
+	CipherText string       `json:"ciphertext"`
+	Nonce      string       `json:"nonce"`
+	KDF        string       `json:"kdf"`
+	KDFParams  ScryptParams `json:"kdfparams"`
+}
+
+// ScryptParams are the scrypt parameters of a KeyFile
+type ScryptParams struct {
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	KeyLen int    `json:"dklen"`
+	Salt   string `json:"salt"`
+}
+
+// checkScrypt rejects scrypt parameters that are invalid or cost more memory or time than
+// N = maxScryptN with the standard r and p = 1, a key file can name any values
+func checkScrypt(n, r, p int) error {
+	if n <= 1 || n > maxScryptN || n&(n-1) != 0 {
+		return fmt.Errorf("scrypt N %d must be a power of two from 2 to %d", n, maxScryptN)
+	}
+	if r <= 0 || p <= 0 || uint64(r)*uint64(p) >= 1<<30 {
+		return fmt.Errorf("scrypt r %d and p %d must be positive with r*p below 2^30", r, p)
+	}
+	if uint64(n)*uint64(r)*uint64(p) > maxScryptN*scryptR {
+		return fmt.Errorf("scrypt N %d, r %d and p %d cost more than N %d, r %d and p 1", n, r, p, maxScryptN, scryptR)
+	}
+	return nil
+}
+
+// EncryptKey encrypts key with passphrase, path is the derivation path recorded in the file
+func EncryptKey(key ed25519.PrivateKey, path, passphrase string, scryptN, scryptP int) (*KeyFile, error) {
+	if err := checkScrypt(scryptN, scryptR, scryptP); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	derived, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(derived)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	address := hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	ciphertext := gcm.Seal(nil, nonce, key.Seed(), []byte(address))
+	return &KeyFile{
+		Version: keyFileVersion,
+		Address: address,
+		Path:    path,
+		Crypto: CryptoJSON{
+			Cipher:     "aes-256-gcm",
+			CipherText: hex.EncodeToString(ciphertext),
+			Nonce:      hex.EncodeToString(nonce),
+			KDF:        "scrypt",
+			KDFParams: ScryptParams{
+				N:      scryptN,
+				R:      scryptR,
+				P:      scryptP,
+				KeyLen: scryptKeyLen,
+				Salt:   hex.EncodeToString(salt),
+			},
+		},
+	}, nil
+}
+
+// DecryptKey decrypts a key file, a wrong passphrase returns ErrWrongPassphrase
+func DecryptKey(f *KeyFile, passphrase string) (ed25519.PrivateKey, error) {
+	c := f.Crypto
+	if f.Version != keyFileVersion || c.Cipher != "aes-256-gcm" || c.KDF != "scrypt" || c.KDFParams.KeyLen != scryptKeyLen {
+		return nil, ErrInvalidKeyFile
+	}
+	salt, err1 := hex.DecodeString(c.KDFParams.Salt)
+	nonce, err2 := hex.DecodeString(c.Nonce)
+	ciphertext, err3 := hex.DecodeString(c.CipherText)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyFile, err)
+	}
+	if err := checkScrypt(c.KDFParams.N, c.KDFParams.R, c.KDFParams.P); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyFile, err)
+	}
+	derived, err := scrypt.Key([]byte(passphrase), salt, c.KDFParams.N, c.KDFParams.R, c.KDFParams.P, c.KDFParams.KeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyFile, err)
+	}
+	gcm, err := newGCM(derived)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, ErrInvalidKeyFile
+	}
+	seed, err := gcm.Open(nil, nonce, ciphertext, []byte(f.Address))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, ErrInvalidKeyFile
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	if hex.EncodeToString(key.Public().(ed25519.PublicKey)) != f.Address {
+		return nil, ErrInvalidKeyFile
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeyStore keeps one encrypted key file per address in a directory
+type KeyStore struct {
+	dir     string
+	ScryptN int
+	ScryptP int
+}
+
+// NewKeyStore creates a keystore in dir with the standard scrypt parameters
+func NewKeyStore(dir string) *KeyStore {
+	return &KeyStore{dir: dir, ScryptN: StandardScryptN, ScryptP: StandardScryptP}
+}
+
+func (ks *KeyStore) file(address string) string {
+	return filepath.Join(ks.dir, strings.ToLower(address)+".json")
+}
+
+// write stores a key file, readable only by the current user
+func (ks *KeyStore) write(f *KeyFile) error {
+	if err := os.MkdirAll(ks.dir, 0o700); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash never leaves a half written key behind
+	tmp, err := os.CreateTemp(ks.dir, ".key-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ks.file(f.Address))
+}
+
+// Store encrypts key with passphrase and saves it, returning its address
+func (ks *KeyStore) Store(key ed25519.PrivateKey, path, passphrase string) (string, error) {
+	f, err := EncryptKey(key, path, passphrase, ks.ScryptN, ks.ScryptP)
+	if err != nil {
+		return "", err
+	}
+	return f.Address, ks.write(f)
+}
+
+// Read loads the key file of address without decrypting it
+func (ks *KeyStore) Read(address string) (*KeyFile, error) {
+	if b, err := hex.DecodeString(address); err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, address)
+	}
+	content, err := os.ReadFile(ks.file(address))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f KeyFile
+	if err := json.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyFile, err)
+	}
+	return &f, nil
+}
+
+// Load decrypts the key of address
+func (ks *KeyStore) Load(address, passphrase string) (ed25519.PrivateKey, error) {
+	f, err := ks.Read(address)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptKey(f, passphrase)
+}
+
+// List returns the stored addresses in order
+func (ks *KeyStore) List() ([]string, error) {
+	entries, err := os.ReadDir(ks.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var addresses []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		addresses = append(addresses, strings.TrimSuffix(name, ".json"))
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// Export returns the encrypted key file of address, it can only be used with the passphrase
+func (ks *KeyStore) Export(address string) ([]byte, error) {
+	f, err := ks.Read(address)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// Import checks that an exported key file decrypts with passphrase and stores it,
+// re-encrypted with newPassphrase and the scrypt parameters of this keystore
+func (ks *KeyStore) Import(keyJSON []byte, passphrase, newPassphrase string) (string, error) {
+	var f KeyFile
+	if err := json.Unmarshal(keyJSON, &f); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidKeyFile, err)
+	}
+	key, err := DecryptKey(&f, passphrase)
+	if err != nil {
+		return "", err
+	}
+	return ks.Store(key, f.Path, newPassphrase)
+}
+
+// Delete removes the key of address after checking the passphrase
+func (ks *KeyStore) Delete(address, passphrase string) error {
+	if _, err := ks.Load(address, passphrase); err != nil {
+		return err
+	}
+	return os.Remove(ks.file(address))
+}
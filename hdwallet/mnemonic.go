@@ -0,0 +1,28 @@
+package hdwallet
+
+import (
+	"errors"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// ErrInvalidMnemonic is returned for phrases that are not valid BIP-39 mnemonics
+var ErrInvalidMnemonic = errors.New("hdwallet: invalid mnemonic")
+
+// NewMnemonic generates a BIP-39 english mnemonic of 24 words (256 bits of entropy)
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(256)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// SeedFromMnemonic checks the mnemonic (words and checksum) and derives the 64 byte BIP-39 seed,
+// passphrase is the optional BIP-39 passphrase ("25th word")
+func SeedFromMnemonic(mnemonic, passphrase string) ([]byte, error) {
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, ErrInvalidMnemonic
+	}
+	return bip39.NewSeed(mnemonic, passphrase), nil
+}